@@ -0,0 +1,78 @@
+// small http server for operators, only meant to be reachable from the host (WORKER_ADMIN_ADDR)
+// /metrics                      prometheus metrics of the worker
+// /logs/<deploymentId>          json list of the stored build logs, newest first
+// /logs/<deploymentId>/<build>  one build log as text, "latest" for the newest
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"worker/internal/buildlog"
+	"worker/internal/config"
+	"worker/internal/metrics"
+)
+
+func adminServer() {
+	if config.Current.AdminAddr == "" || config.Current.AdminAddr == "off" {
+		log.Println("ℹ️ Admin server disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/logs/", serveBuildLogs)
+
+	log.Printf("📊 Admin server listening on %s", config.Current.AdminAddr)
+	if err := http.ListenAndServe(config.Current.AdminAddr, mux); err != nil {
+		log.Printf("❌ Admin server stopped: %v", err)
+	}
+}
+
+func serveBuildLogs(w http.ResponseWriter, r *http.Request) {
+	deploymentID, build, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/logs/"), "/")
+
+	if build == "" {
+		entries, err := buildlog.List(deploymentID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if entries == nil {
+			entries = []buildlog.Entry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	var buildID int64 // 0 is the latest
+	if build != "latest" {
+		id, err := strconv.ParseInt(build, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid build id", http.StatusBadRequest)
+			return
+		}
+		buildID = id
+	}
+
+	file, _, err := buildlog.Open(deploymentID, buildID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, file)
+}
@@ -3,10 +3,21 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 	"worker/internal/builder"
+	"worker/internal/buildlog"
+	"worker/internal/config"
+	"worker/internal/policy"
 	"worker/internal/queue"
+	"worker/internal/secrets"
 	"worker/internal/store"
 	"worker/internal/tracker"
 )
@@ -14,30 +25,211 @@ import (
 const maxConcurrentBuilds = 2 // this is the max builds that can be done parallely because building image is heavy and we need to limit it
 var semaphore = make(chan struct{}, maxConcurrentBuilds)
 
-func safeBuild(msg *builder.BuildImageOptions, deploymentId string) {
+// error codes sent back to the api when a build fails
+const (
+	errCodeBuildFailed    = "BUILD_FAILED"
+	errCodeBuildTimeout   = "BUILD_TIMEOUT"
+	errCodeBuildCancelled = "BUILD_CANCELLED"
+	errCodePolicy         = "DOCKERFILE_POLICY_VIOLATION"
+	errCodeSecretsFound   = "SECRETS_FOUND"
+)
+
+// running builds by deployment id, so a delete can stop a build that is in progress
+var (
+	runningBuilds = make(map[string]context.CancelFunc)
+	buildsMutex   sync.Mutex
+)
+
+func safeBuild(ctx context.Context, msg *builder.BuildImageOptions, job *store.Job, queuedAt time.Time) {
+	deploymentId := job.DeploymentID
+
+	// the lease is kept from the claim on, also while waiting for a slot. losing it cancels the build
+	leaseCtx, stopLease := context.WithCancel(ctx)
+	go renewLease(leaseCtx, job, stopLease)
+
 	semaphore <- struct{}{} // store in the slot // this thread will pause until it can accept again
 	go func() {
 		defer func() { <-semaphore }() // release slot
+		defer stopLease()
+
+		buildStartLatency.Observe(time.Since(queuedAt).Seconds()) // includes the wait for a free slot
+
+		// the deadline starts once the build has a slot, waiting for one doesn't count
+		ctx, cancel := context.WithCancel(leaseCtx)
+		if config.Current.BuildTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, config.Current.BuildTimeout)
+		}
+		defer cancel()
+
+		buildsMutex.Lock()
+		runningBuilds[deploymentId] = cancel
+		buildsMutex.Unlock()
 
-		err := builder.BuildImage(
-			builder.BuildImageOptions{
-				ImageName:      msg.ImageName,
-				ContextDir:     msg.ContextDir,
-				DockerfilePath: msg.DockerfilePath,
-			})
+		defer func() {
+			buildsMutex.Lock()
+			delete(runningBuilds, deploymentId)
+			buildsMutex.Unlock()
+		}()
+
+		msg.Memory = config.Current.BuildMemory
+		msg.CPUs = config.Current.BuildCPUs
+
+		// the build output goes to its own log file, builds running next to each other don't mix on stdout
+		var buildLog io.Writer = os.Stdout
+		logFile, logErr := buildlog.Create(deploymentId, job.ID)
+		if logErr != nil {
+			log.Printf("⚠️ Failed to create build log for %s, using stdout: %v", deploymentId, logErr)
+		} else {
+			buildLog = logFile
+			log.Printf("📜 Building %s, log: %s/%s/%d.log", deploymentId, buildlog.Dir, deploymentId, job.ID)
+		}
+
+		result, err := builder.BuildImage(ctx, *msg, buildLog)
 		if err != nil {
-			store.UpdateWorker(deploymentId, "failed", sql.NullString{Valid: false})
-			log.Printf("❌ Build failed: %v", err)
+			fmt.Fprintf(buildLog, "❌ %v\n", err)
 		} else {
-			queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{ // sent the backend the response of built
+			fmt.Fprintf(buildLog, "📦 %s\n", result.Cache)
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+
+		if err != nil {
+			// the workspace stays for debugging, the janitor removes it later
+			if err := store.FinishJob(job, store.StageFailed); err != nil {
+				log.Printf("⚠️ Not reporting the failed build of %s: %v", deploymentId, err) // the worker holding the lease reports it
+				return
+			}
+			log.Printf("❌ Build failed: %v", err)
+
+			response := queue.Response{
 				DeploymentID: deploymentId,
-				Status:       "built",
-			})
-			store.UpdateWorker(deploymentId, "built", sql.NullString{Valid: false}) // storing in db that container is ready to run
-			tracker.DeleteEntry(deploymentId)                                       // deleting the entry from repos.json and the clonedrepo that we used
-			log.Println("✅ Build successful")
+				Status:       "failed",
+				ErrorCode:    errCodeBuildFailed,
+				Error:        err.Error(),
+			}
+
+			var policyErr *policy.Error
+			var secretsErr *secrets.Error
+			switch {
+			case errors.Is(err, builder.ErrBuildTimeout):
+				response.ErrorCode = errCodeBuildTimeout
+			case errors.Is(err, builder.ErrBuildCancelled):
+				response.ErrorCode = errCodeBuildCancelled
+			case errors.As(err, &policyErr):
+				response.ErrorCode = errCodePolicy
+				response.Error = policyErr.Error() // the report without the build prefix, one violation per line
+				for _, v := range policyErr.Violations {
+					response.Violations = append(response.Violations, queue.Violation{File: v.File, Line: v.Line, Rule: v.Rule, Message: v.Message})
+				}
+			case errors.As(err, &secretsErr):
+				response.ErrorCode = errCodeSecretsFound
+				response.Error = secretsErr.Error()
+				response.Secrets = secretFindings(secretsErr.Report.Findings)
+			}
+
+			queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+		} else {
+			if err := store.SetBuildInfo(job.ID, result.ImageID, result.Cache.Cached, result.Cache.Steps); err != nil {
+				log.Printf("⚠️ Failed to record build info for %s: %v", deploymentId, err)
+			}
+			buildCacheHitRatio.Observe(result.Cache.HitRatio())
+
+			response := queue.Response{ // sent the backend the response of built
+				DeploymentID:  deploymentId,
+				Status:        "built",
+				Message:       result.Cache.String(),
+				CacheHitRatio: result.Cache.HitRatio(),
+				Secrets:       secretFindings(result.Secrets),
+			}
+			if len(result.Secrets) > 0 {
+				response.Message += fmt.Sprintf(", %d possible secret(s) in the build context", len(result.Secrets))
+			}
+
+			// the build itself worked, without a release the deployment only can't be rolled back to it later.
+			// the images of a stack are named by compose and rebuilt in place, stacks have no releases
+			if msg.ComposeFile == "" {
+				if w, err := store.ReadWorker(deploymentId); err != nil || w == nil {
+					log.Printf("⚠️ Failed to read deployment %s to record its release: %v", deploymentId, err)
+				} else if rel, err := recordRelease(w, job.ID, result.ImageID); err != nil {
+					log.Printf("⚠️ Failed to record release of %s: %v", deploymentId, err)
+				} else {
+					response.Release = rel.Number
+					response.Image = rel.Image
+
+					// the build stays usable on this worker without the push, it only can't run anywhere else
+					if builder.RegistryEnabled() {
+						if digest, err := pushRelease(deploymentId, rel); err != nil {
+							log.Printf("⚠️ Failed to push release %d of %s: %v", rel.Number, deploymentId, err)
+							response.Message += fmt.Sprintf(", push to the registry failed: %v", err)
+						} else {
+							response.Digest = digest
+						}
+					}
+				}
+			}
+
+			// storing in db that container is ready to run, together with the job. only announced once that is committed,
+			// a crash in between would otherwise build it again after the backend was told it is built
+			if err := store.FinishJob(job, store.StageBuilt); err != nil {
+				log.Printf("⚠️ Not reporting the build of %s: %v", deploymentId, err)
+				return
+			}
+			queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+			os.RemoveAll(filepath.Join(tracker.WorkspaceDir, job.Workspace)) // deleting the clonedrepo that we used
+			log.Printf("✅ Build successful, %s", result.Cache)
 		}
 
 	}()
 
 }
+
+// renewLease extends the lease of job every third of the lease until ctx is done. when another worker took the job
+// over in the meantime, lost is called to stop the build.
+func renewLease(ctx context.Context, job *store.Job, lost context.CancelFunc) {
+	interval := config.Current.BuildLease / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := store.RenewLease(job, config.Current.BuildLease)
+			if errors.Is(err, store.ErrLeaseLost) {
+				log.Printf("⚠️ Lost the build lease of %s, stopping its build", job.DeploymentID)
+				lost()
+				return
+			}
+			if err != nil {
+				log.Printf("⚠️ Failed to renew the build lease of %s: %v", job.DeploymentID, err) // tried again on the next tick
+			}
+		}
+	}
+}
+
+// secretFindings turns scan findings into the report of the response
+func secretFindings(findings []secrets.Finding) []queue.Violation {
+	var list []queue.Violation
+	for _, f := range findings {
+		list = append(list, queue.Violation{File: f.File, Line: f.Line, Rule: f.Rule, Message: f.Description})
+	}
+	return list
+}
+
+// cancelBuild stops the running build of a deployment, if there is one
+func cancelBuild(deploymentId string) bool {
+	buildsMutex.Lock()
+	defer buildsMutex.Unlock()
+
+	cancel, ok := runningBuilds[deploymentId]
+	if ok {
+		log.Printf("🛑 Cancelling running build of %s", deploymentId)
+		cancel()
+	}
+	return ok
+}
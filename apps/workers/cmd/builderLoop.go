@@ -1,60 +1,140 @@
-// this will run in separate go routine which will reads the repos.json and as soon as there are entries, it will create the build job
-// using docker engine and builder_image.go
+// this is the build scheduler. handleCloning hands every new job to it over buildQueue, so a build starts as soon as
+// the clone is done. the ticker is only a recovery sweep for jobs nobody announced (queue was full, leases that ran out,
+// jobs imported on startup).
 
 package main
 
 import (
-	"database/sql"
+	"context"
 	"log"
+	"path/filepath"
 	"strings"
 	"time"
 	"worker/internal/builder"
+	"worker/internal/config"
+	"worker/internal/metrics"
+	"worker/internal/queue"
 	"worker/internal/store"
 	"worker/internal/tracker"
 )
 
-func builderLoop() {
-	ticker := time.NewTicker(5 * time.Second) // checks after 5 seconds
+type buildRequest struct {
+	jobID    int64
+	queuedAt time.Time
+}
 
-	defer ticker.Stop()
+var buildQueue = make(chan buildRequest, 100) // buffered so cloning never waits on the builder
 
-	for {
-		<-ticker.C
+var buildStartLatency = metrics.NewHistogram(
+	"worker_build_start_latency_seconds",
+	"Time from a job being ready to build until its build actually starts.",
+	metrics.DefaultLatencyBuckets,
+)
 
-		entries, err := tracker.LoadAllEntries()
+var buildCacheHitRatio = metrics.NewHistogram(
+	"worker_build_cache_hit_ratio",
+	"Share of the build steps of a successful build that came from the layer cache.",
+	[]float64{0, 0.25, 0.5, 0.75, 0.9, 1},
+)
 
-		if err != nil {
-			log.Printf("⚠️ Failed to load tracker entries: %v\n", err)
-			continue
-		}
+// enqueueBuild tells the scheduler about a new job. if the queue is full the recovery sweep picks the job up later.
+func enqueueBuild(jobID int64) {
+	select {
+	case buildQueue <- buildRequest{jobID: jobID, queuedAt: time.Now()}:
+	default:
+		log.Printf("⚠️ Build queue is full, job %d waits for the next sweep\n", jobID)
+	}
+}
+
+// builderLoop runs the scheduler until ctx is done, every build it starts gets a context derived from ctx
+func builderLoop(ctx context.Context) {
+	ticker := time.NewTicker(config.Current.BuildSweepInterval)
 
-		for _, entry := range entries {
-			msg, err := store.ReadWorker(entry.DeploymentID)
+	defer ticker.Stop()
 
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case req := <-buildQueue:
+			job, err := store.ClaimJobByID(req.jobID, config.Current.WorkerID, config.Current.BuildLease)
 			if err != nil {
-				log.Printf("⚠️ Failed to fetch deployment info for %s: %v\n", entry.DeploymentID, err)
+				log.Printf("⚠️ Failed to claim build job %d: %v\n", req.jobID, err)
 				continue
 			}
 
-
-			if msg.Status != "cloned" {
-				continue
+			if job == nil {
+				continue // already claimed by the sweep or superseded by a newer clone
 			}
 
-			err = store.UpdateWorker(entry.DeploymentID, "building", sql.NullString{Valid: false})
-			if err != nil {
-				log.Printf("⚠️ Failed to mark as building: %v", err)
-				continue
+			startBuild(ctx, job, req.queuedAt)
+
+		case <-ticker.C:
+			for {
+				// claiming moves the job and the worker row to building in one transaction
+				job, err := store.ClaimJob(config.Current.WorkerID, config.Current.BuildLease)
+				if err != nil {
+					log.Printf("⚠️ Failed to claim build job: %v\n", err)
+					break
+				}
+
+				if job == nil {
+					break // nothing left to build
+				}
+
+				log.Printf("🔎 Sweep found job %d for %s\n", job.ID, job.DeploymentID)
+				startBuild(ctx, job, time.Unix(job.UpdatedAt, 0)) // updatedAt is when it became ready (or its lease was last taken)
 			}
+		}
+	}
 
-			log.Printf("🛠️ Starting build for %s (%s)\n", entry.Repo, entry.DeploymentID)
+}
+
+// startBuild reads the deployment and hands the claimed job to safeBuild
+func startBuild(ctx context.Context, job *store.Job, queuedAt time.Time) {
+	msg, err := store.ReadWorker(job.DeploymentID)
+	if err != nil || msg == nil {
+		log.Printf("⚠️ Failed to fetch deployment info for %s: %v\n", job.DeploymentID, err)
+		store.FinishJob(job, store.StageFailed)
+		return
+	}
 
-			safeBuild(&builder.BuildImageOptions{
-				ImageName:      msg.ImageName.String,
-				ContextDir:     "./tmp/repos/" + entry.Path + strings.TrimPrefix(msg.ContextDir.String, "."),
-				DockerfilePath: "./tmp/repos/" + entry.Path + strings.Trim(msg.DockerfilePath.String, "."),
-			}, msg.DeploymentID)
+	log.Printf("🛠️ Starting build for %s (%s), attempt %d\n", job.Repo, job.DeploymentID, job.Attempts)
+
+	secrets, err := store.OpenBuildSecrets(msg)
+	if err != nil {
+		log.Printf("❌ Not building %s: %v\n", job.DeploymentID, err)
+		if err := store.FinishJob(job, store.StageFailed); err != nil {
+			log.Printf("⚠️ Failed to mark job %d failed: %v\n", job.ID, err)
+			return
 		}
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: job.DeploymentID,
+			Status:       "failed",
+			ErrorCode:    errCodeBuildFailed,
+			Error:        err.Error(),
+		})
+		return
+	}
+
+	var composeFile string
+	if msg.ComposePath.String != "" {
+		composeFile = filepath.Join(tracker.WorkspaceDir, job.Workspace, msg.ComposePath.String)
 	}
 
+	safeBuild(ctx, &builder.BuildImageOptions{
+		ImageName:      msg.ImageName.String,
+		ContextDir:     "./" + tracker.WorkspaceDir + "/" + job.Workspace + strings.TrimPrefix(msg.ContextDir.String, "."),
+		DockerfilePath: dockerfilePath(filepath.Join(tracker.WorkspaceDir, job.Workspace), msg.DockerfilePath.String, msg.ContextDir.String),
+		BuildArgs:      decodeObject(msg.BuildArgs),
+		Target:         msg.BuildTarget.String,
+		Secrets:        secrets,
+		CacheMounts:    decodeList(msg.CacheMounts),
+		CacheID:        job.DeploymentID, // cache mounts are shared by the builds of one deployment only
+		ComposeFile:    composeFile,
+		Workspace:      filepath.Join(tracker.WorkspaceDir, job.Workspace),
+		DeploymentID:   job.DeploymentID,
+		PublicService:  msg.PublicService.String,
+	}, job, queuedAt)
 }
@@ -5,19 +5,32 @@ package main
 import (
 	"log"
 	"worker/internal/queue"
+	"worker/internal/repo"
 )
 
 func consumeMessage(msg queue.DeploymentMessage) {
 
 	switch msg.Type {
 	case "build":
-		go handleCloning(msg)
+		if msg.SourceType == repo.SourceImage {
+			go handlePullImage(msg) // nothing to clone or build, just pull the image
+		} else {
+			go handleCloning(msg)
+		}
 	case "delete":
 		go handleDeleteImage(msg)
 	case "trigger":
 		go handleTriggerImage(msg)
 	case "stop":
 		go handleStoppingImage(msg)
+	case "logs":
+		go handleLogs(msg)
+	case "rollback":
+		go handleRollback(msg)
+	case "status":
+		go handleStatus(msg)
+	case "update-env":
+		go handleUpdateEnv(msg)
 	default:
 		log.Printf("⚠️ Unknown message type: %s", msg.Type)
 	}
@@ -0,0 +1,68 @@
+// repos without a Dockerfile get one generated right after the clone, into the place the build will look for it.
+// the template that was used is stored with the deployment, the generated file only lives in the workspace.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"worker/internal/detect"
+	"worker/internal/queue"
+)
+
+// error codes sent back to the api when a workspace has no Dockerfile that can be built
+const (
+	errCodeNoDockerfile           = "DOCKERFILE_NOT_FOUND"
+	errCodeDockerfileGenerateFail = "DOCKERFILE_GENERATION_FAILED"
+)
+
+// dockerfileError carries the error code of a missing or ungeneratable Dockerfile
+type dockerfileError struct {
+	code string
+	err  error
+}
+
+func (e *dockerfileError) Error() string { return e.err.Error() }
+func (e *dockerfileError) Unwrap() error { return e.err }
+
+// dockerfilePath is where the build looks for the Dockerfile: dockerfile relative to the repo root,
+// or Dockerfile in the context dir when it is empty
+func dockerfilePath(workspace, dockerfile, contextDir string) string {
+	if dockerfile == "" {
+		return filepath.Join(workspace, contextDir, "Dockerfile")
+	}
+	return filepath.Join(workspace, dockerfile)
+}
+
+// prepareDockerfile generates a Dockerfile when the workspace has none and describes what it generated,
+// nil when the repo has its own
+func prepareDockerfile(msg queue.DeploymentMessage, workspace string) (*detect.Result, error) {
+	path := dockerfilePath(workspace, msg.DockerfilePath, msg.ContextDir)
+	if _, err := os.Stat(path); err == nil {
+		return nil, nil
+	}
+
+	if msg.NoDockerfileGen {
+		rel, _ := filepath.Rel(workspace, path)
+		return nil, &dockerfileError{errCodeNoDockerfile, fmt.Errorf("no Dockerfile at %s and generation is turned off", rel)}
+	}
+
+	port, _ := strconv.Atoi(msg.PortNumber)
+	result, err := detect.Write(filepath.Join(workspace, msg.ContextDir), path, detect.Options{
+		Port:         port,
+		StartCommand: msg.StartCommand,
+	})
+	if errors.Is(err, detect.ErrUnknownStack) {
+		return nil, &dockerfileError{errCodeNoDockerfile, err}
+	}
+	if err != nil {
+		return nil, &dockerfileError{errCodeDockerfileGenerateFail, err}
+	}
+
+	log.Printf("📝 No Dockerfile in %s, generated one from the %s template (%s)\n", msg.DeploymentID, result.Template, result.Detail)
+	return result, nil
+}
@@ -1,68 +1,362 @@
 // this is the 2nd step and will be responsible for
-// 1. Writing to the repos.json (done internally by clone.go)
-// 2. writing to sqlite
+// 1. Writing the job to the jobs table
+// 2. writing to sqlite (the worker row goes in the same transaction as the job)
 // 3. Cloning the repo in separate goroutine and only this goroutine will handle this task of cloning the repo not gonna spawn multiple go routine
+// the row is marked cloning (with where the source comes from) before the clone starts, so after a restart
+// recoverPipelines knows the clone never finished and can start it again.
 
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"worker/internal/builder"
+	"worker/internal/detect"
 	"worker/internal/queue"
 	"worker/internal/repo"
 	"worker/internal/store"
 	"worker/internal/utils"
 )
 
-func handleCloning(msg queue.DeploymentMessage) {
-	input := repo.CloneRepoInput{
-		RepoURL: msg.Repository,
-		Branch:  msg.Branch,
-	}
-
-	if msg.Token != "" {
-		input.Token = &msg.Token
-	}
+// sent back when the build args, target or secrets of the message can't be used
+const errCodeInvalidBuildOptions = "INVALID_BUILD_OPTIONS"
 
+func handleCloning(msg queue.DeploymentMessage) {
 	// Run CloneRepo in a separate goroutine
 	go func() {
-		err := repo.CloneRepo(input, msg.DeploymentID)
+		// no point cloning something that can't be built
+		err := builder.ValidateBuildOptions(msg.BuildArgs, msg.BuildTarget, msg.BuildSecrets)
+		if err == nil {
+			err = builder.ValidateCacheMounts(msg.CacheMounts)
+		}
+		if err == nil && msg.ComposeFilePath != "" {
+			err = builder.ValidateStackOptions(msg.BuildTarget, msg.BuildSecrets, msg.CacheMounts, msg.PublicService)
+		}
+		if err == nil {
+			err = builder.ValidateBuildLimits(msg.CacheMounts, msg.BuildSecrets, msg.ComposeFilePath != "")
+		}
+		if err == nil && msg.ComposeFilePath != "" && (msg.RestartPolicy != "" || msg.HealthCheck != nil) {
+			// compose stacks are neither supervised nor health checked, set restart and healthcheck in the compose file
+			err = errors.New("restartPolicy and healthCheck are not supported for compose deployments, set restart and healthcheck in the compose file")
+		}
+		if err == nil {
+			err = validateRestartPolicy(msg.RestartPolicy)
+		}
+		code := errCodeInvalidBuildOptions
+		if err == nil {
+			if err = validateResources(msg.Resources); err != nil {
+				code = errCodeInvalidResources
+			}
+		}
+		if err == nil {
+			if err = validateHealthCheck(msg); err != nil {
+				code = errCodeInvalidHealthCheck
+			}
+		}
+		if err != nil {
+			log.Printf("❌ Invalid build options for deployment %s: %v\n", msg.DeploymentID, err)
+			store.InsertWorker(workerEntry(msg, "failed", ""))
+			queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+				DeploymentID: msg.DeploymentID,
+				Status:       "failed",
+				ErrorCode:    code,
+				Error:        err.Error(),
+			})
+			return
+		}
+
+		previous, err := store.ReadWorker(msg.DeploymentID)
+		if err != nil {
+			log.Printf("⚠️ Failed to read deployment %s: %v\n", msg.DeploymentID, err)
+		}
+
+		if err := store.InsertWorker(workerEntry(msg, "cloning", "")); err != nil {
+			log.Printf("⚠️ Failed to mark deployment %s as cloning: %v\n", msg.DeploymentID, err)
+		}
+
+		folder, err := fetchSource(msg)
+
+		// for git sources remember the commit, and skip the build when nothing the deployment watches has changed
+		var commit string
+		if err == nil && (msg.SourceType == "" || msg.SourceType == repo.SourceGit) {
+			commit, err = repo.HeadCommit(folder)
+			if err != nil {
+				log.Printf("⚠️ Failed to read commit for deployment %s: %v\n", msg.DeploymentID, err)
+				err = nil // not knowing the commit only means we can't skip
+			} else if skipped := skipUnchanged(msg, previous, folder, commit); skipped {
+				return
+			}
+		}
+
+		// repos without a Dockerfile get one generated from what is in the workspace
+		var generated *detect.Result
+		if err == nil && msg.ComposeFilePath == "" {
+			if generated, err = prepareDockerfile(msg, folder); err != nil {
+				os.RemoveAll(folder) // nothing to build from it
+			}
+		}
 
 		status := "cloned"
 		if err != nil {
 			log.Printf("❌ Failed to clone repo for deployment %s: %v\n", msg.DeploymentID, err)
 			status = "failed"
+
+			// letting the backend know why the clone failed
+			code := repo.ErrCodeCloneFailed
+			var cloneErr *repo.CloneError
+			var dockerfileErr *dockerfileError
+			if errors.As(err, &cloneErr) {
+				code = cloneErr.Code
+			} else if errors.As(err, &dockerfileErr) {
+				code = dockerfileErr.code
+			}
+
+			queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+				DeploymentID: msg.DeploymentID,
+				Status:       "failed",
+				ErrorCode:    code,
+				Error:        err.Error(),
+			})
 		} else {
-			queue.PublishResponseToQueue(queue.ResultQueue, queue.Response{
+			response := queue.Response{
 				DeploymentID: msg.DeploymentID,
 				Status:       "cloned",
-			})
+			}
+			if generated != nil {
+				response.Message = fmt.Sprintf("no Dockerfile in the repository, generated one from the %s template (%s)", generated.Template, generated.Detail)
+			}
+			queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
 			log.Printf("✅ Repo cloned successfully for deployment %s\n", msg.DeploymentID)
 		}
 
 		fmt.Println(msg.ComposeFilePath)
 
 		// Write to SQLite with actual status
-		entry := store.Worker{
-			DeploymentID: msg.DeploymentID,
-			Status:       status,
-
-			// Fill these if available from msg:
-			ComposePath:    utils.ToNullString(msg.ComposeFilePath),
-			ImageName:      utils.ToNullString("blacktree/" + utils.Slugify(msg.Repository) + "-" + msg.DeploymentID[:8]),
-			ContextDir:     utils.ToNullString(msg.ContextDir),
-			DockerfilePath: utils.ToNullString(msg.DockerfilePath),
-			Port:           utils.ToNullInt(msg.PortNumber),
-			AutoDeploy:     msg.AutoDeploy,
+		entry := workerEntry(msg, status, commit)
+		if generated != nil {
+			entry.DockerfileTemplate = utils.ToNullString(generated.Template)
 		}
 
 		log.Printf("Raw port string from message: %s", msg.PortNumber)
 
-		if err := store.InsertWorker(entry); err != nil {
-			log.Printf("⚠️ Failed to insert into DB for deployment %s: %v\n", msg.DeploymentID, err)
+		var dbErr error
+		if status == "cloned" {
+			// the builder only ever sees the job and the worker row together, or neither of them
+			var jobID int64
+			jobID, dbErr = store.CreateJob(store.Job{
+				DeploymentID: msg.DeploymentID,
+				Workspace:    filepath.Base(folder),
+				Repo:         repo.SourceName(msg.SourceType, sourceLocation(msg)),
+			}, entry)
+			if dbErr == nil {
+				enqueueBuild(jobID) // hand it straight to the build scheduler
+			}
+		} else {
+			dbErr = store.InsertWorker(entry)
+		}
+
+		if dbErr != nil {
+			log.Printf("⚠️ Failed to insert into DB for deployment %s: %v\n", msg.DeploymentID, dbErr)
+			if status == "cloned" {
+				os.RemoveAll(folder) // without a job nobody would ever build or clean it
+			}
 		} else {
 			log.Printf("✅ Wrote entry successfully in ./data/database.db %s\n", msg.DeploymentID)
 		}
 
 	}()
 }
+
+// workerEntry is the worker row for a deployment message
+func workerEntry(msg queue.DeploymentMessage, status string, commit string) store.Worker {
+	buildSecrets, err := store.SealBuildSecrets(msg.DeploymentID, msg.BuildSecrets)
+	if err != nil {
+		log.Printf("⚠️ Not storing the build secrets of %s: %v\n", msg.DeploymentID, err)
+	}
+
+	return store.Worker{
+		DeploymentID: msg.DeploymentID,
+		Status:       status,
+
+		// Fill these if available from msg:
+		ComposePath:     utils.ToNullString(msg.ComposeFilePath),
+		PublicService:   utils.ToNullString(msg.PublicService),
+		StartCommand:    utils.ToNullString(msg.StartCommand),
+		NoDockerfileGen: msg.NoDockerfileGen,
+		ImageName:       utils.ToNullString("blacktree/" + repo.SourceName(msg.SourceType, sourceLocation(msg)) + "-" + msg.DeploymentID[:8]),
+		ContextDir:      utils.ToNullString(msg.ContextDir),
+		DockerfilePath:  utils.ToNullString(msg.DockerfilePath),
+		Port:            utils.ToNullInt(msg.PortNumber),
+		AutoDeploy:      msg.AutoDeploy,
+		WatchPaths:      jsonList(msg.WatchPaths),
+		CommitSHA:       utils.ToNullString(commit),
+
+		// the token is never stored, a private repo can't be cloned again after a restart without a new message
+		SourceType: utils.ToNullString(msg.SourceType),
+		Repository: utils.ToNullString(msg.Repository),
+		Branch:     utils.ToNullString(msg.Branch),
+		SourcePath: utils.ToNullString(msg.SourcePath),
+
+		BuildArgs:     jsonObject(msg.BuildArgs),
+		BuildTarget:   utils.ToNullString(msg.BuildTarget),
+		BuildSecrets:  buildSecrets,
+		CacheMounts:   jsonList(msg.CacheMounts),
+		Resources:     jsonResources(msg.Resources),
+		RestartPolicy: utils.ToNullString(msg.RestartPolicy),
+		HealthCheck:   jsonHealthCheck(msg.HealthCheck),
+	}
+}
+
+// fetchSource puts the deployment's source into a workspace, whatever kind of source it is.
+// after this every source looks the same to the builder.
+func fetchSource(msg queue.DeploymentMessage) (string, error) {
+	switch msg.SourceType {
+	case "", repo.SourceGit:
+		input := repo.CloneRepoInput{
+			RepoURL: msg.Repository,
+			Branch:  msg.Branch,
+		}
+
+		if msg.Token != "" {
+			input.Token = &msg.Token
+		}
+		return repo.CloneRepo(input, msg.DeploymentID)
+
+	case repo.SourceArchive:
+		return repo.FetchArchive(repo.ArchiveInput{Source: msg.SourcePath}, msg.DeploymentID)
+
+	case repo.SourceLocal:
+		return repo.CopyLocalDir(repo.LocalDirInput{Path: msg.SourcePath}, msg.DeploymentID)
+	}
+
+	return "", &repo.CloneError{Code: repo.ErrCodeSourceInvalid, Err: fmt.Errorf("unknown source type %q", msg.SourceType)}
+}
+
+// sourceLocation is where the source comes from, used to name the image
+func sourceLocation(msg queue.DeploymentMessage) string {
+	switch msg.SourceType {
+	case "", repo.SourceGit:
+		return msg.Repository
+	case repo.SourceImage:
+		return msg.Image
+	}
+	return msg.SourcePath
+}
+
+// skipUnchanged compares the new commit with the last deployed one and drops the workspace when no changed file
+// matches the deployment's watched paths. it reports true when the build was skipped.
+// previous is the row as it was before this clone started.
+func skipUnchanged(msg queue.DeploymentMessage, previous *store.Worker, folder, commit string) bool {
+	if msg.Force {
+		return false
+	}
+
+	if previous == nil || !previous.LastDeployedSHA.Valid {
+		return false // first deployment, always build
+	}
+
+	if previous.LastDeployedSHA.String == commit {
+		log.Printf("ℹ️ Commit %s is already deployed for %s, rebuilding anyway\n", commit, msg.DeploymentID)
+		return false // same commit again means someone asked for a rebuild on purpose
+	}
+
+	changed, err := repo.ChangedFiles(folder, previous.LastDeployedSHA.String, commit)
+	if err != nil {
+		log.Printf("⚠️ Can't diff against last deployed commit for %s, rebuilding: %v\n", msg.DeploymentID, err)
+		return false
+	}
+
+	globs := msg.WatchPaths
+	if len(globs) == 0 {
+		globs = repo.DefaultWatchPaths(msg.ContextDir)
+	}
+
+	if repo.MatchAny(globs, changed) {
+		return false
+	}
+
+	log.Printf("⏭️ Skipping build for %s, %d changed file(s) but none under %v\n", msg.DeploymentID, len(changed), globs)
+
+	if err := repo.DiscardWorkspace(folder); err != nil {
+		log.Printf("⚠️ Failed to remove workspace %s: %v\n", folder, err)
+	}
+
+	// nothing was deployed, the deployment is back to whatever it was before the clone
+	if err := store.SetStatus(msg.DeploymentID, previous.Status); err != nil {
+		log.Printf("⚠️ Failed to restore status of %s: %v\n", msg.DeploymentID, err)
+	}
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       "skipped",
+		Message:      fmt.Sprintf("no changes under %s between %s and %s", strings.Join(globs, ", "), short(previous.LastDeployedSHA.String), short(commit)),
+	})
+	return true
+}
+
+// jsonList stores a list as a json array, nothing when it is empty and the deployment uses the default
+func jsonList(list []string) sql.NullString {
+	if len(list) == 0 {
+		return sql.NullString{Valid: false}
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return sql.NullString{Valid: false}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// jsonObject stores a map as a json object, nothing when it is empty
+func jsonObject(m map[string]string) sql.NullString {
+	if len(m) == 0 {
+		return sql.NullString{Valid: false}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return sql.NullString{Valid: false}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// decodeObject reads a map stored with jsonObject
+func decodeObject(s sql.NullString) map[string]string {
+	if !s.Valid {
+		return nil
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal([]byte(s.String), &m); err != nil {
+		log.Printf("⚠️ Ignoring invalid json object in database: %v\n", err) // never print the value, it may hold secrets
+		return nil
+	}
+	return m
+}
+
+// decodeList reads a list stored with jsonList
+func decodeList(s sql.NullString) []string {
+	if !s.Valid {
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal([]byte(s.String), &list); err != nil {
+		log.Printf("⚠️ Ignoring invalid json array in database: %v\n", err)
+		return nil
+	}
+	return list
+}
+
+func short(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
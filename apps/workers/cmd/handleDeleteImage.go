@@ -12,6 +12,7 @@ package main
 import (
 	"log"
 	"worker/internal/builder"
+	"worker/internal/buildlog"
 	"worker/internal/queue"
 	"worker/internal/store"
 )
@@ -20,19 +21,41 @@ import (
 func handleDeleteImage(msg queue.DeploymentMessage) {
 	log.Printf("🗑️ Received delete message for image: %s (Deployment ID: %s)", msg.Repository, msg.DeploymentID)
 
+	cancelBuild(msg.DeploymentID) // no point finishing a build for a deployment that is going away
+
 	readInfo, err := store.ReadWorker(msg.DeploymentID)
 	if err != nil {
 		log.Printf("⚠️ Failed to read worker info for deployment %s: %v ", msg.DeploymentID, err)
 		return
 	}
-	if readInfo.ImageName.Valid {
-		builder.StopAndDeleteContainer(readInfo.ImageName.String)
+	if readInfo != nil && readInfo.ComposePath.Valid {
+		if err := builder.RemoveStack(msg.DeploymentID); err != nil {
+			log.Printf("⚠️ Failed to remove stack of deployment %s: %v", msg.DeploymentID, err)
+		}
 	} else {
-		log.Printf("⚠️ ImageName is NULL for deployment %s", msg.DeploymentID)
+		// whatever release it runs, the container carries the deployment label
+		stopHealthCheck(msg.DeploymentID)
+		if err := builder.StopDeployment(msg.DeploymentID); err != nil {
+			log.Printf("⚠️ Failed to remove container(s) of deployment %s: %v", msg.DeploymentID, err)
+		}
+		if readInfo != nil && readInfo.ImageName.Valid {
+			if err := builder.RemoveImage(readInfo.ImageName.String); err != nil {
+				log.Printf("⚠️ Failed to remove image %s: %v", readInfo.ImageName.String, err)
+			}
+		} else {
+			log.Printf("⚠️ ImageName is NULL for deployment %s", msg.DeploymentID)
+		}
+	}
+	removeReleases(msg.DeploymentID)
+	if err := store.DeleteEnv(msg.DeploymentID); err != nil {
+		log.Printf("⚠️ Failed to remove the environment of %s: %v", msg.DeploymentID, err)
 	}
 
 	log.Printf("✅ Successfully deleted image: %s", msg.Repository)
 	store.DeleteWorker(msg.DeploymentID)
+	if err := buildlog.Remove(msg.DeploymentID); err != nil {
+		log.Printf("⚠️ Failed to remove build logs of %s: %v", msg.DeploymentID, err)
+	}
 	log.Printf("🗑️ Successfully deleted worker info from database: %s", msg.DeploymentID)
 
 	// sending the data to the main backend
@@ -0,0 +1,49 @@
+// answers a logs message with the stored log of a build (the latest one unless buildId is set).
+// the logs of failed builds are kept as well, that is mostly what they are asked for.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"worker/internal/buildlog"
+	"worker/internal/queue"
+)
+
+const errCodeLogsNotFound = "LOGS_NOT_FOUND"
+
+func handleLogs(msg queue.DeploymentMessage) {
+	file, buildID, err := buildlog.Open(msg.DeploymentID, msg.BuildID)
+	if err != nil {
+		log.Printf("⚠️ No build log for %s (build %d): %v", msg.DeploymentID, msg.BuildID, err)
+
+		reason := err.Error()
+		if errors.Is(err, os.ErrNotExist) {
+			reason = "no build log stored for this deployment and build"
+		}
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: msg.DeploymentID,
+			Status:       "logs",
+			BuildID:      msg.BuildID,
+			ErrorCode:    errCodeLogsNotFound,
+			Error:        reason,
+		})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("⚠️ Failed to read build log of %s: %v", msg.DeploymentID, err)
+		return
+	}
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       "logs",
+		BuildID:      buildID,
+		Logs:         string(data),
+	})
+}
@@ -0,0 +1,122 @@
+// this handles build messages with sourceType image. the image is already built somewhere else so
+// there is nothing to clone or build: pull it, tag it for the deployment and mark it as built so trigger can run it.
+
+package main
+
+import (
+	"log"
+	"worker/internal/builder"
+	"worker/internal/queue"
+	"worker/internal/repo"
+	"worker/internal/store"
+	"worker/internal/utils"
+)
+
+// error codes sent back to the api when an image deployment fails
+const (
+	errCodeInvalidImage = "INVALID_IMAGE"
+	errCodePullFailed   = "IMAGE_PULL_FAILED"
+)
+
+func handlePullImage(msg queue.DeploymentMessage) {
+	log.Printf("📥 Image deployment received for %s (Deployment ID: %s)", msg.Image, msg.DeploymentID)
+
+	if err := builder.ValidateImageReference(msg.Image); err != nil {
+		failImageDeployment(msg, errCodeInvalidImage, err)
+		return
+	}
+	if err := validateResources(msg.Resources); err != nil {
+		failImageDeployment(msg, errCodeInvalidResources, err)
+		return
+	}
+	if err := validateRestartPolicy(msg.RestartPolicy); err != nil {
+		failImageDeployment(msg, errCodeInvalidBuildOptions, err)
+		return
+	}
+	if err := validateHealthCheck(msg); err != nil {
+		failImageDeployment(msg, errCodeInvalidHealthCheck, err)
+		return
+	}
+
+	if err := builder.PullImage(msg.Image); err != nil {
+		failImageDeployment(msg, errCodePullFailed, err)
+		return
+	}
+
+	// every deployment gets its own tag, so deleting one deployment never removes an image another one is using
+	imageName := "blacktree/" + repo.SourceName(repo.SourceImage, msg.Image) + "-" + msg.DeploymentID[:8]
+	if err := builder.TagImage(msg.Image, imageName); err != nil {
+		failImageDeployment(msg, errCodePullFailed, err)
+		return
+	}
+
+	digest, err := builder.ImageDigest(msg.Image)
+	if err != nil {
+		log.Printf("⚠️ Failed to read digest of %s: %v", msg.Image, err)
+	}
+
+	entry := store.Worker{
+		DeploymentID:  msg.DeploymentID,
+		Status:        "built",
+		ImageName:     utils.ToNullString(imageName),
+		SourceImage:   utils.ToNullString(msg.Image),
+		ImageDigest:   utils.ToNullString(digest),
+		Port:          utils.ToNullInt(msg.PortNumber),
+		AutoDeploy:    msg.AutoDeploy,
+		Resources:     jsonResources(msg.Resources),
+		RestartPolicy: utils.ToNullString(msg.RestartPolicy),
+		HealthCheck:   jsonHealthCheck(msg.HealthCheck),
+	}
+
+	if err := store.InsertWorker(entry); err != nil {
+		log.Printf("⚠️ Failed to insert into DB for deployment %s: %v\n", msg.DeploymentID, err)
+		failImageDeployment(msg, errCodePullFailed, err)
+		return
+	}
+
+	log.Printf("✅ Image %s ready for deployment %s (%s)", msg.Image, msg.DeploymentID, digest)
+
+	response := queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       "built",
+	}
+	if rel, err := recordRelease(&entry, 0, ""); err != nil {
+		log.Printf("⚠️ Failed to record release of %s: %v", msg.DeploymentID, err)
+	} else {
+		response.Release = rel.Number
+		response.Image = rel.Image
+		response.Digest = rel.Digest.String
+	}
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+}
+
+func failImageDeployment(msg queue.DeploymentMessage, code string, err error) {
+	log.Printf("❌ Image deployment failed for %s: %v", msg.DeploymentID, err)
+
+	// a failed redeploy only marks the deployment, its image, port and releases are still what runs and rolls back
+	existing, readErr := store.ReadWorker(msg.DeploymentID)
+	switch {
+	case readErr != nil:
+		log.Printf("⚠️ Failed to read deployment %s: %v", msg.DeploymentID, readErr)
+	case existing != nil:
+		if err := store.SetStatus(msg.DeploymentID, "failed"); err != nil {
+			log.Printf("⚠️ Failed to update status of %s: %v", msg.DeploymentID, err)
+		}
+	default:
+		if err := store.InsertWorker(store.Worker{
+			DeploymentID: msg.DeploymentID,
+			Status:       "failed",
+			SourceImage:  utils.ToNullString(msg.Image),
+		}); err != nil {
+			log.Printf("⚠️ Failed to insert into DB for deployment %s: %v", msg.DeploymentID, err)
+		}
+	}
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       "failed",
+		ErrorCode:    code,
+		Error:        err.Error(),
+	})
+}
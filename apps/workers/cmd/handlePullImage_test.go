@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"worker/internal/builder"
+	"worker/internal/config"
+	"worker/internal/docker/dockertest"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// useTestDB opens an empty database in a temporary folder for the test
+func useTestDB(t *testing.T) {
+	t.Helper()
+	if err := store.InitDB(filepath.Join(t.TempDir(), "database.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		store.DB = nil
+	})
+}
+
+// useEngine makes a fake engine the container runtime for the test
+func useEngine(t *testing.T) *dockertest.Engine {
+	t.Helper()
+	engine := dockertest.NewEngine(t)
+	previous, previousRuntime := config.Current, builder.Current
+	config.Current.Runtime = "docker"
+	config.Current.DockerSocket = engine.Socket
+	config.Current.DockerAPIVersion = ""
+	if err := builder.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.Current, builder.Current = previous, previousRuntime })
+	return engine
+}
+
+func TestFailedPullKeepsDeployment(t *testing.T) {
+	useTestDB(t)
+	engine := useEngine(t)
+
+	const id = "1234abcd-0000-0000-0000-000000000000"
+	handlePullImage(queue.DeploymentMessage{DeploymentID: id, Image: "nginx:1.25", PortNumber: "8080"})
+	deployed, err := store.ReadWorker(id)
+	if err != nil || deployed == nil || deployed.Status != "built" {
+		t.Fatalf("first deployment: %+v (err %v)", deployed, err)
+	}
+
+	// a typo in the image name of the redeploy
+	engine.Fail("POST", "/images/create", 404, "pull access denied for ngnix, repository does not exist")
+	handlePullImage(queue.DeploymentMessage{DeploymentID: id, Image: "ngnix:1.26", PortNumber: "9090"})
+
+	w, err := store.ReadWorker(id)
+	if err != nil || w == nil {
+		t.Fatalf("deployment is gone (err %v)", err)
+	}
+	if w.Status != "failed" {
+		t.Errorf("status %q, want failed", w.Status)
+	}
+	if w.ImageName != deployed.ImageName || w.Port != deployed.Port || w.SourceImage != deployed.SourceImage || w.ImageDigest != deployed.ImageDigest {
+		t.Errorf("the failed redeploy changed the deployment: %+v, was %+v", w, deployed)
+	}
+}
+
+func TestFailedPullOfNewDeployment(t *testing.T) {
+	useTestDB(t)
+	useEngine(t)
+
+	const id = "5678abcd-0000-0000-0000-000000000000"
+	handlePullImage(queue.DeploymentMessage{DeploymentID: id, Image: "nginx latest"})
+
+	w, err := store.ReadWorker(id)
+	if err != nil || w == nil || w.Status != "failed" || w.SourceImage.String != "nginx latest" {
+		t.Errorf("new deployment after a failure: %+v (err %v)", w, err)
+	}
+}
@@ -0,0 +1,133 @@
+// this handles the rollback message: the deployment is started again on an earlier release that is still retained.
+// the deployment's image name is moved to that release as well, so a later trigger runs the same image.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"worker/internal/builder"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// error codes sent back to the api when a rollback fails
+const (
+	errCodeReleaseNotFound = "RELEASE_NOT_FOUND"
+	errCodeRollbackFailed  = "ROLLBACK_FAILED"
+)
+
+func handleRollback(msg queue.DeploymentMessage) {
+	log.Printf("⏪ Rollback received for Deployment ID: %s", msg.DeploymentID)
+
+	w, err := store.ReadWorker(msg.DeploymentID)
+	if err != nil {
+		failRollback(msg, "failed", errCodeRollbackFailed, fmt.Errorf("failed to read deployment: %w", err))
+		return
+	}
+	if w == nil || !w.ImageName.Valid {
+		failRollback(msg, "failed", errCodeReleaseNotFound, errors.New("deployment has no releases"))
+		return
+	}
+
+	// until the container is stopped nothing has changed, the api gets the status the deployment still has
+	unchanged := w.Status
+
+	target, err := rollbackTarget(w, msg.Release)
+	if err != nil {
+		failRollback(msg, unchanged, errCodeReleaseNotFound, err)
+		return
+	}
+
+	if exists, err := builder.ImageExists(target.Image); err != nil {
+		failRollback(msg, unchanged, errCodeRollbackFailed, err)
+		return
+	} else if !exists && target.Digest.Valid {
+		// gone from this engine but pushed, the registry still has it
+		if err := pullPinned(target.Digest.String, target.Image); err != nil {
+			failRollback(msg, unchanged, errCodeRollbackFailed, err)
+			return
+		}
+	} else if !exists {
+		store.DeleteRelease(w.DeploymentID, target.Number) // removed behind our back, don't offer it again
+		failRollback(msg, unchanged, errCodeReleaseNotFound, fmt.Errorf("image %s of release %d no longer exists", target.Image, target.Number))
+		return
+	}
+
+	if err := builder.StopDeployment(w.DeploymentID); err != nil {
+		failRollback(msg, unchanged, errCodeRollbackFailed, err)
+		return
+	}
+
+	// the deployment name follows the release in use, trigger and the build cache read it
+	if err := builder.TagImage(target.Image, w.ImageName.String); err != nil {
+		store.SetRunState(w.DeploymentID, "failed")
+		failRollback(msg, "failed", errCodeRollbackFailed, err)
+		return
+	}
+
+	container, err := startDeploymentContainer(w, target.Image, target.Number)
+	if err != nil {
+		store.SetContainer(w.DeploymentID, "")
+		store.SetRunState(w.DeploymentID, "failed")
+		failRollback(msg, "failed", errCodeRollbackFailed, err)
+		return
+	}
+	resetCrashes(w.DeploymentID)
+
+	if err := store.DeployRelease(w.DeploymentID, target.Number); err != nil {
+		log.Printf("⚠️ Failed to record release %d of %s as deployed: %v", target.Number, w.DeploymentID, err)
+	}
+
+	log.Printf("✅ Rolled %s back to release %d (%s)", w.DeploymentID, target.Number, target.Image)
+
+	containerStarted(w, container, queue.Response{
+		DeploymentID: w.DeploymentID,
+		Status:       "running",
+		Message:      fmt.Sprintf("rolled back from release %d to release %d", w.CurrentRelease.Int64, target.Number),
+		Release:      target.Number,
+		Image:        target.Image,
+		Digest:       target.Digest.String,
+	})
+}
+
+// rollbackTarget picks the requested release, or the newest one before the release in use when number is 0
+func rollbackTarget(w *store.Worker, number int) (*store.Release, error) {
+	if number > 0 {
+		rel, err := store.ReadRelease(w.DeploymentID, number)
+		if err != nil {
+			return nil, err
+		}
+		if rel == nil {
+			return nil, fmt.Errorf("release %d does not exist or was pruned", number)
+		}
+		return rel, nil
+	}
+
+	if !w.CurrentRelease.Valid {
+		return nil, errors.New("deployment has no release in use to roll back from")
+	}
+
+	releases, err := store.ListReleases(w.DeploymentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range releases { // newest first
+		if int64(rel.Number) < w.CurrentRelease.Int64 {
+			return &rel, nil
+		}
+	}
+	return nil, fmt.Errorf("no release before release %d is retained", w.CurrentRelease.Int64)
+}
+
+func failRollback(msg queue.DeploymentMessage, status, code string, err error) {
+	log.Printf("❌ Rollback failed for %s: %v", msg.DeploymentID, err)
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       status,
+		ErrorCode:    code,
+		Error:        err.Error(),
+	})
+}
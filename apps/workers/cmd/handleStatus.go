@@ -0,0 +1,87 @@
+// answers a status message with the state of the deployment's containers as the engine sees them,
+// every service for a compose stack. the status column of the worker row is only what the worker last did.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"worker/internal/builder"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+const errCodeStatusFailed = "STATUS_FAILED"
+
+func handleStatus(msg queue.DeploymentMessage) {
+	info, err := store.ReadWorker(msg.DeploymentID)
+	if err == nil && info == nil {
+		err = errors.New("deployment not found")
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to read status of %s: %v", msg.DeploymentID, err)
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: msg.DeploymentID,
+			Status:       "status",
+			ErrorCode:    errCodeStatusFailed,
+			Error:        err.Error(),
+		})
+		return
+	}
+
+	services, err := deploymentContainers(info)
+	if err != nil {
+		log.Printf("⚠️ Failed to list containers of %s: %v", msg.DeploymentID, err)
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: msg.DeploymentID,
+			Status:       "status",
+			ErrorCode:    errCodeStatusFailed,
+			Error:        err.Error(),
+		})
+		return
+	}
+
+	// while it is rebuilt the container has a state of its own
+	message := info.Status
+	if info.RunState.Valid && info.RunState.String != info.Status {
+		message += ", container " + info.RunState.String
+	}
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       "status",
+		Message:      message,
+		Services:     services,
+	})
+}
+
+// deploymentContainers returns the containers of a deployment, one per service for a stack
+func deploymentContainers(info *store.Worker) ([]queue.ServiceStatus, error) {
+	if info.ComposePath.Valid {
+		containers, err := builder.StackContainers(info.DeploymentID)
+		if err != nil {
+			return nil, err
+		}
+
+		services := make([]queue.ServiceStatus, 0, len(containers))
+		for _, c := range containers {
+			services = append(services, queue.ServiceStatus{
+				Service:   c.Labels[builder.ComposeServiceLabel],
+				Container: c.Name,
+				State:     c.State,
+			})
+		}
+		return services, nil
+	}
+
+	containers, err := builder.DeploymentContainers(info.DeploymentID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]queue.ServiceStatus, 0, len(containers))
+	for _, c := range containers {
+		services = append(services, queue.ServiceStatus{Container: c.Name, State: c.State})
+	}
+	return services, nil
+}
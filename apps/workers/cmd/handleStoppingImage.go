@@ -1,4 +1,4 @@
-// this is responsible for handling the stop message and stopping all the containers related to the image.
+// this is responsible for handling the stop message and stopping all the containers of the deployment.
 
 package main
 
@@ -6,19 +6,36 @@ import (
 	"log"
 	"worker/internal/builder"
 	"worker/internal/queue"
+	"worker/internal/store"
 )
 
-// Handles the stop message: stops all containers related to the image
+// Handles the stop message: stops all containers labelled with the deployment
 func handleStoppingImage(msg queue.DeploymentMessage) {
 	log.Printf("🛑 Received stop message for image: %s (Deployment ID: %s)", msg.Repository, msg.DeploymentID)
 
-	err := builder.StopContainer(msg.Repository)
+	// a stack is stopped as a whole, every service with it
+	if info, err := store.ReadWorker(msg.DeploymentID); err == nil && info != nil && info.ComposePath.Valid {
+		if err := builder.StopStack(msg.DeploymentID); err != nil {
+			log.Printf("⚠️ Failed to stop stack of deployment %s: %v", msg.DeploymentID, err)
+			return
+		}
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: msg.DeploymentID,
+			Status:       "stopped",
+		})
+		return
+	}
+
+	stopHealthCheck(msg.DeploymentID)
+	err := builder.StopDeployment(msg.DeploymentID)
 	if err != nil {
-		log.Printf("⚠️ Failed to stop container(s) for image %s: %v", msg.Repository, err)
+		log.Printf("⚠️ Failed to stop container(s) of deployment %s: %v", msg.DeploymentID, err)
 		return
 	}
+	store.SetContainer(msg.DeploymentID, "")
+	store.SetRunState(msg.DeploymentID, "stopped") // the supervisor leaves stopped deployments alone
 
-	log.Printf("✅ Successfully stopped and cleaned up containers for image: %s", msg.Repository)
+	log.Printf("✅ Successfully stopped and cleaned up containers of deployment: %s", msg.DeploymentID)
 
 	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
 		DeploymentID: msg.DeploymentID,
@@ -3,13 +3,16 @@
 package main
 
 import (
-	"database/sql"
+	"fmt"
 	"log"
 	"strconv"
+	"strings"
 
 	"worker/internal/builder"
 	"worker/internal/queue"
+	"worker/internal/repo"
 	"worker/internal/store"
+	"worker/internal/utils"
 )
 
 func handleTriggerImage(msg queue.DeploymentMessage) {
@@ -32,8 +35,27 @@ func handleTriggerImage(msg queue.DeploymentMessage) {
 		return
 	}
 
-	// If image name is missing, log and skip
-	if !info.ImageName.Valid || info.ImageName.String == "" {
+	// a release pushed by another worker is pulled by its digest first
+	if msg.Image != "" && (info == nil || !info.ComposePath.Valid) {
+		if info, err = pullTriggerImage(msg, info); err != nil {
+			log.Printf("❌ Failed to pull %s for deployment %s: %v", msg.Image, msg.DeploymentID, err)
+			queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+				DeploymentID: msg.DeploymentID,
+				Status:       "failed",
+				ErrorCode:    errCodePullFailed,
+				Error:        err.Error(),
+			})
+			return
+		}
+	}
+
+	if info == nil {
+		log.Printf("❌ Deployment %s is not known to this worker, send its pushed digest as image", msg.DeploymentID)
+		return
+	}
+
+	// If image name is missing, log and skip. a stack has no image of its own
+	if !info.ComposePath.Valid && (!info.ImageName.Valid || info.ImageName.String == "") {
 		log.Printf("❌ No valid image name found for deployment %s", msg.DeploymentID)
 		return
 	}
@@ -44,21 +66,120 @@ func handleTriggerImage(msg queue.DeploymentMessage) {
 		containerPort = &portVal
 	}
 
-	// Start the container using builder package
-	err = builder.StartContainer(msg.DeploymentID, info.ImageName.String, containerPort)
+	// Start the container (or all containers of the stack) using builder package
+	container := ""
+	if info.ComposePath.Valid {
+		err = builder.StartStack(msg.DeploymentID, info.PublicService.String, containerResources(info), containerPort)
+	} else {
+		var env []string
+		if env, err = containerEnv(msg.DeploymentID); err == nil {
+			container, err = builder.StartContainer(msg.DeploymentID, info.ImageName.String, int(info.ImageRelease.Int64), env, containerResources(info), containerPort)
+		}
+	}
 	if err != nil {
-		store.UpdateWorker(msg.DeploymentID, "failed", sql.NullString{Valid: false})
+		store.SetRunState(msg.DeploymentID, "failed")
 		log.Printf("❌ Failed to start container for deployment %s: %v", msg.DeploymentID, err)
 		return
 	}
 
-	// Update status and container info
-	store.UpdateWorker(msg.DeploymentID, "running", sql.NullString{String: msg.Repository, Valid: true})
+	// Update status, the rest of the row stays as the build left it
+	resetCrashes(msg.DeploymentID)
 	log.Printf("✅ Successfully triggered container for deployment %s", msg.DeploymentID)
 
-	// sending the info to the backend
-	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+	response := queue.Response{
 		DeploymentID: msg.DeploymentID,
 		Status:       "running", // this is equivalent to ready shoulda been consistent....
-	})
+	}
+
+	// the release the image name points at is the one in use now that its container runs
+	if container != "" && info.ImageRelease.Valid {
+		number := int(info.ImageRelease.Int64)
+		if err := store.DeployRelease(msg.DeploymentID, number); err != nil {
+			log.Printf("⚠️ Failed to record release %d of %s as deployed: %v", number, msg.DeploymentID, err)
+		}
+		response.Release = number
+	}
+
+	if container == "" {
+		// a stack has no container of its own to check
+		store.SetRunState(msg.DeploymentID, "running")
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+		return
+	}
+
+	// sending the info to the backend, once its health check passes when it has one
+	containerStarted(info, container, response)
+}
+
+// namedDigest is the digest of what the image name points at: its release's, the pulled image's when it has none
+func namedDigest(info *store.Worker) string {
+	if !info.ImageRelease.Valid {
+		return info.ImageDigest.String
+	}
+	rel, err := store.ReadRelease(info.DeploymentID, int(info.ImageRelease.Int64))
+	if err != nil || rel == nil {
+		return ""
+	}
+	return rel.Digest.String
+}
+
+// pullTriggerImage makes sure the engine has the pinned image of the trigger message under the deployment's image name.
+// a deployment this worker has never seen gets a row of its own, ready to run.
+func pullTriggerImage(msg queue.DeploymentMessage, info *store.Worker) (*store.Worker, error) {
+	if err := builder.ValidateImageReference(msg.Image); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(msg.Image, "@") {
+		return nil, fmt.Errorf("trigger image %s must be pinned by digest (name@sha256:...)", msg.Image)
+	}
+
+	name := ""
+	switch local, ok := builder.LocalName(msg.Image); {
+	case info != nil && info.ImageName.Valid:
+		name = info.ImageName.String
+	case ok:
+		name = local // the name of the worker that pushed it
+	default:
+		name = "blacktree/" + repo.SourceName(repo.SourceImage, msg.Image) + "-" + msg.DeploymentID[:8]
+	}
+
+	if info != nil && namedDigest(info) == msg.Image {
+		if exists, err := builder.ImageExists(name); err == nil && exists {
+			return info, nil // the image name already points at this one
+		}
+	}
+
+	log.Printf("📥 Pulling %s for deployment %s", msg.Image, msg.DeploymentID)
+	if err := pullPinned(msg.Image, name); err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		if err := validateResources(msg.Resources); err != nil {
+			return nil, err
+		}
+		if err := validateRestartPolicy(msg.RestartPolicy); err != nil {
+			return nil, err
+		}
+		if err := validateHealthCheck(msg); err != nil {
+			return nil, err
+		}
+		entry := store.Worker{
+			DeploymentID:  msg.DeploymentID,
+			Status:        "built",
+			ImageName:     utils.ToNullString(name),
+			ImageDigest:   utils.ToNullString(msg.Image),
+			Port:          utils.ToNullInt(msg.PortNumber),
+			AutoDeploy:    msg.AutoDeploy,
+			Resources:     jsonResources(msg.Resources),
+			RestartPolicy: utils.ToNullString(msg.RestartPolicy),
+			HealthCheck:   jsonHealthCheck(msg.HealthCheck),
+		}
+		if err := store.InsertWorker(entry); err != nil {
+			return nil, fmt.Errorf("failed to store deployment: %w", err)
+		}
+	} else if err := store.SetImage(msg.DeploymentID, name, msg.Image); err != nil {
+		return nil, fmt.Errorf("failed to store pulled image: %w", err)
+	}
+	return store.ReadWorker(msg.DeploymentID)
 }
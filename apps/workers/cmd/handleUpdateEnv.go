@@ -0,0 +1,136 @@
+// this handles the update-env message: the variables and secrets of a deployment are stored and, when its
+// container is running, the container is started again with them. values never go into a log or a response.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"worker/internal/builder"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// error codes sent back to the api when the environment can't be updated
+const (
+	errCodeInvalidEnv      = "INVALID_ENV"
+	errCodeEnvUpdateFailed = "ENV_UPDATE_FAILED"
+)
+
+func handleUpdateEnv(msg queue.DeploymentMessage) {
+	log.Printf("🔧 Environment update received for Deployment ID: %s", msg.DeploymentID)
+
+	w, err := store.ReadWorker(msg.DeploymentID)
+	if err != nil {
+		failUpdateEnv(msg, "failed", errCodeEnvUpdateFailed, fmt.Errorf("failed to read deployment: %w", err))
+		return
+	}
+	if w == nil {
+		failUpdateEnv(msg, "failed", errCodeEnvUpdateFailed, errors.New("deployment not found"))
+		return
+	}
+	if w.ComposePath.Valid {
+		failUpdateEnv(msg, w.Status, errCodeInvalidEnv, errors.New("the services of a compose stack take their environment from the compose file"))
+		return
+	}
+	if err := builder.ValidateEnv(msg.Env, msg.Secrets, msg.UnsetEnv); err != nil {
+		failUpdateEnv(msg, w.Status, errCodeInvalidEnv, err)
+		return
+	}
+
+	var vars []store.EnvVar
+	for name, value := range msg.Env {
+		vars = append(vars, store.EnvVar{Name: name, Value: value})
+	}
+	for name, value := range msg.Secrets {
+		vars = append(vars, store.EnvVar{Name: name, Value: value, Secret: true})
+	}
+	if err := store.UpdateEnv(msg.DeploymentID, vars, msg.UnsetEnv); err != nil {
+		failUpdateEnv(msg, w.Status, errCodeEnvUpdateFailed, err)
+		return
+	}
+
+	plain, secret, err := store.CountEnv(msg.DeploymentID)
+	if err != nil {
+		log.Printf("⚠️ Failed to count the variables of %s: %v", msg.DeploymentID, err)
+	}
+	message := fmt.Sprintf("environment updated, %d variable(s) and %d secret(s)", plain, secret)
+
+	running, err := builder.IsDeploymentRunning(msg.DeploymentID)
+	if err != nil {
+		failUpdateEnv(msg, w.Status, errCodeEnvUpdateFailed, err)
+		return
+	}
+	if !running {
+		log.Printf("✅ Environment of %s updated, it is used on the next trigger", msg.DeploymentID)
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: msg.DeploymentID,
+			Status:       w.Status,
+			Message:      message,
+		})
+		return
+	}
+
+	// the environment of a container is fixed when it is created, so it is replaced
+	if err := builder.StopDeployment(msg.DeploymentID); err != nil {
+		failUpdateEnv(msg, w.Status, errCodeEnvUpdateFailed, err)
+		return
+	}
+	store.SetContainer(msg.DeploymentID, "")
+
+	container, err := startDeploymentContainer(w, releaseImage(w, w.CurrentRelease), int(w.CurrentRelease.Int64))
+	if err != nil {
+		store.SetRunState(msg.DeploymentID, "failed")
+		failUpdateEnv(msg, "failed", errCodeEnvUpdateFailed, err)
+		return
+	}
+	resetCrashes(msg.DeploymentID)
+
+	log.Printf("✅ Environment of %s updated, container restarted", msg.DeploymentID)
+	containerStarted(w, container, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       "running",
+		Message:      message + ", container restarted",
+	})
+}
+
+// startDeploymentContainer starts the image as the deployment's container, with its stored port, environment and limits
+func startDeploymentContainer(w *store.Worker, image string, release int) (string, error) {
+	var containerPort *int
+	if w.Port.Valid {
+		port := int(w.Port.Int64)
+		containerPort = &port
+	}
+
+	env, err := containerEnv(w.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return builder.StartContainer(w.DeploymentID, image, release, env, containerResources(w), containerPort)
+}
+
+// containerEnv is the stored environment of a deployment as KEY=value, secrets decrypted
+func containerEnv(deploymentID string) ([]string, error) {
+	vars, err := store.ReadEnv(deploymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the environment: %w", err)
+	}
+
+	env := make([]string, 0, len(vars))
+	for _, v := range vars {
+		env = append(env, v.Name+"="+v.Value)
+	}
+	return env, nil
+}
+
+func failUpdateEnv(msg queue.DeploymentMessage, status, code string, err error) {
+	log.Printf("❌ Environment update failed for %s: %v", msg.DeploymentID, err)
+
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: msg.DeploymentID,
+		Status:       status,
+		ErrorCode:    code,
+		Error:        err.Error(),
+	})
+}
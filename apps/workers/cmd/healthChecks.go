@@ -0,0 +1,282 @@
+// the health check of a deployment comes with the build message and is kept in the worker row as json. a container
+// with a check is reported starting until the check passes, then running. a running one is checked for as long as it
+// runs and reported unhealthy when the check keeps failing, and running again once it passes again.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"worker/internal/builder"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// error codes of health checks
+const (
+	errCodeInvalidHealthCheck = "INVALID_HEALTH_CHECK"
+	errCodeHealthCheckFailed  = "HEALTH_CHECK_FAILED"
+)
+
+// healthMonitors cancel the health check running for a deployment, there is at most one per deployment
+var healthMonitors = struct {
+	sync.Mutex
+	cancel map[string]context.CancelFunc
+}{cancel: make(map[string]context.CancelFunc)}
+
+// validateHealthCheck checks the health check of a message, no check is always valid
+func validateHealthCheck(msg queue.DeploymentMessage) error {
+	if msg.HealthCheck == nil {
+		return nil
+	}
+	h, err := toBuilderHealthCheck(*msg.HealthCheck)
+	if err != nil {
+		return err
+	}
+	return builder.ValidateHealthCheck(h, msg.PortNumber != "")
+}
+
+// jsonHealthCheck stores the health check of a message, nothing when there is none
+func jsonHealthCheck(h *queue.HealthCheck) sql.NullString {
+	if h == nil {
+		return sql.NullString{Valid: false}
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return sql.NullString{Valid: false}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// decodeHealthCheck reads a health check stored with jsonHealthCheck, nil when there is none or it can't be read
+func decodeHealthCheck(w *store.Worker) *queue.HealthCheck {
+	if !w.HealthCheck.Valid || w.HealthCheck.String == "" {
+		return nil
+	}
+
+	var h queue.HealthCheck
+	if err := json.Unmarshal([]byte(w.HealthCheck.String), &h); err != nil {
+		log.Printf("⚠️ Invalid health check stored for %s, not checking it: %v", w.DeploymentID, err)
+		return nil
+	}
+	return &h
+}
+
+// deploymentHealthCheck reads the stored health check of a deployment, false when it has none or it can't be used
+func deploymentHealthCheck(w *store.Worker) (builder.HealthCheck, bool) {
+	stored := decodeHealthCheck(w)
+	if stored == nil {
+		return builder.HealthCheck{}, false
+	}
+
+	h, err := toBuilderHealthCheck(*stored)
+	if err == nil {
+		err = builder.ValidateHealthCheck(h, w.Port.Valid)
+	}
+	if err != nil {
+		log.Printf("⚠️ Invalid health check stored for %s, not checking it: %v", w.DeploymentID, err)
+		return builder.HealthCheck{}, false
+	}
+	return h.WithDefaults(), true
+}
+
+func toBuilderHealthCheck(h queue.HealthCheck) (builder.HealthCheck, error) {
+	check := builder.HealthCheck{
+		Type:               h.Type,
+		Path:               h.Path,
+		Command:            h.Command,
+		HealthyThreshold:   h.HealthyThreshold,
+		UnhealthyThreshold: h.UnhealthyThreshold,
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		into  *time.Duration
+	}{
+		{"startPeriod", h.StartPeriod, &check.StartPeriod},
+		{"interval", h.Interval, &check.Interval},
+		{"timeout", h.Timeout, &check.Timeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return builder.HealthCheck{}, fmt.Errorf("invalid health check %s %q: %w", d.name, d.value, err)
+		}
+		*d.into = parsed
+	}
+	return check, nil
+}
+
+// containerStarted records the new container of a deployment and reports it. without a health check it is running
+// right away, with one it is starting and the check reports running once it passes.
+func containerStarted(w *store.Worker, containerID string, response queue.Response) {
+	stopHealthCheck(w.DeploymentID)
+	store.SetContainer(w.DeploymentID, containerID)
+
+	check, ok := deploymentHealthCheck(w)
+	if !ok {
+		store.SetRunState(w.DeploymentID, "running")
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+		return
+	}
+
+	store.SetRunState(w.DeploymentID, "starting")
+	response.Status = "starting"
+	if response.Message != "" {
+		response.Message += ", "
+	}
+	response.Message += fmt.Sprintf("waiting for the %s health check", check.Type)
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+
+	startHealthCheck(w, containerID, check, time.Now())
+}
+
+// startHealthCheck checks the container in the background, replacing the check running for the deployment
+func startHealthCheck(w *store.Worker, containerID string, check builder.HealthCheck, started time.Time) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	healthMonitors.Lock()
+	if previous, ok := healthMonitors.cancel[w.DeploymentID]; ok {
+		previous()
+	}
+	healthMonitors.cancel[w.DeploymentID] = cancel
+	healthMonitors.Unlock()
+
+	go func() {
+		defer cancel()
+		watchHealth(ctx, w.DeploymentID, containerID, int(w.Port.Int64), check, started)
+	}()
+}
+
+// stopHealthCheck ends the health check of a deployment, if it has one running
+func stopHealthCheck(deploymentID string) {
+	healthMonitors.Lock()
+	defer healthMonitors.Unlock()
+
+	if cancel, ok := healthMonitors.cancel[deploymentID]; ok {
+		cancel()
+		delete(healthMonitors.cancel, deploymentID)
+	}
+}
+
+// watchHealth probes the container every interval until ctx is done or the deployment moves on to another container.
+// it follows the container's run state, a rebuild of the deployment doesn't stop it. failures within the start
+// period don't count.
+func watchHealth(ctx context.Context, deploymentID string, containerID string, port int, check builder.HealthCheck, started time.Time) {
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+
+	passes, failures := 0, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		probeErr := builder.ProbeHealth(ctx, containerID, port, check)
+		if ctx.Err() != nil {
+			return
+		}
+
+		w, err := store.ReadWorker(deploymentID)
+		if err != nil {
+			log.Printf("⚠️ Failed to read deployment %s: %v", deploymentID, err)
+			continue
+		}
+		if w == nil || !w.ContainerName.Valid || w.ContainerName.String != containerID {
+			return
+		}
+		state := w.RunState.String
+		switch state {
+		case "starting", "running", "unhealthy":
+		default:
+			return // stopped, crashed, restarting, the supervisor has it
+		}
+
+		if probeErr == nil {
+			passes, failures = passes+1, 0
+			if state != "running" && passes >= check.HealthyThreshold {
+				reportHealthy(deploymentID, state)
+			}
+			continue
+		}
+
+		passes = 0
+		if errors.Is(probeErr, builder.ErrNotRunning) {
+			continue // the supervisor reports a stopped container
+		}
+		if state == "starting" && time.Since(started) < check.StartPeriod {
+			continue
+		}
+		failures++
+		if state != "unhealthy" && failures >= check.UnhealthyThreshold {
+			reportUnhealthy(deploymentID, failures, probeErr)
+		}
+	}
+}
+
+func reportHealthy(deploymentID string, status string) {
+	message := "health check passed"
+	if status == "unhealthy" {
+		message = "healthy again"
+	}
+	log.Printf("💚 Deployment %s: %s", deploymentID, message)
+
+	store.SetRunState(deploymentID, "running")
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: deploymentID,
+		Status:       "running",
+		Message:      message,
+	})
+}
+
+func reportUnhealthy(deploymentID string, failures int, err error) {
+	log.Printf("💔 Deployment %s is unhealthy after %d failed health check(s): %v", deploymentID, failures, err)
+
+	store.SetRunState(deploymentID, "unhealthy")
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: deploymentID,
+		Status:       "unhealthy",
+		ErrorCode:    errCodeHealthCheckFailed,
+		Error:        err.Error(),
+		Message:      fmt.Sprintf("health check failed %d time(s) in a row", failures),
+	})
+}
+
+// resumeHealthChecks picks up the health checks of the deployments that were running when the worker last stopped.
+// a deployment that was still starting gets its start period again.
+func resumeHealthChecks() {
+	workers, err := store.ListWorkersByRunState("starting", "running", "unhealthy")
+	if err != nil {
+		log.Printf("⚠️ Failed to list running deployments: %v", err)
+		return
+	}
+
+	for i := range workers {
+		w := &workers[i]
+		if !w.ContainerName.Valid {
+			continue
+		}
+		check, ok := deploymentHealthCheck(w)
+		if !ok {
+			continue
+		}
+		started := time.Time{}
+		if w.RunState.String == "starting" {
+			started = time.Now()
+		}
+		startHealthCheck(w, w.ContainerName.String, check, started)
+	}
+}
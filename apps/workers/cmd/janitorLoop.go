@@ -0,0 +1,54 @@
+// this runs in its own goroutine and cleans up old workspaces under tmp/repos every WORKER_GC_INTERVAL
+
+package main
+
+import (
+	"log"
+	"time"
+	"worker/internal/buildlog"
+	"worker/internal/config"
+	"worker/internal/janitor"
+)
+
+func janitorLoop() {
+	if config.Current.GCInterval <= 0 {
+		log.Println("ℹ️ Workspace janitor disabled")
+		return
+	}
+
+	if config.Current.GCMaxAge < config.Current.CloneTimeout {
+		log.Printf("⚠️ WORKER_GC_MAX_AGE (%s) is shorter than the clone timeout (%s), running clones might be removed", config.Current.GCMaxAge, config.Current.CloneTimeout)
+	}
+
+	ticker := time.NewTicker(config.Current.GCInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+
+		report, err := janitor.Sweep(janitor.Options{
+			MaxAge: config.Current.GCMaxAge,
+			DryRun: config.Current.GCDryRun,
+		})
+		if err != nil {
+			log.Printf("⚠️ Workspace janitor failed: %v", err)
+			continue
+		}
+
+		if config.Current.GCDryRun {
+			log.Printf("🧹 Janitor dry run, would clean: %s", report)
+			continue
+		}
+		log.Printf("🧹 Janitor cleaned: %s", report)
+
+		// build logs have their own retention
+		if config.Current.BuildLogMaxAge > 0 {
+			removed, err := buildlog.PruneOld(config.Current.BuildLogMaxAge)
+			if err != nil {
+				log.Printf("⚠️ Failed to prune build logs: %v", err)
+			} else if removed > 0 {
+				log.Printf("🧹 Removed %d build log(s) older than %s", removed, config.Current.BuildLogMaxAge)
+			}
+		}
+	}
+}
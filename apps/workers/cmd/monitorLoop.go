@@ -0,0 +1,283 @@
+// this supervises the containers of the deployments. when a container stops without the worker stopping it, the
+// api is told (crashed, oom-killed or exited, with the exit code and the last lines of output) and the container is
+// started again according to the deployment's restart policy. the wait before a restart doubles with every crash,
+// and too many crashes in a row stop the restarts with crash-loop.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"worker/internal/builder"
+	"worker/internal/config"
+	"worker/internal/queue"
+	"worker/internal/store"
+	"worker/internal/utils"
+)
+
+// restart policies of a deployment
+const (
+	restartNo        = "no"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// error codes sent with the status of a container that stopped on its own
+const (
+	errCodeCrashed       = "CONTAINER_CRASHED"
+	errCodeOOMKilled     = "OOM_KILLED"
+	errCodeCrashLoop     = "CRASH_LOOP"
+	errCodeRestartFailed = "RESTART_FAILED"
+)
+
+// how long to wait before watching again when the event stream broke
+const monitorRetryDelay = 5 * time.Second
+
+// crashes are the recent crash times per deployment, they decide the backoff and the crash loop
+var crashes = struct {
+	sync.Mutex
+	times map[string][]time.Time
+}{times: make(map[string][]time.Time)}
+
+// monitorLoop follows the container events until ctx is done
+func monitorLoop(ctx context.Context) {
+	for {
+		// whatever happened while nobody was watching, e.g. while the worker was down
+		sweepContainers()
+
+		events, errs := builder.WatchContainers(ctx)
+		for event := range events {
+			handleContainerEvent(event)
+		}
+
+		err := <-errs
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("⚠️ Container event stream ended: %v, watching again in %s", err, monitorRetryDelay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(monitorRetryDelay):
+		}
+	}
+}
+
+func handleContainerEvent(event builder.ContainerEvent) {
+	deploymentID := event.Labels[builder.LabelDeployment]
+
+	switch event.Action {
+	case "oom":
+		// a process of the container was killed, the container itself only stops when that was its main process
+		log.Printf("⚠️ A process in container %s of deployment %s ran out of memory", event.Labels["name"], deploymentID)
+	case "die":
+		if builder.StoppedByWorker(event.ID) {
+			return
+		}
+		c, err := builder.InspectContainer(event.ID)
+		if err != nil {
+			log.Printf("⚠️ Failed to inspect stopped container %s: %v", event.ID, err)
+			return
+		}
+		handleContainerExit(deploymentID, c)
+	}
+}
+
+// sweepContainers handles the containers that stopped while the worker wasn't watching
+func sweepContainers() {
+	containers, err := builder.ManagedContainers()
+	if err != nil {
+		log.Printf("⚠️ Failed to list the deployment containers: %v", err)
+		return
+	}
+
+	for _, listed := range containers {
+		if listed.Running || builder.StoppedByWorker(listed.ID) {
+			continue
+		}
+		c, err := builder.InspectContainer(listed.ID)
+		if err != nil {
+			continue // removed in the meantime
+		}
+		handleContainerExit(c.Labels[builder.LabelDeployment], c)
+	}
+}
+
+// handleContainerExit reports a container that stopped on its own and schedules its restart
+func handleContainerExit(deploymentID string, c *builder.ContainerInfo) {
+	w, err := store.ReadWorker(deploymentID)
+	if err != nil {
+		log.Printf("⚠️ Failed to read deployment %s: %v", deploymentID, err)
+		return
+	}
+	// only the container the deployment is running counts, a replaced or stopped one was already dealt with. the
+	// build stage in status doesn't matter, the old container keeps serving while the deployment is rebuilt.
+	if w == nil || (w.ContainerName.Valid && w.ContainerName.String != c.ID) {
+		return
+	}
+	switch w.RunState.String {
+	case "running", "starting", "unhealthy":
+	default:
+		return
+	}
+	stopHealthCheck(deploymentID)
+
+	policy := restartPolicy(w)
+	response := queue.Response{
+		DeploymentID: deploymentID,
+		Status:       "crashed",
+		ErrorCode:    errCodeCrashed,
+		Error:        fmt.Sprintf("container %s exited with code %d", c.Name, c.ExitCode),
+		ExitCode:     c.ExitCode,
+		Logs:         crashLogs(deploymentID, c.ID),
+	}
+	switch {
+	case c.OOMKilled:
+		limit := "none"
+		if c.Memory > 0 {
+			limit = utils.HumanBytes(c.Memory)
+		}
+		response.Status = "oom-killed"
+		response.ErrorCode = errCodeOOMKilled
+		response.Error = fmt.Sprintf("container %s was killed for running out of memory (limit %s)", c.Name, limit)
+	case c.ExitCode == 0:
+		response.Status = "exited"
+		response.ErrorCode = ""
+		response.Error = ""
+		response.Message = fmt.Sprintf("container %s exited with code 0", c.Name)
+	}
+
+	restart := policy == restartAlways || (policy == restartOnFailure && (c.ExitCode != 0 || c.OOMKilled))
+	var delay time.Duration
+	if restart {
+		var count int
+		count, delay = recordCrash(deploymentID)
+		if config.Current.CrashLoopRestarts > 0 && count > config.Current.CrashLoopRestarts {
+			restart = false
+			response.Status = "crash-loop"
+			response.ErrorCode = errCodeCrashLoop
+			response.Message = fmt.Sprintf("stopped %d times within %s, not restarted anymore until the next trigger", count, config.Current.CrashLoopWindow)
+		} else {
+			response.Message = fmt.Sprintf("restart %d in %s", count, delay)
+		}
+	}
+
+	log.Printf("💥 Deployment %s: container %s stopped with code %d (%s), %s", deploymentID, c.Name, c.ExitCode, response.Status, response.Message)
+	store.SetRunState(deploymentID, response.Status)
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, response)
+
+	if restart {
+		time.AfterFunc(delay, func() { restartDeployment(deploymentID, c.ID, response.Status) })
+	}
+}
+
+// restartDeployment starts the container again, unless the deployment was stopped, triggered or deleted meanwhile
+func restartDeployment(deploymentID string, stoppedID string, status string) {
+	w, err := store.ReadWorker(deploymentID)
+	if err != nil {
+		log.Printf("⚠️ Failed to read deployment %s: %v", deploymentID, err)
+		return
+	}
+	if w == nil || w.RunState.String != status || (w.ContainerName.Valid && w.ContainerName.String != stoppedID) {
+		log.Printf("ℹ️ Deployment %s changed since its container stopped, not restarting it", deploymentID)
+		return
+	}
+
+	container, err := startDeploymentContainer(w, releaseImage(w, w.CurrentRelease), int(w.CurrentRelease.Int64))
+	if err != nil {
+		log.Printf("❌ Failed to restart deployment %s: %v", deploymentID, err)
+		store.SetRunState(deploymentID, "failed")
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: deploymentID,
+			Status:       "failed",
+			ErrorCode:    errCodeRestartFailed,
+			Error:        err.Error(),
+		})
+		return
+	}
+
+	log.Printf("🔁 Restarted deployment %s", deploymentID)
+
+	containerStarted(w, container, queue.Response{
+		DeploymentID: deploymentID,
+		Status:       "running",
+		Message:      "restarted after the container stopped",
+	})
+}
+
+// recordCrash counts the crashes of the deployment within the crash loop window and returns the wait before the
+// next restart: the backoff doubled for every earlier crash in the window
+func recordCrash(deploymentID string) (int, time.Duration) {
+	crashes.Lock()
+	defer crashes.Unlock()
+
+	now := time.Now()
+	var recent []time.Time
+	for _, at := range crashes.times[deploymentID] {
+		if now.Sub(at) < config.Current.CrashLoopWindow {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	crashes.times[deploymentID] = recent
+
+	delay := config.Current.RestartBackoff
+	for i := 1; i < len(recent) && delay < config.Current.RestartBackoffMax; i++ {
+		delay *= 2
+	}
+	return len(recent), min(delay, config.Current.RestartBackoffMax)
+}
+
+// resetCrashes forgets the crashes of a deployment, a trigger, rollback or new environment starts over
+func resetCrashes(deploymentID string) {
+	crashes.Lock()
+	defer crashes.Unlock()
+	delete(crashes.times, deploymentID)
+}
+
+// crashLogs is the end of the container's output with the deployment's secrets hidden, empty when it can't be read
+func crashLogs(deploymentID string, containerID string) string {
+	if config.Current.CrashLogLines <= 0 {
+		return ""
+	}
+
+	vars, err := store.ReadEnv(deploymentID)
+	if err != nil {
+		log.Printf("⚠️ Not sending the logs of %s, its secrets can't be read to hide them: %v", deploymentID, err)
+		return ""
+	}
+	secrets := make(map[string]string)
+	for _, v := range vars {
+		if v.Secret {
+			secrets[v.Name] = v.Value
+		}
+	}
+
+	logs, err := builder.ContainerLogTail(containerID, config.Current.CrashLogLines, secrets)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+		return ""
+	}
+	return logs
+}
+
+// restartPolicy is the deployment's policy, on-failure when it has none
+func restartPolicy(w *store.Worker) string {
+	if w.RestartPolicy.Valid && w.RestartPolicy.String != "" {
+		return w.RestartPolicy.String
+	}
+	return restartOnFailure
+}
+
+// validateRestartPolicy checks the policy of a message, empty takes the default
+func validateRestartPolicy(policy string) error {
+	switch policy {
+	case "", restartNo, restartOnFailure, restartAlways:
+		return nil
+	}
+	return fmt.Errorf("invalid restart policy %q, expected %s, %s or %s", policy, restartNo, restartOnFailure, restartAlways)
+}
@@ -0,0 +1,158 @@
+// recoverPipelines runs once on startup, before the builder takes any job. a worker that was killed in the middle of
+// a clone or a build leaves deployments stuck in cloning, cloned or building with nobody working on them.
+// this looks at each of them and picks the pipeline up where it can:
+// 1. clone never finished, or its workspace is gone -> clone again from the source stored in the row
+// 2. build was running -> the job goes back to cloned and is built again, unless another worker still holds its lease
+// 3. cloned and waiting -> handed to the builder
+// the backend gets the corrected status for every deployment that was touched.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"worker/internal/config"
+	"worker/internal/queue"
+	"worker/internal/repo"
+	"worker/internal/store"
+	"worker/internal/tracker"
+)
+
+func recoverPipelines() {
+	workers, err := store.ListWorkersByStatus("cloning", store.StageCloned, store.StageBuilding)
+	if err != nil {
+		log.Println("⚠️ Failed to look for interrupted deployments:", err)
+		return
+	}
+
+	if len(workers) == 0 {
+		return
+	}
+
+	log.Printf("🔁 Resuming %d interrupted deployment(s)\n", len(workers))
+
+	for _, w := range workers {
+		if err := recoverDeployment(w); err != nil {
+			log.Printf("⚠️ Failed to resume deployment %s: %v\n", w.DeploymentID, err)
+		}
+	}
+}
+
+func recoverDeployment(w store.Worker) error {
+	job, err := store.LatestJob(w.DeploymentID)
+	if err != nil {
+		return err
+	}
+
+	// the row only says cloning while no job exists for the new workspace, an older job belongs to the previous clone
+	if w.Status == "cloning" || job == nil || !workspaceExists(job) {
+		return recloneDeployment(w)
+	}
+
+	switch job.Stage {
+	case store.StageBuilding:
+		// workers share the database, a job leased to another worker that keeps renewing it is still being built.
+		// a lease of this worker before the restart (WORKER_ID) or one that ran out has nobody building it
+		reset, err := store.ResetJob(job, config.Current.WorkerID)
+		if err != nil {
+			return err
+		}
+		if !reset {
+			log.Printf("🔁 %s is leased to %s, the sweep takes it over if the lease runs out\n", w.DeploymentID, job.LeaseOwner.String)
+			return nil
+		}
+		log.Printf("🔁 Build of %s was interrupted, building again\n", w.DeploymentID)
+		publishRecovered(w.DeploymentID, store.StageCloned, "build was interrupted by a worker restart, building again")
+		enqueueBuild(job.ID)
+
+	case store.StageCloned:
+		log.Printf("🔁 %s was cloned but not built yet\n", w.DeploymentID)
+		if w.Status != store.StageCloned {
+			store.SetStatus(w.DeploymentID, store.StageCloned)
+			publishRecovered(w.DeploymentID, store.StageCloned, "")
+		}
+		enqueueBuild(job.ID)
+
+	default:
+		// the job already finished, only the row is behind
+		if err := store.SetStatus(w.DeploymentID, job.Stage); err != nil {
+			return err
+		}
+		publishRecovered(w.DeploymentID, job.Stage, "")
+	}
+
+	return nil
+}
+
+// recloneDeployment starts the clone again from the source recorded in the worker row
+func recloneDeployment(w store.Worker) error {
+	// the secrets only live in this process, the message never goes through the queue
+	secrets, err := store.OpenBuildSecrets(&w)
+	if err != nil {
+		return err
+	}
+
+	msg := queue.DeploymentMessage{
+		Type:            "build",
+		DeploymentID:    w.DeploymentID,
+		Repository:      w.Repository.String,
+		SourceType:      w.SourceType.String,
+		SourcePath:      w.SourcePath.String,
+		Branch:          w.Branch.String,
+		DockerfilePath:  w.DockerfilePath.String,
+		ComposeFilePath: w.ComposePath.String,
+		PublicService:   w.PublicService.String,
+		StartCommand:    w.StartCommand.String,
+		NoDockerfileGen: w.NoDockerfileGen,
+		ContextDir:      w.ContextDir.String,
+		AutoDeploy:      w.AutoDeploy,
+		BuildArgs:       decodeObject(w.BuildArgs),
+		BuildTarget:     w.BuildTarget.String,
+		BuildSecrets:    secrets,
+		CacheMounts:     decodeList(w.CacheMounts),
+		WatchPaths:      decodeList(w.WatchPaths),
+		Resources:       decodeResources(&w),
+		RestartPolicy:   w.RestartPolicy.String,
+		HealthCheck:     decodeHealthCheck(&w),
+		Force:           true, // the previous attempt never got to deploy, so there is nothing to compare with
+	}
+
+	if w.Port.Valid {
+		msg.PortNumber = strconv.FormatInt(w.Port.Int64, 10)
+	}
+
+	if sourceLocation(msg) == "" {
+		// rows written by older workers don't know where their source came from
+		if err := store.SetStatus(w.DeploymentID, store.StageFailed); err != nil {
+			return err
+		}
+		queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+			DeploymentID: w.DeploymentID,
+			Status:       store.StageFailed,
+			ErrorCode:    repo.ErrCodeSourceInvalid,
+			Error:        fmt.Sprintf("deployment was %s when the worker stopped and its source is unknown, send a new build", w.Status),
+		})
+		return nil
+	}
+
+	log.Printf("🔁 Cloning %s again, the previous clone did not finish\n", w.DeploymentID)
+	publishRecovered(w.DeploymentID, "cloning", "clone was interrupted by a worker restart, cloning again")
+	handleCloning(msg)
+	return nil
+}
+
+func workspaceExists(job *store.Job) bool {
+	_, err := os.Stat(filepath.Join(tracker.WorkspaceDir, job.Workspace))
+	return err == nil
+}
+
+func publishRecovered(deploymentID, status, message string) {
+	queue.PublishResponseToQueue(queue.ResultRoutingKey, queue.Response{
+		DeploymentID: deploymentID,
+		Status:       status,
+		Message:      message,
+	})
+}
@@ -0,0 +1,151 @@
+// the image name of a deployment (blacktree/<slug>-<id8>) points at its newest release, or the one it was rolled back
+// to. every build and every pulled image also gets a tag of its own, <commit>-<release number>, that is never moved,
+// so older releases stay around for a rollback until they are pruned. a release is only the release in use once a
+// trigger started its container, until then the container of the previous one keeps running.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"worker/internal/builder"
+	"worker/internal/config"
+	"worker/internal/store"
+	"worker/internal/utils"
+)
+
+// recordRelease tags the image the deployment name points at as its next release, the next trigger starts it.
+// jobID is 0 for pulled images, they have no build.
+func recordRelease(w *store.Worker, jobID int64, imageID string) (*store.Release, error) {
+	imageName := w.ImageName.String
+	if imageName == "" {
+		return nil, fmt.Errorf("deployment %s has no image name", w.DeploymentID)
+	}
+
+	version := "build" // archives and local folders have no commit
+	if w.SourceImage.String != "" {
+		version = "image"
+	} else if w.CommitSHA.String != "" {
+		version = short(w.CommitSHA.String)
+	}
+
+	release := store.Release{
+		DeploymentID: w.DeploymentID,
+		ImageID:      utils.ToNullString(imageID),
+		CommitSHA:    w.CommitSHA,
+		Digest:       w.ImageDigest, // pulled images already have one, built ones get it when they are pushed
+	}
+	if jobID != 0 {
+		release.JobID = sql.NullInt64{Int64: jobID, Valid: true}
+	}
+
+	rel, err := store.CreateRelease(release, func(number int) string {
+		return fmt.Sprintf("%s:%s-%d", imageName, version, number)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := builder.TagImage(imageName, rel.Image); err != nil {
+		store.DeleteRelease(w.DeploymentID, rel.Number)
+		return nil, err
+	}
+
+	if err := store.SetImageRelease(w.DeploymentID, rel.Number); err != nil {
+		return nil, err
+	}
+
+	log.Printf("🏷️ Release %d of %s is %s", rel.Number, w.DeploymentID, rel.Image)
+	pruneReleases(w.DeploymentID, rel.Number, int(w.CurrentRelease.Int64))
+	return rel, nil
+}
+
+// releaseImage is the immutable image of a release of the deployment. without a release (an image pulled by a trigger)
+// or when its record is gone, it is the image name.
+func releaseImage(w *store.Worker, number sql.NullInt64) string {
+	if !number.Valid {
+		return w.ImageName.String
+	}
+
+	rel, err := store.ReadRelease(w.DeploymentID, int(number.Int64))
+	if err != nil || rel == nil {
+		log.Printf("⚠️ Failed to read release %d of %s, using %s: %v", number.Int64, w.DeploymentID, w.ImageName.String, err)
+		return w.ImageName.String
+	}
+	return rel.Image
+}
+
+// pushRelease pushes the image of a built release to WORKER_REGISTRY and records the digest it got there
+func pushRelease(deploymentID string, rel *store.Release) (string, error) {
+	digest, err := builder.PushImage(rel.Image)
+	if err != nil {
+		return "", err
+	}
+
+	if err := store.SetReleaseDigest(deploymentID, rel.Number, digest); err != nil {
+		return digest, fmt.Errorf("pushed as %s but failed to record it: %w", digest, err)
+	}
+	log.Printf("📤 Release %d of %s is %s", rel.Number, deploymentID, digest)
+	return digest, nil
+}
+
+// pullPinned pulls an image by its digest and names it name, the way the worker that pushed it knew it
+func pullPinned(digest, name string) error {
+	if err := builder.PullImage(digest); err != nil {
+		return err
+	}
+	return builder.TagImage(digest, name)
+}
+
+// pruneReleases removes the images of the releases past WORKER_RELEASES_KEEP, the newest release and the release in
+// use are always kept
+func pruneReleases(deploymentID string, newest, current int) {
+	keep := config.Current.ReleasesKeep
+	if keep <= 0 {
+		return
+	}
+
+	releases, err := store.ListReleases(deploymentID)
+	if err != nil {
+		log.Printf("⚠️ Failed to list releases of %s: %v", deploymentID, err)
+		return
+	}
+
+	if len(releases) <= keep {
+		return
+	}
+
+	for _, rel := range releases[keep:] {
+		if rel.Number == newest || rel.Number == current {
+			continue
+		}
+
+		// only drops the tag when the image has other names, e.g. a rebuild of the same commit that was fully cached
+		if err := builder.RemoveImage(rel.Image); err != nil {
+			log.Printf("⚠️ Failed to remove image of release %d of %s: %v", rel.Number, deploymentID, err)
+			continue
+		}
+		store.DeleteRelease(deploymentID, rel.Number)
+		log.Printf("🧹 Pruned release %d of %s (%s)", rel.Number, deploymentID, rel.Image)
+	}
+}
+
+// removeReleases removes the images of every release of a deployment that is going away
+func removeReleases(deploymentID string) {
+	releases, err := store.ListReleases(deploymentID)
+	if err != nil {
+		log.Printf("⚠️ Failed to list releases of %s: %v", deploymentID, err)
+		return
+	}
+
+	for _, rel := range releases {
+		if err := builder.RemoveImage(rel.Image); err != nil {
+			log.Printf("⚠️ Failed to remove image of release %d of %s: %v", rel.Number, deploymentID, err)
+		}
+	}
+
+	if err := store.DeleteReleases(deploymentID); err != nil {
+		log.Printf("⚠️ Failed to delete releases of %s: %v", deploymentID, err)
+	}
+}
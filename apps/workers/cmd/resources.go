@@ -0,0 +1,70 @@
+// the container limits of a deployment come with the build message and are kept in the worker row as json
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"worker/internal/builder"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// sent back when the resource limits of the message can't be used
+const errCodeInvalidResources = "INVALID_RESOURCES"
+
+// validateResources checks the limits of a message, no limits are always valid
+func validateResources(r *queue.Resources) error {
+	if r == nil {
+		return nil
+	}
+	return builder.ValidateResources(toBuilderResources(*r))
+}
+
+// jsonResources stores the limits of a message, nothing when there are none
+func jsonResources(r *queue.Resources) sql.NullString {
+	if r == nil {
+		return sql.NullString{Valid: false}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return sql.NullString{Valid: false}
+	}
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// containerResources reads the stored limits of a deployment, unreadable ones fall back to the worker defaults
+func containerResources(w *store.Worker) builder.Resources {
+	r := decodeResources(w)
+	if r == nil {
+		return builder.Resources{}
+	}
+	return toBuilderResources(*r)
+}
+
+// decodeResources reads limits stored with jsonResources, nil when there are none or they can't be read
+func decodeResources(w *store.Worker) *queue.Resources {
+	if !w.Resources.Valid {
+		return nil
+	}
+
+	var r queue.Resources
+	if err := json.Unmarshal([]byte(w.Resources.String), &r); err != nil {
+		log.Printf("⚠️ Invalid resource limits stored for %s, using the defaults: %v", w.DeploymentID, err)
+		return nil
+	}
+	return &r
+}
+
+func toBuilderResources(r queue.Resources) builder.Resources {
+	return builder.Resources{
+		CPUs:         r.CPUs,
+		CPUShares:    r.CPUShares,
+		MemoryMB:     r.MemoryMB,
+		MemorySwapMB: r.MemorySwapMB,
+		PidsLimit:    r.PidsLimit,
+		Ulimits:      r.Ulimits,
+	}
+}
@@ -5,3 +5,8 @@ go 1.21.1
 require github.com/streadway/amqp v1.1.0
 
 require github.com/mattn/go-sqlite3 v1.14.28
+
+require (
+	golang.org/x/net v0.30.0
+	golang.org/x/text v0.19.0 // indirect
+)
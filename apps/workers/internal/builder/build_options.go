@@ -0,0 +1,101 @@
+// build arguments, targets and secrets come from the deployment message, so they are checked before anything
+// is cloned, and the build output is scrubbed of secret values before it reaches a log.
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	buildArgName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	secretID     = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	targetName   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// ValidateBuildOptions checks the names of build args, secrets and the target
+func ValidateBuildOptions(args map[string]string, target string, secrets map[string]string) error {
+	for name, value := range args {
+		if !buildArgName.MatchString(name) {
+			return fmt.Errorf("invalid build arg name %q", name)
+		}
+		// build args are stored in the image history, a secret passed as one would leak
+		for id, secret := range secrets {
+			if name == id || (secret != "" && strings.Contains(value, secret)) {
+				return fmt.Errorf("build arg %q carries the build secret %q, use a secret mount instead", name, id)
+			}
+		}
+	}
+
+	for id, value := range secrets {
+		if !secretID.MatchString(id) {
+			return fmt.Errorf("invalid build secret id %q", id)
+		}
+		if value == "" {
+			return fmt.Errorf("build secret %q is empty", id)
+		}
+	}
+
+	if target != "" && !targetName.MatchString(target) {
+		return fmt.Errorf("invalid build target %q", target)
+	}
+	return nil
+}
+
+// Redactor replaces secret values with *** in everything written through it. it works on whole lines so a secret
+// split over two writes is still caught, call Flush at the end for the last line.
+type Redactor struct {
+	out     io.Writer
+	secrets []string
+	line    []byte
+}
+
+func NewRedactor(out io.Writer, secrets map[string]string) *Redactor {
+	r := &Redactor{out: out}
+	for _, value := range secrets {
+		if value != "" {
+			r.secrets = append(r.secrets, value)
+		}
+	}
+	return r
+}
+
+func (r *Redactor) Write(p []byte) (int, error) {
+	if len(r.secrets) == 0 {
+		return r.out.Write(p)
+	}
+
+	r.line = append(r.line, p...)
+	for {
+		i := bytes.IndexByte(r.line, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := io.WriteString(r.out, r.redact(string(r.line[:i+1]))); err != nil {
+			return 0, err
+		}
+		r.line = r.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes whatever is left after the last newline
+func (r *Redactor) Flush() error {
+	if len(r.line) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.out, r.redact(string(r.line)))
+	r.line = nil
+	return err
+}
+
+func (r *Redactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
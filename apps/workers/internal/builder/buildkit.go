@@ -0,0 +1,97 @@
+// builds that need buildkit: secret mounts (RUN --mount=type=secret,id=npm_token ...) and cache mounts (see cache.go).
+// secrets are never passed as build args, so they don't end up in a layer or in the image history.
+// docker builds these with the engine's own buildkit (version=2 of the build api), the legacy builder rejects --mount.
+// buildkit asks the client for each secret over a session (docker.StartSession) that stays open for the build.
+// podman's builder (buildah) understands --mount itself and reads secret files, its compat api takes them as a
+// query parameter.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"worker/internal/config"
+)
+
+// ErrBuildLimitsUnsupported is returned for a build the worker's build limits can't be applied to
+var ErrBuildLimitsUnsupported = errors.New("WORKER_BUILD_MEMORY_MB and WORKER_BUILD_CPUS can't be applied to this build")
+
+// ValidateBuildLimits refuses builds that would get around the build limits of the worker: docker's buildkit and
+// compose have no per build cpu or memory limits
+func ValidateBuildLimits(cacheMounts []string, secrets map[string]string, compose bool) error {
+	if config.Current.BuildMemory == 0 && config.Current.BuildCPUs == 0 {
+		return nil
+	}
+	if compose {
+		return fmt.Errorf("%w: compose builds have no build limits", ErrBuildLimitsUnsupported)
+	}
+	if _, docker := Current.(*dockerRuntime); docker && (len(cacheMounts) > 0 || len(secrets) > 0) {
+		return fmt.Errorf("%w: cache mounts and build secrets need docker's buildkit, which has no build limits", ErrBuildLimitsUnsupported)
+	}
+	return nil
+}
+
+func (r *dockerRuntime) buildWithBuildKit(ctx context.Context, opt BuildImageOptions, out io.Writer) (string, error) {
+	// buildkit has no per build cpu or memory limits, only the engine wide ones would apply
+	if opt.Memory > 0 || opt.CPUs > 0 {
+		return "", fmt.Errorf("%w: cache mounts and build secrets need docker's buildkit, which has no build limits", ErrBuildLimitsUnsupported)
+	}
+
+	build := buildOptions(opt)
+	build.BuildKit = true
+
+	// keep cache metadata in the image, so it can serve as --cache-from once it is pushed or pulled elsewhere
+	build.BuildArgs = make(map[string]string, len(opt.BuildArgs)+1)
+	for name, value := range opt.BuildArgs {
+		build.BuildArgs[name] = value
+	}
+	build.BuildArgs["BUILDKIT_INLINE_CACHE"] = "1"
+
+	if len(opt.Secrets) > 0 {
+		session, err := r.client.StartSession(ctx, opt.Secrets)
+		if err != nil {
+			return "", fmt.Errorf("failed to open a buildkit session for the build secrets: %w", err)
+		}
+		defer session.Close()
+		build.Session = session.ID
+	}
+
+	return r.client.BuildImage(ctx, build, out)
+}
+
+// Build on podman writes each secret to a private file for the engine to read, and removes them when the build is done
+func (r *podmanRuntime) Build(ctx context.Context, opt BuildImageOptions, out io.Writer) (string, error) {
+	build := buildOptions(opt)
+
+	if len(opt.Secrets) > 0 {
+		dir, err := os.MkdirTemp("", "blacktree-secrets-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+
+		for i, id := range sortedKeys(opt.Secrets) {
+			file := filepath.Join(dir, fmt.Sprint(i))
+			if err := os.WriteFile(file, []byte(opt.Secrets[id]), 0600); err != nil {
+				return "", fmt.Errorf("failed to write build secret %s: %w", id, err)
+			}
+			build.Secrets = append(build.Secrets, "id="+id+",src="+file)
+		}
+	}
+
+	return r.client.BuildImage(ctx, build, out)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
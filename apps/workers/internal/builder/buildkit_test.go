@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"worker/internal/config"
+	"worker/internal/docker"
+	"worker/internal/docker/dockertest"
+)
+
+func TestDockerBuildSecrets(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	rt := &dockerRuntime{client: docker.New(engine.Socket, "")}
+
+	dir := t.TempDir()
+	dockerfile := "FROM busybox\nRUN --mount=type=secret,id=npm_token npm ci\n"
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	_, err := rt.Build(context.Background(), BuildImageOptions{
+		ImageName:      "blacktree/app-1234abcd",
+		ContextDir:     dir,
+		DockerfilePath: filepath.Join(dir, "Dockerfile"),
+		Secrets:        map[string]string{"npm_token": "s3cret"},
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if secret := engine.Secrets()["npm_token"]; secret != "s3cret" {
+		t.Errorf("buildkit got the secret %q", secret)
+	}
+	build := engine.LastRequest("POST", "/build")
+	if build.Query["version"][0] != "2" || len(build.Query["session"]) != 1 {
+		t.Errorf("the build didn't run in buildkit with a session: %v", build.Query)
+	}
+	if strings.Contains(strings.Join(build.Query["buildargs"], ""), "s3cret") {
+		t.Errorf("the secret was sent as a build arg: %v", build.Query["buildargs"])
+	}
+}
+
+// the session has to be open before the build starts, a build without it would run with empty secrets
+func TestDockerBuildSecretsWithoutSession(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Fail("POST", "/session", 404, "page not found")
+	rt := &dockerRuntime{client: docker.New(engine.Socket, "")}
+
+	_, err := rt.Build(context.Background(), BuildImageOptions{ContextDir: t.TempDir(), Secrets: map[string]string{"npm_token": "s3cret"}}, nil)
+	if !docker.IsNotFound(err) {
+		t.Fatalf("expected the session error, got %v", err)
+	}
+	if engine.LastRequest("POST", "/build") != nil {
+		t.Error("the build was sent without a session")
+	}
+}
+
+func TestBuildLimitsWithBuildKit(t *testing.T) {
+	previous, previousRuntime := config.Current, Current
+	config.Current.BuildMemory = 512
+	Current = &dockerRuntime{}
+	t.Cleanup(func() { config.Current, Current = previous, previousRuntime })
+
+	if err := ValidateBuildLimits(nil, map[string]string{"npm_token": "s3cret"}, false); !errors.Is(err, ErrBuildLimitsUnsupported) {
+		t.Errorf("secrets: expected the build limits error, got %v", err)
+	}
+	if err := ValidateBuildLimits(nil, nil, false); err != nil {
+		t.Errorf("plain build: %v", err)
+	}
+}
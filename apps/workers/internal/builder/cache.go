@@ -0,0 +1,267 @@
+// build cache reuse. two things make a rebuild of a deployment warm:
+// 1. the previous successful image of the deployment is passed as --cache-from
+// 2. cache mounts for package managers. RUN instructions that call npm, go or pip get a
+//    --mount=type=cache for the manager's download cache, so dependencies survive a changed lockfile.
+//    the cache id contains the deployment id, deployments never see each other's caches.
+//    the mounts are owned by root and sit in root's home, so RUN instructions of a stage that switched to another
+//    USER don't get them. the user a base image was built with is not known before the build, it is taken to be root.
+// the build output is read along the way to count how many steps came from the cache.
+
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cacheMount is the download cache of one package manager
+type cacheMount struct {
+	commands *regexp.Regexp // RUN instructions this mount is added to
+	targets  []string       // cache folders of the root user
+}
+
+var cacheMounts = map[string]cacheMount{
+	"npm": {
+		commands: regexp.MustCompile(`\bnpm\s+(ci|install|i)\b`),
+		targets:  []string{"/root/.npm"},
+	},
+	"go": {
+		commands: regexp.MustCompile(`\bgo\s+(mod\s+download|build|install|test|generate)\b`),
+		targets:  []string{"/go/pkg/mod", "/root/.cache/go-build"},
+	},
+	"pip": {
+		commands: regexp.MustCompile(`\bpip3?\s+install\b`),
+		targets:  []string{"/root/.cache/pip"},
+	},
+}
+
+// ValidateCacheMounts checks that every requested cache mount is one the builder knows
+func ValidateCacheMounts(names []string) error {
+	for _, name := range names {
+		if _, ok := cacheMounts[name]; !ok {
+			return fmt.Errorf("unknown cache mount %q, expected one of %s", name, strings.Join(CacheMountNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// CacheMountNames lists the supported package managers
+func CacheMountNames() []string {
+	var names []string
+	for name := range cacheMounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addCacheMounts writes a copy of the Dockerfile with cache mounts added to the matching RUN instructions and
+// returns its path. the copy sits next to the original in the workspace. when nothing matched the original is used.
+func addCacheMounts(dockerfile string, names []string, cacheID string) (string, int, error) {
+	data, err := os.ReadFile(dockerfile)
+	if err != nil {
+		return "", 0, err
+	}
+
+	rewritten, added := rewriteRuns(string(data), names, cacheID)
+	if added == 0 {
+		return dockerfile, 0, nil
+	}
+
+	target := filepath.Join(filepath.Dir(dockerfile), ".blacktree.cache.Dockerfile")
+	if err := os.WriteFile(target, []byte(rewritten), 0644); err != nil {
+		return "", 0, err
+	}
+	return target, added, nil
+}
+
+var (
+	runInstruction  = regexp.MustCompile(`(?i)^(\s*RUN)(\s)`)
+	fromInstruction = regexp.MustCompile(`(?i)^\s*FROM\s+(?:--\S+\s+)*(\S+)(?:\s+AS\s+(\S+))?`)
+	userInstruction = regexp.MustCompile(`(?i)^\s*USER\s+(\S+)`)
+)
+
+// rootUser tells if a USER argument is root, an empty user is the one of the base image
+func rootUser(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+// rewriteRuns adds the mounts to every RUN instruction (continuation lines included) that calls a package manager
+// and runs as root. a stage built on an earlier stage starts with the user that stage ended with.
+func rewriteRuns(dockerfile string, names []string, cacheID string) (string, int) {
+	lines := strings.Split(dockerfile, "\n")
+	added := 0
+
+	var user string                // USER of the current stage
+	var stages []string            // user each earlier stage ended with
+	stageNames := map[string]int{} // AS name of a stage -> its index
+	inStage := false
+
+	for i := 0; i < len(lines); i++ {
+		if m := fromInstruction.FindStringSubmatch(lines[i]); m != nil {
+			if inStage {
+				stages = append(stages, user)
+			}
+			inStage = true
+			user = ""
+			if index, ok := stageNames[strings.ToLower(m[1])]; ok {
+				user = stages[index]
+			} else if index, err := strconv.Atoi(m[1]); err == nil && index >= 0 && index < len(stages) {
+				user = stages[index]
+			}
+			if m[2] != "" {
+				stageNames[strings.ToLower(m[2])] = len(stages)
+			}
+			continue
+		}
+		if m := userInstruction.FindStringSubmatch(lines[i]); m != nil {
+			user = m[1]
+			continue
+		}
+		if !runInstruction.MatchString(lines[i]) {
+			continue
+		}
+
+		// the instruction ends at the first line without a trailing backslash
+		end := i
+		for end < len(lines)-1 && strings.HasSuffix(strings.TrimRight(lines[end], " \t\r"), "\\") {
+			end++
+		}
+		instruction := strings.Join(lines[i:end+1], "\n")
+
+		if !rootUser(user) {
+			i = end // the mounts would not be writable for the user
+			continue
+		}
+
+		var mounts []string
+		for _, name := range names {
+			mount := cacheMounts[name]
+			if !mount.commands.MatchString(instruction) {
+				continue
+			}
+			for j, target := range mount.targets {
+				id := fmt.Sprintf("blacktree-%s-%s-%d", cacheID, name, j)
+				if strings.Contains(instruction, "target="+target) {
+					continue // the Dockerfile already mounts something there
+				}
+				mounts = append(mounts, fmt.Sprintf("--mount=type=cache,id=%s,target=%s", id, target))
+			}
+		}
+
+		if len(mounts) > 0 {
+			lines[i] = runInstruction.ReplaceAllString(lines[i], "${1} "+strings.Join(mounts, " ")+"${2}")
+			added += len(mounts)
+		}
+		i = end
+	}
+
+	return strings.Join(lines, "\n"), added
+}
+
+// CacheStats is how many build steps could be taken from the cache
+type CacheStats struct {
+	Steps  int // RUN, COPY, ... steps, FROM and buildkit internals are not counted
+	Cached int
+}
+
+// HitRatio is Cached / Steps, 0 for a build without steps
+func (s CacheStats) HitRatio() float64 {
+	if s.Steps == 0 {
+		return 0
+	}
+	return float64(s.Cached) / float64(s.Steps)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d/%d steps cached (%.0f%%)", s.Cached, s.Steps, s.HitRatio()*100)
+}
+
+var (
+	legacyStep     = regexp.MustCompile(`^Step \d+/\d+ : (\S+)`)           // Step 2/5 : RUN npm ci
+	legacyCached   = regexp.MustCompile(`^\s*---> Using cache`)            //  ---> Using cache
+	buildkitStep   = regexp.MustCompile(`^#(\d+) \[[^\]]*\d+/\d+\] (\S+)`) // #6 [2/4] RUN npm ci, #6 [build 2/4] ...
+	buildkitCached = regexp.MustCompile(`^#(\d+) CACHED`)
+)
+
+// cacheCounter reads the build output that passes through it and counts the cached steps of both builders
+type cacheCounter struct {
+	mu     sync.Mutex
+	line   []byte
+	stats  CacheStats
+	steps  map[string]bool // buildkit vertex ids of counted steps
+	cached map[string]bool
+}
+
+func newCacheCounter() *cacheCounter {
+	return &cacheCounter{steps: make(map[string]bool), cached: make(map[string]bool)}
+}
+
+func (c *cacheCounter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.line = append(c.line, p...)
+	for {
+		i := bytes.IndexByte(c.line, '\n')
+		if i < 0 {
+			break
+		}
+		c.count(string(c.line[:i]))
+		c.line = c.line[i+1:]
+	}
+	return len(p), nil
+}
+
+func (c *cacheCounter) count(line string) {
+	line = strings.TrimRight(line, "\r")
+
+	if m := legacyStep.FindStringSubmatch(line); m != nil {
+		if !strings.EqualFold(m[1], "FROM") {
+			c.stats.Steps++
+		}
+		return
+	}
+	if legacyCached.MatchString(line) {
+		c.stats.Cached++
+		return
+	}
+
+	if m := buildkitStep.FindStringSubmatch(line); m != nil {
+		if !strings.EqualFold(m[2], "FROM") && !c.steps[m[1]] {
+			c.steps[m[1]] = true
+			c.stats.Steps++
+		}
+		return
+	}
+	if m := buildkitCached.FindStringSubmatch(line); m != nil {
+		if c.steps[m[1]] && !c.cached[m[1]] {
+			c.cached[m[1]] = true
+			c.stats.Cached++
+		}
+	}
+}
+
+func (c *cacheCounter) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the last line has no newline when the stream ended
+	if len(c.line) > 0 {
+		scanner := bufio.NewScanner(bytes.NewReader(c.line))
+		for scanner.Scan() {
+			c.count(scanner.Text())
+		}
+		c.line = nil
+	}
+	return c.stats
+}
@@ -0,0 +1,36 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteRunsUser(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		dockerfile string
+		added      int
+	}{
+		{"root by default", "FROM node:20\nRUN npm ci\n", 1},
+		{"explicit root", "FROM node:20\nUSER node\nUSER root:root\nRUN npm ci\n", 1},
+		{"uid 0", "FROM node:20\nUSER 0\nRUN npm ci\n", 1},
+		{"other user", "FROM node:20\nUSER node\nRUN npm ci\n", 0},
+		{"numeric user", "FROM golang:1.21\nUSER 1000:1000\nRUN go build ./...\n", 0},
+		{"new stage starts as root", "FROM node:20 AS deps\nUSER node\nRUN npm ci\nFROM node:20\nRUN npm ci\n", 1},
+		{"stage inherits user", "FROM node:20 AS base\nUSER node\nFROM base\nRUN npm ci\n", 0},
+		{"stage by index", "FROM node:20\nUSER node\nFROM 0\nRUN npm ci\n", 0},
+		{"continuation skipped", "FROM node:20\nUSER node\nRUN apt-get update && \\\n  npm ci\nUSER root\nRUN npm ci\n", 1},
+	} {
+		rewritten, added := rewriteRuns(tc.dockerfile, []string{"npm", "go"}, "1234abcd")
+		if added != tc.added {
+			t.Errorf("%s: %d mounts added, want %d:\n%s", tc.name, added, tc.added, rewritten)
+		}
+	}
+}
+
+func TestRewriteRunsMounts(t *testing.T) {
+	rewritten, added := rewriteRuns("FROM golang:1.21\nRUN --mount=type=cache,target=/go/pkg/mod go build ./...\n", []string{"go", "npm"}, "1234abcd")
+	if added != 1 || !strings.Contains(rewritten, "RUN --mount=type=cache,id=blacktree-1234abcd-go-1,target=/root/.cache/go-build --mount=type=cache,target=/go/pkg/mod go build") {
+		t.Errorf("%d mounts added:\n%s", added, rewritten)
+	}
+}
@@ -0,0 +1,381 @@
+// compose stacks: a deployment with a compose file is built and run as one compose project named after the
+// deployment, so the services of two deployments never share containers, networks or volumes.
+// the engine api knows nothing about compose, so this goes through the compose cli (docker compose or podman compose,
+// WORKER_COMPOSE_COMMAND) pointed at the worker's engine socket. the build resolves the compose file first
+// (`config`: paths made absolute, env files and variables filled in) and keeps the result in data/stacks/<deploymentId>.
+// the workspace is gone after the build, the stack is started from that file and the images the build left.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"worker/internal/config"
+	"worker/internal/policy"
+	portman "worker/internal/portMan"
+	"worker/internal/secrets"
+)
+
+// StackDir keeps the resolved compose file of every stack deployment
+const StackDir = "data/stacks"
+
+// label compose puts on every container of a project
+const composeProjectLabel = "com.docker.compose.project"
+
+// ComposeServiceLabel names the service a container of a stack belongs to
+const ComposeServiceLabel = "com.docker.compose.service"
+
+var serviceName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// StackProject is the compose project name of a deployment, project names have to be lower case
+func StackProject(deploymentID string) string {
+	return strings.ToLower(ContainerName(deploymentID))
+}
+
+func stackFile(deploymentID string) string {
+	return filepath.Join(StackDir, deploymentID, "compose.json")
+}
+
+// the stack file with the ports the worker assigned, what `up` actually runs
+func stackRunFile(deploymentID string) string {
+	return filepath.Join(StackDir, deploymentID, "compose.run.json")
+}
+
+// ValidateStackOptions rejects build options that only make sense for a single Dockerfile. a compose file sets
+// targets, secrets and caches per service itself.
+func ValidateStackOptions(target string, secrets map[string]string, cacheMounts []string, publicService string) error {
+	if target != "" || len(secrets) > 0 || len(cacheMounts) > 0 {
+		return errors.New("buildTarget, buildSecrets and cacheMounts are not supported for compose deployments, set them in the compose file")
+	}
+	if publicService != "" && !serviceName.MatchString(publicService) {
+		return fmt.Errorf("invalid public service name %q", publicService)
+	}
+	return nil
+}
+
+// buildStack resolves the compose file, keeps it for starting the stack later and builds every service with a
+// build section. the images are named <project>-<service> by compose, so they belong to this deployment only.
+func buildStack(ctx context.Context, opt BuildImageOptions, out io.Writer) (string, []secrets.Finding, error) {
+	// compose has no per build cpu or memory limits, only the engine wide ones would apply
+	if opt.Memory > 0 || opt.CPUs > 0 {
+		return "", nil, fmt.Errorf("%w: compose builds have no build limits", ErrBuildLimitsUnsupported)
+	}
+
+	project := StackProject(opt.DeploymentID)
+
+	resolved, err := composeOutput(ctx, "-p", project, "-f", opt.ComposeFile, "config", "--format", "json")
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	services, err := parseStack(resolved)
+	if err != nil {
+		return "", nil, err
+	}
+	var stack map[string]any
+	if err := json.Unmarshal(resolved, &stack); err != nil {
+		return "", nil, fmt.Errorf("invalid compose config output: %w", err)
+	}
+	names := sortedKeys(services)
+	if opt.PublicService != "" && !slices.Contains(names, opt.PublicService) {
+		return "", nil, fmt.Errorf("public service %q is not in the compose file, it has %s", opt.PublicService, strings.Join(names, ", "))
+	}
+	fmt.Fprintf(out, "🧩 Project %s, services: %s\n", project, strings.Join(names, ", "))
+
+	workspace := opt.Workspace
+	if workspace == "" {
+		workspace = filepath.Dir(opt.ComposeFile)
+	}
+	if err := checkStackPolicy(stack, workspace, filepath.Dir(opt.ComposeFile), services, opt.BuildArgs, out); err != nil {
+		return "", nil, err
+	}
+	findings, err := scanSecrets(stackScanTargets(opt.ComposeFile, services), out)
+	if err != nil {
+		return "", findings, err
+	}
+
+	// env files end up in the resolved file, so only the worker may read it
+	if err := os.MkdirAll(filepath.Dir(stackFile(opt.DeploymentID)), 0700); err != nil {
+		return "", findings, err
+	}
+	if err := os.WriteFile(stackFile(opt.DeploymentID), resolved, 0600); err != nil {
+		return "", findings, fmt.Errorf("failed to store compose file: %w", err)
+	}
+
+	args := []string{"-p", project, "-f", stackFile(opt.DeploymentID), "build"}
+	for _, name := range sortedKeys(opt.BuildArgs) {
+		args = append(args, "--build-arg", name+"="+opt.BuildArgs[name])
+	}
+
+	cmd := composeCommand(ctx, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out // build progress goes to stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", findings, ctx.Err()
+		}
+		return "", findings, fmt.Errorf("compose build: %w", err)
+	}
+	return "", findings, nil // a stack has no single image
+}
+
+// StartStack starts every service of the stack. only publicService gets a host port, mapped to containerPort.
+// ports published by the compose file itself are dropped, they would clash between deployments. every service runs
+// with the deployment's limits, whatever limits the compose file sets are replaced.
+func StartStack(deploymentID string, publicService string, resources Resources, containerPort *int) error {
+	ctx := context.Background()
+
+	running, err := Current.ContainersWithLabel(ctx, composeProjectLabel+"="+StackProject(deploymentID), false)
+	if err != nil {
+		return fmt.Errorf("failed to check running containers: %w", err)
+	}
+	if len(running) > 0 {
+		log.Printf("⚠️ Stack %s is already running", StackProject(deploymentID))
+		return nil
+	}
+
+	data, err := os.ReadFile(stackFile(deploymentID))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stack of %s was never built", deploymentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	var stack map[string]any
+	if err := json.Unmarshal(data, &stack); err != nil {
+		return fmt.Errorf("invalid stored compose file: %w", err)
+	}
+	// stored by a worker that didn't check this yet, or edited since
+	if violations := stackIsolation(stack); len(violations) > 0 {
+		return &policy.Error{Violations: violations}
+	}
+
+	limits, err := resources.resolve()
+	if err != nil {
+		return err
+	}
+
+	services, _ := stack["services"].(map[string]any)
+	for _, s := range services {
+		if service, ok := s.(map[string]any); ok {
+			delete(service, "ports")
+			delete(service, "container_name") // fixed names would clash as well, compose names them per project
+			applyStackLimits(service, limits)
+		}
+	}
+
+	if containerPort != nil {
+		if publicService == "" && len(services) == 1 {
+			for name := range services {
+				publicService = name
+			}
+		}
+
+		service, ok := services[publicService].(map[string]any)
+		if !ok {
+			return fmt.Errorf("no public service %q in the stack, set publicService to the service that gets port %d", publicService, *containerPort)
+		}
+
+		hostPort, err := portman.GetFreePort()
+		if err != nil {
+			log.Printf("❌ Failed to get free host port: %v", err)
+			return err
+		}
+
+		log.Printf("🔌 Mapping host port %d to port %d of service %s", hostPort, *containerPort, publicService)
+		service["ports"] = []any{map[string]any{
+			"target":    *containerPort,
+			"published": strconv.Itoa(hostPort),
+			"protocol":  "tcp",
+		}}
+	}
+
+	runFile, err := json.Marshal(stack)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(stackRunFile(deploymentID), runFile, 0600); err != nil {
+		return err
+	}
+
+	if err := runCompose(ctx, "-p", StackProject(deploymentID), "-f", stackRunFile(deploymentID), "up", "--detach", "--no-build", "--remove-orphans"); err != nil {
+		return fmt.Errorf("failed to start stack: %w", err)
+	}
+
+	log.Printf("✅ Successfully started stack %s", StackProject(deploymentID))
+	return nil
+}
+
+// the limit keys of a compose service, removed before the deployment's limits are set
+var stackLimitKeys = []string{
+	"cpus", "cpu_count", "cpu_percent", "cpu_shares", "cpu_quota", "cpu_period", "cpu_rt_runtime", "cpu_rt_period",
+	"mem_limit", "memswap_limit", "mem_swappiness", "oom_kill_disable", "pids_limit", "ulimits",
+}
+
+// applyStackLimits gives a service the limits a single container of the deployment would run with
+func applyStackLimits(service map[string]any, l Limits) {
+	for _, key := range stackLimitKeys {
+		delete(service, key)
+	}
+	if deploy, ok := service["deploy"].(map[string]any); ok {
+		delete(deploy, "resources") // would override the keys set below
+	}
+
+	if l.NanoCPUs > 0 {
+		service["cpus"] = float64(l.NanoCPUs) / 1e9
+	}
+	if l.CPUShares > 0 {
+		service["cpu_shares"] = l.CPUShares
+	}
+	if l.Memory > 0 {
+		service["mem_limit"] = l.Memory
+		service["memswap_limit"] = l.MemorySwap
+	}
+	if l.PidsLimit > 0 {
+		service["pids_limit"] = l.PidsLimit
+	}
+	if len(l.Ulimits) > 0 {
+		ulimits := make(map[string]any, len(l.Ulimits))
+		for _, u := range l.Ulimits {
+			ulimits[u.Name] = map[string]any{"soft": u.Soft, "hard": u.Hard}
+		}
+		service["ulimits"] = ulimits
+	}
+}
+
+// StopStack stops and removes the containers and the network of the stack, volumes and images stay
+func StopStack(deploymentID string) error {
+	args := append(stackArgs(deploymentID), "down", "--remove-orphans")
+	if err := runCompose(context.Background(), args...); err != nil {
+		return fmt.Errorf("failed to stop stack: %w", err)
+	}
+
+	log.Printf("✅ Stopped stack %s", StackProject(deploymentID))
+	return nil
+}
+
+// RemoveStack removes everything of the stack: containers, network, volumes, the images it built and its stored file
+func RemoveStack(deploymentID string) error {
+	args := append(stackArgs(deploymentID), "down", "--remove-orphans", "--volumes", "--rmi", "local")
+	if err := runCompose(context.Background(), args...); err != nil {
+		return fmt.Errorf("failed to remove stack: %w", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(StackDir, deploymentID)); err != nil {
+		return err
+	}
+
+	log.Printf("🗑️ Removed stack %s", StackProject(deploymentID))
+	return nil
+}
+
+// StackContainers lists the containers of the stack, stopped ones included
+func StackContainers(deploymentID string) ([]ContainerInfo, error) {
+	return Current.ContainersWithLabel(context.Background(), composeProjectLabel+"="+StackProject(deploymentID), true)
+}
+
+// stackArgs selects the project, with the stored file when there is one (down works without it as well)
+func stackArgs(deploymentID string) []string {
+	args := []string{"-p", StackProject(deploymentID)}
+	for _, file := range []string{stackRunFile(deploymentID), stackFile(deploymentID)} {
+		if _, err := os.Stat(file); err == nil {
+			return append(args, "-f", file)
+		}
+	}
+	return args
+}
+
+// composeService is the part of a resolved compose service the checks before the build need
+type composeService struct {
+	Image string        `json:"image"`
+	Build *composeBuild `json:"build"` // nil for services that only run an image
+}
+
+type composeBuild struct {
+	Context          string            `json:"context"` // absolute after compose config
+	Dockerfile       string            `json:"dockerfile"`
+	DockerfileInline string            `json:"dockerfile_inline"`
+	Target           string            `json:"target"`
+	Args             map[string]string `json:"args"`
+}
+
+// parseStack reads the services of a resolved compose file
+func parseStack(resolved []byte) (map[string]composeService, error) {
+	var stack struct {
+		Services map[string]composeService `json:"services"`
+	}
+	if err := json.Unmarshal(resolved, &stack); err != nil {
+		return nil, fmt.Errorf("invalid compose config output: %w", err)
+	}
+	if len(stack.Services) == 0 {
+		return nil, errors.New("compose file has no services")
+	}
+	return stack.Services, nil
+}
+
+// composeCommand runs the compose cli against the engine the worker uses. compose fills ${VARIABLES} of the
+// compose file in from its environment, so it only gets what it needs to run, never the worker's own settings.
+func composeCommand(ctx context.Context, args ...string) *exec.Cmd {
+	command := config.Current.ComposeCommand
+	if len(command) == 0 {
+		command = []string{Current.Name(), "compose"}
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:], args...)...)
+	cmd.Env = []string{"DOCKER_HOST=unix://" + engineSocket()}
+	for _, name := range []string{"PATH", "HOME", "DOCKER_CONFIG", "XDG_RUNTIME_DIR"} {
+		if value, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	return cmd
+}
+
+// runCompose runs a compose command with its output in the worker log
+func runCompose(ctx context.Context, args ...string) error {
+	cmd := composeCommand(ctx, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stdout
+	return cmd.Run()
+}
+
+// composeOutput runs a compose command and returns its stdout, stderr goes into the error
+func composeOutput(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := composeCommand(ctx, args...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// engineSocket is the unix socket of the engine behind Current
+func engineSocket() string {
+	switch rt := Current.(type) {
+	case *dockerRuntime:
+		return rt.client.Socket
+	case *podmanRuntime:
+		return rt.client.Socket
+	}
+	return config.Current.DockerSocket
+}
@@ -0,0 +1,302 @@
+// the conformance suite runs the same lifecycle against every Runtime and fails on the first place where the engine
+// doesn't behave the way the worker expects. by default it runs against fake docker and podman engines, after adding
+// or changing a Runtime it should pass against both. to check the real engine of a host before sending it deployments:
+//
+//	WORKER_CONFORMANCE=1 go test ./internal/builder -run TestConformance/engine
+//
+// with WORKER_RUNTIME and the socket settings of the worker, WORKER_CONFORMANCE_IMAGE replaces the base image.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"worker/internal/config"
+	"worker/internal/docker"
+	"worker/internal/docker/dockertest"
+)
+
+// conformanceImage is a small image with a shell, used as the base of the test image
+const conformanceImage = "docker.io/library/busybox:latest"
+
+const conformanceOutput = "blacktree-conformance"
+
+// withRegistry sets WORKER_REGISTRY and its credentials for the test
+func withRegistry(t *testing.T, registry, username, password string) {
+	t.Helper()
+	previous := config.Current
+	config.Current.Registry = registry
+	config.Current.RegistryUsername = username
+	config.Current.RegistryPassword = password
+	t.Cleanup(func() { config.Current = previous })
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("docker", func(t *testing.T) {
+		engine := dockertest.NewEngine(t)
+		withRegistry(t, "registry.test:5000", "", "")
+		conformance(t, &dockerRuntime{client: docker.New(engine.Socket, "")}, conformanceImage)
+	})
+
+	t.Run("podman", func(t *testing.T) {
+		engine := dockertest.NewPodmanEngine(t)
+		withRegistry(t, "registry.test:5000", "", "")
+		conformance(t, &podmanRuntime{dockerRuntime{client: docker.New(engine.Socket, "")}}, conformanceImage)
+	})
+
+	t.Run("engine", func(t *testing.T) {
+		if os.Getenv("WORKER_CONFORMANCE") != "1" {
+			t.Skip("set WORKER_CONFORMANCE=1 to check the engine of this host")
+		}
+		rt, err := NewRuntime(config.Current.Runtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		image := conformanceImage
+		if custom := os.Getenv("WORKER_CONFORMANCE_IMAGE"); custom != "" {
+			image = custom
+		}
+		conformance(t, rt, image)
+	})
+}
+
+// conformance pulls baseImage, builds a tiny image on top of it and runs, inspects, reads the logs of, stops and removes
+// a container from it. with WORKER_REGISTRY set the image is also pushed and pulled back by digest.
+// everything it creates is removed again (except the pushed image in the registry).
+func conformance(t *testing.T, rt Runtime, baseImage string) {
+	ctx := context.Background()
+	name := fmt.Sprintf("blacktree-conformance-%d", time.Now().UnixNano())
+	image := "blacktree/" + name
+
+	step := func(format string, args ...any) {
+		t.Logf("🧪 [%s] %s", rt.Name(), fmt.Sprintf(format, args...))
+	}
+
+	// 1. pull, the base image came from a registry so it must have a digest
+	step("pull %s", baseImage)
+	if err := rt.PullImage(ctx, baseImage, io.Discard); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	digests, err := rt.ImageDigests(ctx, baseImage)
+	if err != nil {
+		t.Fatalf("image digests: %v", err)
+	}
+	if len(digests) == 0 {
+		t.Fatalf("image digests: pulled image %s has no repo digest", baseImage)
+	}
+
+	// 2. build
+	dir := t.TempDir()
+
+	// the build fails when .dockerignore was not applied to the context
+	dockerfile := fmt.Sprintf("FROM %s\nCOPY . /ctx\nRUN test ! -e /ctx/ignored\nCMD [\"sh\", \"-c\", \"echo %s && sleep 300\"]\n", baseImage, conformanceOutput)
+	for file, content := range map[string]string{
+		"Dockerfile":    dockerfile,
+		".dockerignore": "ignored\n",
+		"ignored":       "must not be sent",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	step("build %s", image)
+	if _, err := rt.Build(ctx, BuildImageOptions{
+		ImageName:      image,
+		ContextDir:     dir,
+		DockerfilePath: filepath.Join(dir, "Dockerfile"),
+	}, io.Discard); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := rt.RemoveImage(context.Background(), image); err != nil {
+			t.Errorf("remove image: %v", err)
+		}
+		if exists, _ := rt.ImageExists(context.Background(), image); exists {
+			t.Errorf("remove image: %s still exists", image)
+		}
+	})
+
+	if exists, err := rt.ImageExists(ctx, image); err != nil || !exists {
+		t.Fatalf("image exists: built image %s not found (err %v)", image, err)
+	}
+	// the worker tells pulled and pushed images from local ones by their repo digests
+	if digests, err := rt.ImageDigests(ctx, image); err != nil || len(digests) != 0 {
+		t.Fatalf("image digests: a local build should have none, got %v (err %v)", digests, err)
+	}
+
+	// 3. run and inspect
+	step("run %s", name)
+	id, err := rt.Run(ctx, RunOptions{
+		Name:   name,
+		Image:  image,
+		Ports:  map[int]int{8080: 0}, // 0 lets the engine pick a free host port
+		Env:    []string{"BLACKTREE_CONFORMANCE=1"},
+		Labels: map[string]string{"blacktree.conformance": "true"},
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	t.Cleanup(func() { rt.Remove(context.Background(), id) }) // in case a later step fails
+
+	info, err := rt.Inspect(ctx, id)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if !info.Running || info.Name != name || info.Labels["blacktree.conformance"] != "true" {
+		t.Fatalf("inspect: got running=%t name=%q labels=%v", info.Running, info.Name, info.Labels)
+	}
+	if info.Ports[8080] == 0 {
+		t.Fatalf("inspect: container port 8080 was not published, got %v", info.Ports)
+	}
+
+	labelled, err := rt.ContainersWithLabel(ctx, "blacktree.conformance=true", false)
+	if err != nil {
+		t.Fatalf("containers with label: %v", err)
+	}
+	if len(labelled) != 1 || labelled[0].ID != id {
+		t.Fatalf("containers with label: expected only %s, got %v", id, labelled)
+	}
+
+	// a second container with the same name is a conflict, the worker relies on that to never run two
+	if _, err := rt.Run(ctx, RunOptions{Name: name, Image: image}); err == nil {
+		t.Fatalf("run: a second container named %s was started", name)
+	}
+
+	// 4. logs
+	step("logs")
+	if err := waitForLogs(ctx, rt, id); err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+
+	// 5. stop, twice. the second one must not be an error
+	step("stop")
+	for i := 0; i < 2; i++ {
+		if err := rt.Stop(ctx, id, time.Second); err != nil {
+			t.Fatalf("stop #%d: %v", i+1, err)
+		}
+	}
+	if info, err := rt.Inspect(ctx, id); err != nil || info.Running {
+		t.Fatalf("stop: container still running (err %v)", err)
+	}
+	if running, err := rt.ContainersWithLabel(ctx, "blacktree.conformance=true", false); err != nil || len(running) != 0 {
+		t.Fatalf("containers with label: a stopped container is listed as running (%v, err %v)", running, err)
+	}
+
+	// 6. remove, twice as well
+	step("remove")
+	for i := 0; i < 2; i++ {
+		if err := rt.Remove(ctx, id); err != nil {
+			t.Fatalf("remove #%d: %v", i+1, err)
+		}
+	}
+	if _, err := rt.Inspect(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("remove: inspect after remove should be ErrNotFound, got %v", err)
+	}
+
+	// 7. push to WORKER_REGISTRY and pull it back by digest, only when a registry is configured
+	if RegistryEnabled() {
+		ref := RegistryRef(image + ":latest")
+		step("push %s", ref)
+		if err := rt.TagImage(ctx, image, ref); err != nil {
+			t.Fatalf("tag for push: %v", err)
+		}
+		digest, err := rt.PushImage(ctx, ref, io.Discard)
+		rt.RemoveImage(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("push: %v", err)
+		}
+		if !digestPattern.MatchString(digest) {
+			t.Fatalf("push: expected the digest of %s, got %q", ref, digest)
+		}
+
+		pinned := repository(ref) + "@" + digest
+		step("pull %s", pinned)
+		if err := rt.PullImage(ctx, pinned, io.Discard); err != nil {
+			t.Fatalf("pull by digest: %v", err)
+		}
+		if err := rt.RemoveImage(ctx, pinned); err != nil {
+			t.Fatalf("remove pulled image: %v", err)
+		}
+	}
+
+	step("ok")
+}
+
+func waitForLogs(ctx context.Context, rt Runtime, id string) error {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		logs, err := rt.Logs(ctx, id, LogsOptions{Tail: 10})
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(logs)
+		logs.Close()
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(string(data), conformanceOutput) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("expected %q in the logs, got %q", conformanceOutput, data)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// rootless podman can't publish ports below 1024, the podman runtime refuses them before asking the engine
+func TestPodmanPrivilegedPort(t *testing.T) {
+	engine := dockertest.NewPodmanEngine(t)
+	rt := &podmanRuntime{dockerRuntime{client: docker.New(engine.Socket, "")}}
+
+	_, err := rt.Run(context.Background(), RunOptions{Name: "web", Image: conformanceImage, Ports: map[int]int{80: 80}})
+	if err == nil || !strings.Contains(err.Error(), "host port 80") {
+		t.Fatalf("expected port 80 to be refused, got %v", err)
+	}
+	if requests := engine.Requests(); len(requests) != 0 {
+		t.Errorf("the engine was asked anyway: %+v", requests)
+	}
+}
+
+// a container that can't be started is removed again, its name would block the next start
+func TestRunRemovesContainerThatFailedToStart(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Fail("POST", "/containers/blacktree-1234abcd/start", 500, "driver failed programming external connectivity")
+	rt := &dockerRuntime{client: docker.New(engine.Socket, "")}
+
+	if err := rt.PullImage(context.Background(), conformanceImage, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	_, err := rt.Run(context.Background(), RunOptions{Name: "blacktree-1234abcd", Image: conformanceImage})
+	if !errors.Is(err, docker.ErrDaemon) {
+		t.Fatalf("expected the start error, got %v", err)
+	}
+	if containers := engine.Containers(); len(containers) != 0 {
+		t.Errorf("containers left behind: %v", containers)
+	}
+}
+
+// an unknown WORKER_RUNTIME is an error at startup, Current is left alone
+func TestInitUnknownRuntime(t *testing.T) {
+	previous := config.Current
+	config.Current.Runtime = "dokcer"
+	t.Cleanup(func() { config.Current = previous })
+
+	before := Current
+	if err := Init(); err == nil || !strings.Contains(err.Error(), `"dokcer"`) {
+		t.Fatalf("expected an unknown runtime error, got %v", err)
+	}
+	if Current != before {
+		t.Error("Current was replaced by a failed Init")
+	}
+}
@@ -0,0 +1,38 @@
+// the output of a deployment's container, the supervisor sends the end of it along when the container crashes
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tailBytes caps the log tail, a few long lines shouldn't make a huge status message
+const tailBytes = 16 * 1024
+
+// ContainerLogTail returns the last lines of stdout and stderr of a container, secret values replaced with ***
+func ContainerLogTail(id string, lines int, secrets map[string]string) (string, error) {
+	logs, err := Current.Logs(context.Background(), id, LogsOptions{Tail: lines})
+	if err != nil {
+		return "", fmt.Errorf("failed to read the logs of %s: %w", id, err)
+	}
+	defer logs.Close()
+
+	var out strings.Builder
+	redactor := NewRedactor(&out, secrets)
+	if _, err := io.Copy(redactor, logs); err != nil {
+		return "", fmt.Errorf("failed to read the logs of %s: %w", id, err)
+	}
+	redactor.Flush()
+
+	tail := out.String()
+	if len(tail) > tailBytes {
+		tail = tail[len(tail)-tailBytes:]
+		if i := strings.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+	return tail, nil
+}
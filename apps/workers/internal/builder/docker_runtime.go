@@ -0,0 +1,211 @@
+// Runtime for the docker daemon, a thin layer over the engine api client
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"worker/internal/docker"
+)
+
+type dockerRuntime struct {
+	client *docker.Client
+}
+
+func (r *dockerRuntime) Name() string { return "docker" }
+
+func (r *dockerRuntime) Build(ctx context.Context, opt BuildImageOptions, out io.Writer) (string, error) {
+	if len(opt.Secrets) > 0 || len(opt.CacheMounts) > 0 {
+		return r.buildWithBuildKit(ctx, opt, out)
+	}
+	return r.client.BuildImage(ctx, buildOptions(opt), out)
+}
+
+func buildOptions(opt BuildImageOptions) docker.BuildOptions {
+	return docker.BuildOptions{
+		Tags:       []string{opt.ImageName},
+		ContextDir: opt.ContextDir,
+		Dockerfile: opt.DockerfilePath,
+		BuildArgs:  opt.BuildArgs,
+		Target:     opt.Target,
+		CacheFrom:  opt.CacheFrom,
+		Memory:     opt.Memory,
+		CPUQuota:   int64(opt.CPUs * 100000), // per 100ms period
+	}
+}
+
+func (r *dockerRuntime) Run(ctx context.Context, opt RunOptions) (string, error) {
+	config := docker.ContainerConfig{
+		Image:  opt.Image,
+		Env:    opt.Env,
+		Labels: opt.Labels,
+		HostConfig: docker.HostConfig{
+			NanoCPUs:   opt.Limits.NanoCPUs,
+			CPUShares:  opt.Limits.CPUShares,
+			Memory:     opt.Limits.Memory,
+			MemorySwap: opt.Limits.MemorySwap,
+			Ulimits:    opt.Limits.Ulimits,
+		},
+	}
+	if opt.Limits.PidsLimit > 0 {
+		config.HostConfig.PidsLimit = &opt.Limits.PidsLimit
+	}
+
+	if len(opt.Ports) > 0 {
+		config.ExposedPorts = make(map[string]struct{})
+		config.HostConfig.PortBindings = make(map[string][]docker.PortBinding)
+		for containerPort, hostPort := range opt.Ports {
+			port := strconv.Itoa(containerPort) + "/tcp"
+			config.ExposedPorts[port] = struct{}{}
+			config.HostConfig.PortBindings[port] = []docker.PortBinding{{HostPort: strconv.Itoa(hostPort)}}
+		}
+	}
+
+	id, err := r.client.CreateContainer(ctx, opt.Name, config)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.client.StartContainer(ctx, id); err != nil {
+		r.client.RemoveContainer(ctx, id, true, true) // don't leave the created container behind, its name would block the next start
+		return "", err
+	}
+	return id, nil
+}
+
+func (r *dockerRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	return r.client.StopContainer(ctx, id, timeout)
+}
+
+func (r *dockerRuntime) Remove(ctx context.Context, id string) error {
+	err := r.client.RemoveContainer(ctx, id, true, true)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (r *dockerRuntime) Inspect(ctx context.Context, id string) (*ContainerInfo, error) {
+	info, err := r.client.InspectContainer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[int]int)
+	for port, bindings := range info.NetworkSettings.Ports {
+		containerPort, err := strconv.Atoi(strings.TrimSuffix(port, "/tcp"))
+		if err != nil || len(bindings) == 0 {
+			continue // udp or not published
+		}
+		if hostPort, err := strconv.Atoi(bindings[0].HostPort); err == nil {
+			ports[containerPort] = hostPort
+		}
+	}
+
+	return &ContainerInfo{
+		ID:        info.ID,
+		Name:      strings.TrimPrefix(info.Name, "/"),
+		Image:     info.Config.Image,
+		State:     info.State.Status,
+		Running:   info.State.Running,
+		ExitCode:  info.State.ExitCode,
+		OOMKilled: info.State.OOMKilled,
+		Memory:    info.HostConfig.Memory,
+		Ports:     ports,
+		Labels:    info.Config.Labels,
+	}, nil
+}
+
+func (r *dockerRuntime) Logs(ctx context.Context, id string, opt LogsOptions) (io.ReadCloser, error) {
+	return r.client.ContainerLogs(ctx, id, opt)
+}
+
+func (r *dockerRuntime) ContainersWithLabel(ctx context.Context, label string, all bool) ([]ContainerInfo, error) {
+	return r.list(ctx, all, map[string][]string{"label": {label}})
+}
+
+func (r *dockerRuntime) Exec(ctx context.Context, id string, cmd []string, out io.Writer) (int, error) {
+	return r.client.Exec(ctx, id, cmd, out)
+}
+
+func (r *dockerRuntime) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, <-chan error) {
+	raw, errs := r.client.Events(ctx, map[string][]string{"type": {"container"}, "label": {label}})
+
+	events := make(chan ContainerEvent)
+	go func() {
+		defer close(events)
+		for event := range raw {
+			// the attributes are the labels plus name, image and exitCode
+			select {
+			case events <- ContainerEvent{ID: event.Actor.ID, Action: event.Action, Labels: event.Actor.Attributes}:
+			case <-ctx.Done():
+				// raw is drained and closed by the client once ctx is done
+			}
+		}
+	}()
+	return events, errs
+}
+
+func (r *dockerRuntime) list(ctx context.Context, all bool, filter map[string][]string) ([]ContainerInfo, error) {
+	containers, err := r.client.ListContainers(ctx, all, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		infos = append(infos, ContainerInfo{
+			ID:      c.ID,
+			Name:    name,
+			Image:   c.Image,
+			State:   c.State,
+			Running: c.State == "running",
+			Labels:  c.Labels,
+		})
+	}
+	return infos, nil
+}
+
+func (r *dockerRuntime) PullImage(ctx context.Context, ref string, out io.Writer) error {
+	return r.client.PullImage(ctx, ref, registryAuth(ref), out)
+}
+
+func (r *dockerRuntime) PushImage(ctx context.Context, ref string, out io.Writer) (string, error) {
+	return r.client.PushImage(ctx, ref, registryAuth(ref), out)
+}
+
+func (r *dockerRuntime) TagImage(ctx context.Context, source, target string) error {
+	return r.client.TagImage(ctx, source, target)
+}
+
+func (r *dockerRuntime) ImageExists(ctx context.Context, ref string) (bool, error) {
+	_, err := r.client.InspectImage(ctx, ref)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *dockerRuntime) ImageDigests(ctx context.Context, ref string) ([]string, error) {
+	info, err := r.client.InspectImage(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return info.RepoDigests, nil
+}
+
+func (r *dockerRuntime) RemoveImage(ctx context.Context, ref string) error {
+	err := r.client.RemoveImage(ctx, ref, true)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
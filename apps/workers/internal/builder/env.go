@@ -0,0 +1,43 @@
+// environment variables of a running container come from the update-env message, the names are checked
+// before anything is stored
+
+package builder
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnv checks the names of the variables, secrets and the names to unset. a name can only be one of them.
+func ValidateEnv(env map[string]string, secrets map[string]string, unset []string) error {
+	seen := make(map[string]string)
+	check := func(name, kind string) error {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("invalid %s name %q", kind, name)
+		}
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("%q is given as %s and as %s", name, other, kind)
+		}
+		seen[name] = kind
+		return nil
+	}
+
+	for name := range env {
+		if err := check(name, "env"); err != nil {
+			return err
+		}
+	}
+	for name := range secrets {
+		if err := check(name, "secret"); err != nil {
+			return err
+		}
+	}
+	for _, name := range unset {
+		if err := check(name, "unsetEnv"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
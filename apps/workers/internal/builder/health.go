@@ -0,0 +1,179 @@
+// health checks of a deployment's container. http and tcp checks go to the host port the container port is
+// published on, command checks run inside the container. one probe is one attempt, counting passes and failures
+// against the thresholds is up to the caller.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// types of health checks
+const (
+	HealthHTTP    = "http"
+	HealthTCP     = "tcp"
+	HealthCommand = "command"
+)
+
+// defaults for what a health check leaves out
+const (
+	defaultHealthInterval    = 10 * time.Second
+	defaultHealthTimeout     = 3 * time.Second
+	defaultHealthStartPeriod = 30 * time.Second
+)
+
+// ErrNotRunning is what a probe of a container that has stopped fails with, the supervisor deals with those
+var ErrNotRunning = errors.New("container is not running")
+
+// HealthCheck is how the health of a deployment's container is checked
+type HealthCheck struct {
+	Type               string
+	Path               string   // http: path that has to answer with 2xx or 3xx
+	Command            []string // command: run in the container, exit code 0 is healthy
+	StartPeriod        time.Duration
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int // passes in a row to become healthy
+	UnhealthyThreshold int // failures in a row to become unhealthy
+}
+
+// WithDefaults fills in what the check leaves out
+func (h HealthCheck) WithDefaults() HealthCheck {
+	if h.Type == HealthHTTP && h.Path == "" {
+		h.Path = "/"
+	}
+	if h.Interval == 0 {
+		h.Interval = defaultHealthInterval
+	}
+	if h.Timeout == 0 {
+		h.Timeout = defaultHealthTimeout
+	}
+	if h.StartPeriod == 0 {
+		h.StartPeriod = defaultHealthStartPeriod
+	}
+	if h.HealthyThreshold == 0 {
+		h.HealthyThreshold = 1
+	}
+	if h.UnhealthyThreshold == 0 {
+		h.UnhealthyThreshold = 3
+	}
+	return h
+}
+
+// ValidateHealthCheck checks a health check, http and tcp checks need the deployment to publish a port
+func ValidateHealthCheck(h HealthCheck, hasPort bool) error {
+	switch h.Type {
+	case HealthHTTP:
+		if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+			return fmt.Errorf("health check path %q must start with /", h.Path)
+		}
+		fallthrough
+	case HealthTCP:
+		if !hasPort {
+			return fmt.Errorf("a %s health check needs the deployment's portNumber", h.Type)
+		}
+	case HealthCommand:
+		if len(h.Command) == 0 || h.Command[0] == "" {
+			return errors.New("a command health check needs a command")
+		}
+	default:
+		return fmt.Errorf("invalid health check type %q, expected %s, %s or %s", h.Type, HealthHTTP, HealthTCP, HealthCommand)
+	}
+
+	if h.StartPeriod < 0 || h.Interval < 0 || h.Timeout < 0 || h.HealthyThreshold < 0 || h.UnhealthyThreshold < 0 {
+		return errors.New("health check durations and thresholds can't be negative")
+	}
+	if h.Interval > 0 && h.Interval < time.Second {
+		return errors.New("health check interval must be at least 1s")
+	}
+	if h := h.WithDefaults(); h.Timeout > h.Interval {
+		return fmt.Errorf("health check timeout %s is longer than its interval %s", h.Timeout, h.Interval)
+	}
+	return nil
+}
+
+// ProbeHealth checks the container once, nil means healthy. containerPort is the port http and tcp checks go to.
+func ProbeHealth(ctx context.Context, containerID string, containerPort int, h HealthCheck) error {
+	c, err := Current.Inspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if !c.Running {
+		return fmt.Errorf("%w, it is %s", ErrNotRunning, c.State)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	switch h.Type {
+	case HealthHTTP, HealthTCP:
+		hostPort, ok := c.Ports[containerPort]
+		if !ok {
+			return fmt.Errorf("container port %d is not published", containerPort)
+		}
+		address := net.JoinHostPort("127.0.0.1", strconv.Itoa(hostPort))
+		if h.Type == HealthTCP {
+			return probeTCP(ctx, address)
+		}
+		return probeHTTP(ctx, "http://"+address+h.Path)
+	case HealthCommand:
+		return probeCommand(ctx, containerID, h.Command)
+	}
+	return fmt.Errorf("unknown health check type %q", h.Type)
+}
+
+func probeTCP(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// redirects are not followed, a 3xx is an answer of the app
+var healthClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func probeHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s answered %s", req.URL.Path, resp.Status)
+	}
+	return nil
+}
+
+func probeCommand(ctx context.Context, containerID string, cmd []string) error {
+	var out strings.Builder
+	code, err := Current.Exec(ctx, containerID, cmd, &out)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		output := strings.TrimSpace(out.String())
+		if len(output) > 200 {
+			output = output[len(output)-200:]
+		}
+		return fmt.Errorf("health command exited with code %d: %s", code, output)
+	}
+	return nil
+}
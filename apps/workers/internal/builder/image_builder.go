@@ -1,34 +1,128 @@
 package builder
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"strings"
+	"worker/internal/secrets"
 )
 
 // BuildImageOptions contains input for building the Docker image
 type BuildImageOptions struct {
-	ImageName      string // e.g., "blacktree-worker:latest"
-	ContextDir     string // e.g., "./tmp/repos/repo-name-timestamp"
-	DockerfilePath string // e.g., "./tmp/repos/repo-name-timestamp/Dockerfile"
+	ImageName      string            // e.g., "blacktree-worker:latest"
+	ContextDir     string            // e.g., "./tmp/repos/repo-name-timestamp"
+	DockerfilePath string            // e.g., "./tmp/repos/repo-name-timestamp/Dockerfile"
+	BuildArgs      map[string]string // --build-arg, these end up in the image history
+	Target         string            // --target stage of a multi-stage Dockerfile
+	Secrets        map[string]string // id -> value, only available to RUN --mount=type=secret,id=<id> and never written to a layer
+	Memory         int64             // memory limit of the build in bytes, 0 = unlimited
+	CPUs           float64           // cpus the build may use, 0 = unlimited
+	CacheFrom      []string          // images to reuse layers from, defaults to the previous image of ImageName
+	CacheMounts    []string          // package managers (npm, go, pip) whose download cache is kept between builds
+	CacheID        string            // keeps the cache mounts of one deployment apart from the others, the deployment id
+	ComposeFile    string            // compose file of a stack deployment, all of its services are built instead of one image
+	Workspace      string            // root of the clone, a stack may not build from or read env files outside of it
+	DeploymentID   string            // names the compose project of a stack
+	PublicService  string            // service of the stack that gets the deployment's port
 }
 
-// BuildImage builds the Docker image using a shell script
-func BuildImage(opt BuildImageOptions) error {
-	fmt.Printf("🔨 Starting Docker build...\n")
-	fmt.Printf("📦 Image: %s\n", opt.ImageName)
-	fmt.Printf("📁 Context: %s\n", opt.ContextDir)
-	fmt.Printf("📄 Dockerfile: %s\n", opt.DockerfilePath)
+// BuildResult describes a finished build
+type BuildResult struct {
+	ImageID string
+	Cache   CacheStats
+	Secrets []secrets.Finding // possible secrets in the build context, when the scan only warns
+}
+
+// ErrBuildTimeout is returned by BuildImage when the build ran past the deadline of its context
+var ErrBuildTimeout = errors.New("build timed out")
+
+// ErrBuildCancelled is returned by BuildImage when its context was cancelled, e.g. the deployment was deleted
+var ErrBuildCancelled = errors.New("build cancelled")
+
+// BuildImage builds the image with the configured runtime, the build output goes to log (stdout when nil).
+// when ctx ends the build is killed and ErrBuildTimeout or ErrBuildCancelled is returned.
+func BuildImage(ctx context.Context, opt BuildImageOptions, log io.Writer) (BuildResult, error) {
+	if log == nil {
+		log = os.Stdout
+	}
+
+	// a Dockerfile that prints a secret must not leak it into the build log
+	redactor := NewRedactor(log, opt.Secrets)
+	defer redactor.Flush()
+
+	counter := newCacheCounter()
+	out := io.MultiWriter(redactor, counter)
+
+	fmt.Fprintf(out, "🔨 Starting %s build...\n", Current.Name())
+
+	var imageID string
+	var findings []secrets.Finding
+	var err error
+	if opt.ComposeFile != "" {
+		fmt.Fprintf(out, "🧩 Compose file: %s\n", opt.ComposeFile)
+		imageID, findings, err = buildStack(ctx, opt, out)
+	} else {
+		imageID, findings, err = buildSingleImage(ctx, opt, out)
+	}
+
+	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return BuildResult{}, fmt.Errorf("%s build: %w", Current.Name(), ErrBuildTimeout)
+		case context.Canceled:
+			return BuildResult{}, fmt.Errorf("%s build: %w", Current.Name(), ErrBuildCancelled)
+		}
+		return BuildResult{}, fmt.Errorf("%s build failed: %w", Current.Name(), err)
+	}
 
-	// Command: sudo ./build.sh <image-name> <context-dir> <dockerfile-path>
-	cmd := exec.Command("./scripts/build.sh", opt.ImageName, opt.ContextDir, opt.DockerfilePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	result := BuildResult{ImageID: imageID, Cache: counter.Stats(), Secrets: findings}
+	if opt.ComposeFile != "" {
+		fmt.Fprintf(out, "✅ Stack built successfully, %s\n", result.Cache)
+	} else {
+		fmt.Fprintf(out, "✅ Image built successfully (%s), %s\n", imageID, result.Cache)
+	}
+	return result, nil
+}
+
+// buildSingleImage builds the Dockerfile of the deployment into its image
+func buildSingleImage(ctx context.Context, opt BuildImageOptions, out io.Writer) (string, []secrets.Finding, error) {
+	fmt.Fprintf(out, "📦 Image: %s\n", opt.ImageName)
+	fmt.Fprintf(out, "📁 Context: %s\n", opt.ContextDir)
+	fmt.Fprintf(out, "📄 Dockerfile: %s\n", opt.DockerfilePath)
+
+	if opt.Target != "" {
+		fmt.Fprintf(out, "🎯 Target: %s\n", opt.Target)
+	}
+
+	if err := checkPolicy(opt, out); err != nil {
+		return "", nil, err
+	}
+
+	findings, err := scanSecrets([]scanTarget{{dir: opt.ContextDir}}, out)
+	if err != nil {
+		return "", findings, err
+	}
+
+	// the tag still points at the last successful build of the deployment, failed builds never move it
+	if len(opt.CacheFrom) == 0 && opt.ImageName != "" {
+		if exists, err := Current.ImageExists(ctx, opt.ImageName); err == nil && exists {
+			opt.CacheFrom = []string{opt.ImageName}
+			fmt.Fprintf(out, "♻️ Cache from: %s\n", opt.ImageName)
+		}
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker build failed: %w", err)
+	if len(opt.CacheMounts) > 0 {
+		dockerfile, added, err := addCacheMounts(opt.DockerfilePath, opt.CacheMounts, opt.CacheID)
+		if err != nil {
+			return "", findings, fmt.Errorf("failed to add cache mounts: %w", err)
+		}
+		opt.DockerfilePath = dockerfile
+		fmt.Fprintf(out, "♻️ Cache mounts: %s (%d added)\n", strings.Join(opt.CacheMounts, ", "), added)
 	}
 
-	fmt.Println("✅ Docker image built successfully")
-	return nil
+	imageID, err := Current.Build(ctx, opt, out)
+	return imageID, findings, err
 }
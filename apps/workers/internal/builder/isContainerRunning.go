@@ -1,27 +1,59 @@
-// the image name is provide and it will check whether the container is running or not.
+// finds the containers of a deployment by the labels StartContainer puts on them
 
 package builder
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
 )
 
-// IsContainerRunning checks if a container is running for a given image name
-func IsContainerRunning(imageName string) (bool, error) {
-	// Command: docker ps --filter ancestor=imageName --format "{{.ID}}"
-	cmd := exec.Command("docker", "ps", "--filter", fmt.Sprintf("ancestor=%s", imageName), "--format", "{{.ID}}")
+// DeploymentContainers lists the containers of a deployment, all includes stopped ones.
+// containers started before they were labelled are still found by their name.
+func DeploymentContainers(deploymentID string, all bool) ([]ContainerInfo, error) {
+	ctx := context.Background()
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
+	containers, err := Current.ContainersWithLabel(ctx, LabelDeployment+"="+deploymentID, all)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers of %s: %w", deploymentID, err)
+	}
+	if len(containers) > 0 {
+		return containers, nil
+	}
+
+	legacy, err := Current.Inspect(ctx, ContainerName(deploymentID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", ContainerName(deploymentID), err)
+	}
+	if legacy.Labels[LabelDeployment] != "" || (!all && !legacy.Running) {
+		return nil, nil // the name belongs to another deployment's container now, or it is not running
+	}
+	return []ContainerInfo{*legacy}, nil
+}
 
-	if err := cmd.Run(); err != nil {
-		return false, fmt.Errorf("failed to check running containers: %w", err)
+// IsDeploymentRunning checks if a container of the deployment is running
+func IsDeploymentRunning(deploymentID string) (bool, error) {
+	containers, err := DeploymentContainers(deploymentID, false)
+	if err != nil {
+		return false, err
 	}
+	return len(containers) > 0, nil
+}
+
+// ManagedContainers lists every container the worker started for a deployment, running or not
+func ManagedContainers() ([]ContainerInfo, error) {
+	return Current.ContainersWithLabel(context.Background(), LabelDeployment, true)
+}
+
+// InspectContainer returns the state of a container by id or name, ErrNotFound when there is none
+func InspectContainer(id string) (*ContainerInfo, error) {
+	return Current.Inspect(context.Background(), id)
+}
 
-	// If output is not empty, at least one container is running with this image
-	isRunning := strings.TrimSpace(out.String()) != ""
-	return isRunning, nil
-}
\ No newline at end of file
+// WatchContainers streams the events of the containers the worker started for a deployment
+func WatchContainers(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	return Current.ContainerEvents(ctx, LabelDeployment)
+}
@@ -0,0 +1,43 @@
+// Runtime for podman through its docker compatible api (podman system service). rootless podman is
+// close enough to docker that most calls are the same, the differences are handled here:
+// - a rootless engine can't publish ports below 1024
+// - repo digests of local images are reported as localhost/<name>@sha256:...
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type podmanRuntime struct {
+	dockerRuntime
+}
+
+func (r *podmanRuntime) Name() string { return "podman" }
+
+func (r *podmanRuntime) Run(ctx context.Context, opt RunOptions) (string, error) {
+	for containerPort, hostPort := range opt.Ports {
+		if hostPort > 0 && hostPort < 1024 { // 0 lets the engine pick one
+			return "", fmt.Errorf("rootless podman can't publish host port %d for container port %d", hostPort, containerPort)
+		}
+	}
+	return r.dockerRuntime.Run(ctx, opt)
+}
+
+func (r *podmanRuntime) ImageDigests(ctx context.Context, ref string) ([]string, error) {
+	digests, err := r.dockerRuntime.ImageDigests(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// podman lists local names as localhost/<name>@sha256:..., those never came from a registry
+	var remote []string
+	for _, d := range digests {
+		if !strings.HasPrefix(d, "localhost/") {
+			remote = append(remote, d)
+		}
+	}
+	return remote, nil
+}
@@ -0,0 +1,115 @@
+// the Dockerfile policy (WORKER_POLICY_*) is checked right before a build, so a Dockerfile that breaks it never
+// reaches the engine. a stack is checked service by service, from its resolved compose file.
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"worker/internal/config"
+	"worker/internal/policy"
+)
+
+// currentPolicy is the policy of the worker config
+func currentPolicy() policy.Policy {
+	return policy.Policy{
+		AllowedRegistries: config.Current.PolicyRegistries,
+		RequireNonRoot:    config.Current.PolicyNonRoot,
+		NoRemoteAdd:       config.Current.PolicyNoRemoteAdd,
+		MaxLayers:         config.Current.PolicyMaxLayers,
+	}
+}
+
+// checkPolicy checks the Dockerfile of a single image build, the error is a *policy.Error when it breaks the policy
+func checkPolicy(opt BuildImageOptions, out io.Writer) error {
+	p := currentPolicy()
+	if !p.Enabled() {
+		return nil
+	}
+
+	violations, err := p.CheckFile(opt.DockerfilePath, reportName(opt.ContextDir, opt.DockerfilePath), policy.Options{
+		BuildArgs: opt.BuildArgs,
+		Target:    opt.Target,
+	})
+	if err != nil {
+		return err
+	}
+	return policyResult(violations, out)
+}
+
+// checkStackPolicy checks that the stack stays isolated from the host and only builds from the workspace, then the
+// Dockerfile of every service that is built and the image of every one that isn't. relative paths of the stack are
+// relative to base, the folder of the compose file.
+func checkStackPolicy(stack map[string]any, workspace, base string, services map[string]composeService, buildArgs map[string]string, out io.Writer) error {
+	isolation := append(stackIsolation(stack), stackPaths(stack, workspace, base)...)
+	if len(isolation) > 0 {
+		return policyResult(isolation, out)
+	}
+
+	p := currentPolicy()
+	if !p.Enabled() {
+		return nil
+	}
+
+	var violations []policy.Violation
+	for _, name := range sortedKeys(services) {
+		service := services[name]
+		if service.Build == nil {
+			violations = append(violations, p.CheckImage(service.Image, "service "+name)...)
+			continue
+		}
+
+		// --build-arg of the compose cli overrides the args of the compose file
+		args := make(map[string]string, len(service.Build.Args)+len(buildArgs))
+		for k, v := range service.Build.Args {
+			args[k] = v
+		}
+		for k, v := range buildArgs {
+			args[k] = v
+		}
+		opt := policy.Options{BuildArgs: args, Target: service.Build.Target}
+
+		var found []policy.Violation
+		var err error
+		if service.Build.DockerfileInline != "" {
+			found, err = p.Check(strings.NewReader(service.Build.DockerfileInline), "service "+name+" (inline Dockerfile)", opt)
+		} else {
+			dockerfile := service.Build.Dockerfile
+			if dockerfile == "" {
+				dockerfile = "Dockerfile"
+			}
+			if !filepath.IsAbs(dockerfile) {
+				dockerfile = filepath.Join(service.Build.Context, dockerfile)
+			}
+			found, err = p.CheckFile(dockerfile, "service "+name+" ("+reportName(service.Build.Context, dockerfile)+")", opt)
+		}
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		violations = append(violations, found...)
+	}
+	return policyResult(violations, out)
+}
+
+// policyResult writes the report into the build log, the build fails when there is anything in it
+func policyResult(violations []policy.Violation, out io.Writer) error {
+	if len(violations) == 0 {
+		fmt.Fprintln(out, "🛡️ Dockerfile policy passed")
+		return nil
+	}
+
+	for _, v := range violations {
+		fmt.Fprintf(out, "🚫 %s\n", v)
+	}
+	return &policy.Error{Violations: violations}
+}
+
+// reportName is the Dockerfile path relative to the build context, the way the repo knows it
+func reportName(contextDir, path string) string {
+	if rel, err := filepath.Rel(contextDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.Base(path)
+}
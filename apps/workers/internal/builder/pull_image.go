@@ -0,0 +1,81 @@
+// pulls a prebuilt image for deployments that don't need a build and tags it for the deployment
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ValidateImageReference does a basic sanity check on the reference. if it is pinned with @ the digest must be a full sha256.
+func ValidateImageReference(ref string) error {
+	if ref == "" || strings.ContainsAny(ref, " \t\n") || strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid image reference %q", ref)
+	}
+
+	if name, digest, pinned := strings.Cut(ref, "@"); pinned {
+		if name == "" || !digestPattern.MatchString(digest) {
+			return fmt.Errorf("invalid image digest in %q, expected name@sha256:<64 hex chars>", ref)
+		}
+	}
+	return nil
+}
+
+// PullImage pulls the image from its registry (dockerhub, a local registry, ...)
+func PullImage(ref string) error {
+	fmt.Printf("📥 Pulling image %s\n", ref)
+
+	if err := Current.PullImage(context.Background(), ref, os.Stdout); err != nil {
+		return fmt.Errorf("%s pull failed: %w", Current.Name(), err)
+	}
+
+	fmt.Printf("✅ Pulled image %s\n", ref)
+	return nil
+}
+
+// TagImage gives an existing image another name
+func TagImage(source, target string) error {
+	if err := Current.TagImage(context.Background(), source, target); err != nil {
+		return fmt.Errorf("%s tag failed: %w", Current.Name(), err)
+	}
+	return nil
+}
+
+// ImageExists tells whether the engine has an image with this reference
+func ImageExists(ref string) (bool, error) {
+	return Current.ImageExists(context.Background(), ref)
+}
+
+// RemoveImage removes an image or only one of its names when it has more, removing a missing image is not an error
+func RemoveImage(ref string) error {
+	if err := Current.RemoveImage(context.Background(), ref); err != nil {
+		return fmt.Errorf("%s image removal failed: %w", Current.Name(), err)
+	}
+	return nil
+}
+
+// ImageDigest returns the repo digest (name@sha256:...) of a pulled image. images that were only built locally have none.
+func ImageDigest(ref string) (string, error) {
+	digests, err := Current.ImageDigests(context.Background(), ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+
+	if len(digests) == 0 {
+		return "", nil
+	}
+
+	// prefer the digest of the repository we pulled from
+	name := repository(ref)
+	for _, d := range digests {
+		if strings.HasPrefix(d, name+"@") {
+			return d, nil
+		}
+	}
+	return digests[0], nil
+}
@@ -0,0 +1,173 @@
+package builder
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"worker/internal/docker"
+	"worker/internal/docker/dockertest"
+)
+
+// useEngine makes the fake engine the runtime of the package functions for the test
+func useEngine(t *testing.T, engine *dockertest.Engine) {
+	t.Helper()
+	previous := Current
+	Current = &dockerRuntime{client: docker.New(engine.Socket, "")}
+	t.Cleanup(func() { Current = previous })
+}
+
+func TestValidateImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+
+	for _, tc := range []struct {
+		ref   string
+		valid bool
+	}{
+		{"nginx", true},
+		{"nginx:1.25-alpine", true},
+		{"localhost:5000/team/app:3f2a9c1-4", true},
+		{"localhost:5000/team/app@" + digest, true},
+		{"", false},
+		{"nginx latest", false},
+		{"--help", false},
+		{"nginx@sha256:abc", false},
+		{"@" + digest, false},
+		{"nginx@md5:" + strings.Repeat("ab", 16), false},
+	} {
+		if err := ValidateImageReference(tc.ref); (err == nil) != tc.valid {
+			t.Errorf("%q: got %v, want valid=%t", tc.ref, err, tc.valid)
+		}
+	}
+}
+
+func TestPullImage(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+	withRegistry(t, "", "", "")
+
+	if err := PullImage("docker.io/library/nginx:1.25"); err != nil {
+		t.Fatal(err)
+	}
+
+	pull := engine.LastRequest("POST", "/images/create")
+	if pull == nil {
+		t.Fatal("nothing was pulled")
+	}
+	if from, tag := pull.Query["fromImage"], pull.Query["tag"]; len(from) != 1 || from[0] != "docker.io/library/nginx" || len(tag) != 1 || tag[0] != "1.25" {
+		t.Errorf("pulled %v:%v, want docker.io/library/nginx:1.25", from, tag)
+	}
+	if auth := pull.Header.Get("X-Registry-Auth"); auth != "" {
+		t.Errorf("anonymous pull sent credentials %q", auth)
+	}
+
+	if exists, err := ImageExists("docker.io/library/nginx:1.25"); err != nil || !exists {
+		t.Fatalf("pulled image not found (err %v)", err)
+	}
+	digest, err := ImageDigest("docker.io/library/nginx:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(digest, "docker.io/library/nginx@sha256:") {
+		t.Errorf("digest %q is not of the pulled repository", digest)
+	}
+}
+
+func TestPullImageByDigest(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+
+	if err := PullImage("registry.test:5000/app:1"); err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := ImageDigest("registry.test:5000/app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PullImage(pinned); err != nil {
+		t.Fatalf("pull %s: %v", pinned, err)
+	}
+	_, digest, _ := strings.Cut(pinned, "@")
+	if tag := engine.LastRequest("POST", "/images/create").Query["tag"]; len(tag) != 1 || tag[0] != digest {
+		t.Errorf("pinned pull sent tag %v, want the digest %s", tag, digest)
+	}
+}
+
+// the digest of the repository an image was pulled from wins over the ones it got from other names
+func TestImageDigestPrefersRepository(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+
+	if err := PullImage("mirror.test/library/busybox:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagImage("mirror.test/library/busybox:1", "blacktree/busybox-1234abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := PullImage("blacktree/busybox-1234abcd"); err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, repo := range map[string]string{
+		"mirror.test/library/busybox:1":     "mirror.test/library/busybox@",
+		"blacktree/busybox-1234abcd:latest": "blacktree/busybox-1234abcd@",
+	} {
+		digest, err := ImageDigest(ref)
+		if err != nil || !strings.HasPrefix(digest, repo) {
+			t.Errorf("%s: got %q (err %v), want a digest of %s", ref, digest, err, repo)
+		}
+	}
+}
+
+func TestPullImageFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		message string
+		check   func(error) bool
+	}{
+		{"missing repository", http.StatusNotFound, "pull access denied for nope, repository does not exist", docker.IsNotFound},
+		{"registry down", http.StatusInternalServerError, "Get https://registry.test/v2/: dial tcp: connection refused", func(err error) bool { return errors.Is(err, docker.ErrDaemon) }},
+		{"invalid reference", http.StatusBadRequest, "invalid reference format", func(err error) bool { return errors.Is(err, docker.ErrBadRequest) }},
+		{"stream error", 0, "manifest for nope:1 not found: manifest unknown", func(err error) bool {
+			var streamErr *docker.StreamError
+			return errors.As(err, &streamErr) && streamErr.Op == "pull"
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := dockertest.NewEngine(t)
+			useEngine(t, engine)
+			engine.Fail("POST", "/images/create", tc.status, tc.message)
+
+			err := PullImage("nope:1")
+			if err == nil {
+				t.Fatal("pull did not fail")
+			}
+			if !tc.check(err) {
+				t.Errorf("unexpected error type %T: %v", errors.Unwrap(err), err)
+			}
+			if !strings.HasPrefix(err.Error(), "docker pull failed: ") || !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("error %q doesn't say what failed", err)
+			}
+			if engine.HasImage("nope:1") {
+				t.Error("a failed pull left an image behind")
+			}
+		})
+	}
+}
+
+func TestImageDigestMissingImage(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+
+	if _, err := ImageDigest("nope:1"); !docker.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if exists, err := ImageExists("nope:1"); err != nil || exists {
+		t.Errorf("missing image: exists=%t err=%v", exists, err)
+	}
+	if err := RemoveImage("nope:1"); err != nil {
+		t.Errorf("removing a missing image: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// built images can be pushed to a registry (WORKER_REGISTRY), so a release outlives the worker that built it and
+// any worker can pull it by digest. pulls and pushes to that registry use its credentials, everything else is
+// pulled anonymously like before.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"worker/internal/config"
+	"worker/internal/docker"
+)
+
+// RegistryEnabled tells if built images are pushed
+func RegistryEnabled() bool {
+	return config.Current.Registry != ""
+}
+
+// RegistryRef is the name a local image gets in the registry,
+// blacktree/app-1234abcd:3f2a9c1-4 becomes <registry>/blacktree/app-1234abcd:3f2a9c1-4
+func RegistryRef(image string) string {
+	return config.Current.Registry + "/" + image
+}
+
+// LocalName turns a reference from the registry back into the local image name it was pushed from,
+// <registry>/blacktree/app-1234abcd@sha256:... is blacktree/app-1234abcd. ok is false for other registries.
+func LocalName(ref string) (string, bool) {
+	if !RegistryEnabled() || !strings.HasPrefix(ref, config.Current.Registry+"/") {
+		return "", false
+	}
+	return repository(strings.TrimPrefix(ref, config.Current.Registry+"/")), true
+}
+
+// PushImage pushes a local image to the registry and returns the reference that pins it, <registry>/<name>@sha256:...
+func PushImage(image string) (string, error) {
+	ctx := context.Background()
+	ref := RegistryRef(image)
+
+	if err := TagImage(image, ref); err != nil {
+		return "", err
+	}
+	// the registry name is only needed for the push, the image keeps its local names
+	defer func() {
+		if err := Current.RemoveImage(ctx, ref); err != nil {
+			log.Printf("⚠️ Failed to remove the registry tag %s: %v", ref, err)
+		}
+	}()
+
+	fmt.Printf("📤 Pushing %s\n", ref)
+	digest, err := Current.PushImage(ctx, ref, os.Stdout)
+	if err != nil {
+		return "", fmt.Errorf("%s push failed: %w", Current.Name(), err)
+	}
+
+	pinned := repository(ref) + "@" + digest
+	if digest == "" { // the engine didn't report it, after a push the image knows its repo digest
+		if pinned, err = ImageDigest(ref); err != nil || pinned == "" {
+			return "", fmt.Errorf("pushed %s but its digest is unknown: %v", ref, err)
+		}
+	}
+
+	fmt.Printf("✅ Pushed %s\n", pinned)
+	return pinned, nil
+}
+
+// registryAuth returns the credentials for ref when it is in the configured registry, nil for any other
+func registryAuth(ref string) *docker.AuthConfig {
+	if !RegistryEnabled() || config.Current.RegistryUsername == "" {
+		return nil
+	}
+
+	host, _, _ := strings.Cut(config.Current.Registry, "/")
+	if registryHost(ref) != host {
+		return nil
+	}
+	return &docker.AuthConfig{
+		Username:      config.Current.RegistryUsername,
+		Password:      config.Current.RegistryPassword,
+		ServerAddress: host,
+	}
+}
+
+// registryHost is the registry part of a reference, docker.io for names without one
+func registryHost(ref string) string {
+	first, _, found := strings.Cut(ref, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+// repository drops the tag or digest of a reference
+func repository(ref string) string {
+	name, _, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,170 @@
+package builder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"worker/internal/docker"
+	"worker/internal/docker/dockertest"
+)
+
+// decodeAuth reads the X-Registry-Auth header of a request, nil when it has none
+func decodeAuth(t *testing.T, req *dockertest.Request) *docker.AuthConfig {
+	t.Helper()
+	header := req.Header.Get("X-Registry-Auth")
+	if header == "" {
+		return nil
+	}
+
+	data, err := base64.URLEncoding.DecodeString(header)
+	if err != nil {
+		t.Fatalf("X-Registry-Auth is not base64url: %v", err)
+	}
+	var auth docker.AuthConfig
+	if err := json.Unmarshal(data, &auth); err != nil {
+		t.Fatalf("X-Registry-Auth is not json: %v", err)
+	}
+	return &auth
+}
+
+func TestRegistryRefAndLocalName(t *testing.T) {
+	withRegistry(t, "registry.test:5000/team", "", "")
+
+	if got, want := RegistryRef("blacktree/app-1234abcd:3f2a9c1-4"), "registry.test:5000/team/blacktree/app-1234abcd:3f2a9c1-4"; got != want {
+		t.Errorf("registry ref %q, want %q", got, want)
+	}
+
+	for ref, want := range map[string]string{
+		"registry.test:5000/team/blacktree/app-1234abcd@sha256:" + strings.Repeat("ab", 32): "blacktree/app-1234abcd",
+		"registry.test:5000/team/blacktree/app-1234abcd:3f2a9c1-4":                          "blacktree/app-1234abcd",
+		"registry.test:5000/other/app:1":                                                    "",
+		"docker.io/library/nginx:1.25":                                                      "",
+	} {
+		got, ok := LocalName(ref)
+		if got != want || ok != (want != "") {
+			t.Errorf("%s: got %q %t, want %q", ref, got, ok, want)
+		}
+	}
+}
+
+// credentials only go to the configured registry, as base64url json the way the engine expects them
+func TestRegistryAuth(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+	withRegistry(t, "registry.test:5000/team", "ci", "s3cr+t/=")
+
+	if err := PullImage("registry.test:5000/team/blacktree/app-1234abcd:3f2a9c1-4"); err != nil {
+		t.Fatal(err)
+	}
+	auth := decodeAuth(t, engine.LastRequest("POST", "/images/create"))
+	if auth == nil || *auth != (docker.AuthConfig{Username: "ci", Password: "s3cr+t/=", ServerAddress: "registry.test:5000"}) {
+		t.Errorf("registry pull sent %+v", auth)
+	}
+
+	for _, ref := range []string{"docker.io/library/nginx:1.25", "nginx:1.25", "registry.test:5001/team/app:1", "localhost/app:1"} {
+		if err := PullImage(ref); err != nil {
+			t.Fatal(err)
+		}
+		if auth := decodeAuth(t, engine.LastRequest("POST", "/images/create")); auth != nil {
+			t.Errorf("%s: pull from another registry sent %+v", ref, auth)
+		}
+	}
+}
+
+func TestPushImage(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+	withRegistry(t, "registry.test:5000", "ci", "s3cret")
+	engine.AddImage("blacktree/app-1234abcd:3f2a9c1-4")
+
+	pinned, err := PushImage("blacktree/app-1234abcd:3f2a9c1-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := engine.LastRequest("POST", "/images/registry.test:5000/blacktree/app-1234abcd/push")
+	if push == nil {
+		t.Fatalf("nothing was pushed to the registry: %+v", engine.Requests())
+	}
+	if tag := push.Query["tag"]; len(tag) != 1 || tag[0] != "3f2a9c1-4" {
+		t.Errorf("pushed tag %v, want 3f2a9c1-4", tag)
+	}
+	if auth := decodeAuth(t, push); auth == nil || auth.Username != "ci" || auth.Password != "s3cret" || auth.ServerAddress != "registry.test:5000" {
+		t.Errorf("push sent %+v", auth)
+	}
+
+	// the digest is the one the engine reported for the push
+	digests, err := ImageDigest("blacktree/app-1234abcd:3f2a9c1-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pinned, "registry.test:5000/blacktree/app-1234abcd@sha256:") || pinned != digests {
+		t.Errorf("pushed as %q, the image knows %q", pinned, digests)
+	}
+	if err := ValidateImageReference(pinned); err != nil {
+		t.Errorf("pushed reference can't be pulled: %v", err)
+	}
+
+	// the registry name is only needed for the push
+	if engine.HasImage("registry.test:5000/blacktree/app-1234abcd:3f2a9c1-4") {
+		t.Error("the registry tag was left behind")
+	}
+	if !engine.HasImage("blacktree/app-1234abcd:3f2a9c1-4") {
+		t.Error("the local image was removed")
+	}
+}
+
+// an engine that doesn't report the digest in the push stream still has it on the image afterwards
+func TestPushImageDigestFromInspect(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.PushDigest = false
+	useEngine(t, engine)
+	withRegistry(t, "registry.test:5000", "", "")
+	engine.AddImage("blacktree/app-1234abcd:build-2")
+
+	pinned, err := PushImage("blacktree/app-1234abcd:build-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pinned, "registry.test:5000/blacktree/app-1234abcd@sha256:") {
+		t.Errorf("pushed as %q", pinned)
+	}
+
+	// the engine refuses pushes without the header, so it is sent even without credentials
+	push := engine.LastRequest("POST", "/images/registry.test:5000/blacktree/app-1234abcd/push")
+	if auth := decodeAuth(t, push); auth == nil || *auth != (docker.AuthConfig{}) {
+		t.Errorf("push without credentials sent %+v", auth)
+	}
+}
+
+func TestPushImageFailure(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+	withRegistry(t, "registry.test:5000", "ci", "wrong")
+	engine.AddImage("blacktree/app-1234abcd:3f2a9c1-4")
+	engine.Fail("POST", "/images/registry.test:5000/blacktree/app-1234abcd/push", 0, "unauthorized: authentication required")
+
+	pinned, err := PushImage("blacktree/app-1234abcd:3f2a9c1-4")
+	if err == nil {
+		t.Fatalf("push did not fail, got %q", pinned)
+	}
+	var streamErr *docker.StreamError
+	if !errors.As(err, &streamErr) || streamErr.Op != "push" || streamErr.Message != "unauthorized: authentication required" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if engine.HasImage("registry.test:5000/blacktree/app-1234abcd:3f2a9c1-4") {
+		t.Error("the registry tag was left behind after a failed push")
+	}
+}
+
+func TestPushMissingImage(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	useEngine(t, engine)
+	withRegistry(t, "registry.test:5000", "", "")
+
+	if _, err := PushImage("blacktree/app-1234abcd:gone-1"); !docker.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// limits of a deployment's container. a deployment asks for what it needs, what it leaves out comes from the
+// worker defaults (WORKER_CONTAINER_*) and nothing may go above the worker maxima (WORKER_CONTAINER_MAX_*), so
+// one container can't take the host down with it.
+
+package builder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"worker/internal/config"
+	"worker/internal/docker"
+)
+
+// Resources are the limits a deployment asks for, 0 (or nothing) takes the worker default
+type Resources struct {
+	CPUs         float64  // cpu time as a number of cpus, e.g. 0.5
+	CPUShares    int64    // relative weight when the cpus are contended, the engine's default is 1024
+	MemoryMB     int64    // memory limit
+	MemorySwapMB int64    // memory plus swap, the same as MemoryMB (or 0) for no swap
+	PidsLimit    int64    // processes and threads
+	Ulimits      []string // name=soft[:hard], e.g. nofile=1024:4096
+}
+
+type Ulimit = docker.Ulimit
+
+const mb = 1024 * 1024
+
+// Limits are the resolved Resources in the units of the engine api, what a container is run with
+type Limits struct {
+	NanoCPUs   int64
+	CPUShares  int64
+	Memory     int64 // bytes
+	MemorySwap int64 // bytes
+	PidsLimit  int64
+	Ulimits    []Ulimit
+}
+
+// ValidateResources rejects limits that are invalid or above the worker maxima
+func ValidateResources(r Resources) error {
+	if r.CPUs < 0 || r.CPUShares < 0 || r.MemoryMB < 0 || r.MemorySwapMB < 0 || r.PidsLimit < 0 {
+		return fmt.Errorf("resource limits can't be negative")
+	}
+	if limit := config.Current.MaxContainerCPUs; limit > 0 && r.CPUs > limit {
+		return fmt.Errorf("cpus %g is above the maximum of %g", r.CPUs, limit)
+	}
+	if r.CPUShares != 0 && (r.CPUShares < 2 || r.CPUShares > 262144) {
+		return fmt.Errorf("cpuShares must be between 2 and 262144")
+	}
+	if limit := config.Current.MaxContainerMemory; limit > 0 && r.MemoryMB*mb > limit {
+		return fmt.Errorf("memoryMb %d is above the maximum of %d MB", r.MemoryMB, limit/mb)
+	}
+	if limit := config.Current.MaxContainerPids; limit > 0 && r.PidsLimit > limit {
+		return fmt.Errorf("pidsLimit %d is above the maximum of %d", r.PidsLimit, limit)
+	}
+
+	if r.MemorySwapMB > 0 {
+		memory := r.MemoryMB * mb
+		if memory == 0 {
+			memory = config.Current.ContainerMemory
+		}
+		if memory == 0 {
+			return fmt.Errorf("memorySwapMb needs a memory limit")
+		}
+		if r.MemorySwapMB*mb < memory {
+			return fmt.Errorf("memorySwapMb is memory plus swap, it can't be below the memory limit of %d MB", memory/mb)
+		}
+		if limit := config.Current.MaxContainerSwap; limit > 0 && r.MemorySwapMB*mb-memory > limit {
+			return fmt.Errorf("memorySwapMb allows %d MB of swap, the maximum is %d MB", (r.MemorySwapMB*mb-memory)/mb, limit/mb)
+		}
+	}
+
+	defaults, err := parseUlimits(config.Current.ContainerUlimits)
+	if err != nil {
+		return fmt.Errorf("WORKER_CONTAINER_ULIMITS: %w", err)
+	}
+	ulimits, err := parseUlimits(r.Ulimits)
+	if err != nil {
+		return err
+	}
+	for name, u := range ulimits {
+		if d, ok := defaults[name]; ok && u.Hard > d.Hard {
+			return fmt.Errorf("ulimit %s hard limit %d is above the maximum of %d", name, u.Hard, d.Hard)
+		}
+	}
+	return nil
+}
+
+// the ulimits the engine knows
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true, "memlock": true, "msgqueue": true, "nice": true,
+	"nofile": true, "nproc": true, "rss": true, "rtprio": true, "rttime": true, "sigpending": true, "stack": true,
+}
+
+// resolve fills in the worker defaults and caps everything at the maxima. the maxima are checked when the
+// deployment is created, capping again here covers maxima that were lowered since.
+func (r Resources) resolve() (Limits, error) {
+	cfg := config.Current
+	l := Limits{CPUShares: r.CPUShares}
+
+	cpus := r.CPUs
+	if cpus == 0 {
+		cpus = cfg.ContainerCPUs
+	}
+	if cfg.MaxContainerCPUs > 0 && (cpus == 0 || cpus > cfg.MaxContainerCPUs) {
+		cpus = cfg.MaxContainerCPUs
+	}
+	l.NanoCPUs = int64(cpus * 1e9)
+
+	l.Memory = r.MemoryMB * mb
+	if l.Memory == 0 {
+		l.Memory = cfg.ContainerMemory
+	}
+	if cfg.MaxContainerMemory > 0 && (l.Memory == 0 || l.Memory > cfg.MaxContainerMemory) {
+		l.Memory = cfg.MaxContainerMemory
+	}
+
+	if l.Memory > 0 {
+		l.MemorySwap = l.Memory // no swap unless asked for
+		if swap := r.MemorySwapMB * mb; swap > l.Memory {
+			l.MemorySwap = swap
+			if cfg.MaxContainerSwap > 0 && swap-l.Memory > cfg.MaxContainerSwap {
+				l.MemorySwap = l.Memory + cfg.MaxContainerSwap
+			}
+		}
+	}
+
+	l.PidsLimit = r.PidsLimit
+	if l.PidsLimit == 0 {
+		l.PidsLimit = cfg.ContainerPids
+	}
+	if cfg.MaxContainerPids > 0 && (l.PidsLimit == 0 || l.PidsLimit > cfg.MaxContainerPids) {
+		l.PidsLimit = cfg.MaxContainerPids
+	}
+
+	ulimits, err := parseUlimits(cfg.ContainerUlimits)
+	if err != nil {
+		return l, fmt.Errorf("WORKER_CONTAINER_ULIMITS: %w", err)
+	}
+	own, err := parseUlimits(r.Ulimits)
+	if err != nil {
+		return l, err
+	}
+	for name, u := range own {
+		if d, ok := ulimits[name]; ok {
+			u.Hard = min(u.Hard, d.Hard)
+			u.Soft = min(u.Soft, u.Hard)
+		}
+		ulimits[name] = u
+	}
+	for _, name := range sortedKeys(ulimits) {
+		l.Ulimits = append(l.Ulimits, ulimits[name])
+	}
+	return l, nil
+}
+
+// parseUlimits reads name=soft[:hard] entries, the hard limit defaults to the soft one
+func parseUlimits(entries []string) (map[string]Ulimit, error) {
+	ulimits := make(map[string]Ulimit)
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !ulimitNames[name] {
+			return nil, fmt.Errorf("invalid ulimit %q, expected name=soft[:hard]", entry)
+		}
+
+		softValue, hardValue, hasHard := strings.Cut(value, ":")
+		soft, err := strconv.ParseInt(softValue, 10, 64)
+		hard := soft
+		if err == nil && hasHard {
+			hard, err = strconv.ParseInt(hardValue, 10, 64)
+		}
+		if err != nil || soft < 0 || hard < soft {
+			return nil, fmt.Errorf("invalid ulimit %q, expected name=soft[:hard] with soft <= hard", entry)
+		}
+		ulimits[name] = Ulimit{Name: name, Soft: soft, Hard: hard}
+	}
+	return ulimits, nil
+}
@@ -0,0 +1,110 @@
+// the container engine behind the worker. docker and (rootless) podman both speak the engine api,
+// but they differ in a few places, so everything in the worker goes through Runtime and only the
+// implementations know which engine they talk to. WORKER_RUNTIME picks one.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+	"worker/internal/config"
+	"worker/internal/docker"
+)
+
+// Runtime is what the worker needs from a container engine
+type Runtime interface {
+	Name() string // "docker" or "podman"
+
+	// Build builds the context into the image and writes the build output to out. it returns the image id.
+	Build(ctx context.Context, opt BuildImageOptions, out io.Writer) (string, error)
+	// Run creates and starts a container and returns its id
+	Run(ctx context.Context, opt RunOptions) (string, error)
+	// Stop stops a container, killing it when it is still running after timeout. stopped containers are not an error.
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+	// Remove deletes a container, running or not. containers that don't exist are not an error.
+	Remove(ctx context.Context, id string) error
+	// Inspect returns the state of a container, ErrNotFound when it doesn't exist
+	Inspect(ctx context.Context, id string) (*ContainerInfo, error)
+	// Logs returns stdout and stderr of the container as one stream, the caller closes it
+	Logs(ctx context.Context, id string, opt LogsOptions) (io.ReadCloser, error)
+	// ContainersWithLabel lists the containers carrying the label, "key" or "key=value"
+	ContainersWithLabel(ctx context.Context, label string, all bool) ([]ContainerInfo, error)
+	// Exec runs a command in a running container, writes its output to out and returns its exit code
+	Exec(ctx context.Context, id string, cmd []string, out io.Writer) (int, error)
+	// ContainerEvents streams what happens to the containers carrying the label. the events channel is closed
+	// when the stream ends, the error channel then gets the reason (nil when ctx was cancelled).
+	ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, <-chan error)
+
+	PullImage(ctx context.Context, ref string, out io.Writer) error
+	// PushImage pushes name:tag and returns the digest (sha256:...) the registry stored it under
+	PushImage(ctx context.Context, ref string, out io.Writer) (string, error)
+	TagImage(ctx context.Context, source, target string) error
+	ImageExists(ctx context.Context, ref string) (bool, error)
+	// ImageDigests returns the repo digests (name@sha256:...) of the image, empty for images that never saw a registry
+	ImageDigests(ctx context.Context, ref string) ([]string, error)
+	// RemoveImage deletes the image. images that don't exist are not an error.
+	RemoveImage(ctx context.Context, ref string) error
+}
+
+// RunOptions describes a container to start
+type RunOptions struct {
+	Name   string
+	Image  string
+	Ports  map[int]int // container port -> host port
+	Env    []string    // KEY=value
+	Labels map[string]string
+	Limits Limits
+}
+
+// ContainerInfo is the part of a container's state the worker looks at
+type ContainerInfo struct {
+	ID        string
+	Name      string
+	Image     string // image name the container was created from
+	State     string // created, running, exited, ...
+	Running   bool
+	ExitCode  int
+	OOMKilled bool
+	Memory    int64       // memory limit in bytes, 0 = unlimited
+	Ports     map[int]int // container port -> host port, only from Inspect
+	Labels    map[string]string
+}
+
+// ContainerEvent is something that happened to a container
+type ContainerEvent struct {
+	ID     string
+	Action string // start, die, oom, ...
+	Labels map[string]string
+}
+
+type LogsOptions = docker.LogsOptions
+
+// ErrNotFound is returned for a container or image that doesn't exist, check it with errors.Is
+var ErrNotFound = docker.ErrNotFound
+
+// Current is the runtime picked by WORKER_RUNTIME, set by Init
+var Current Runtime
+
+// NewRuntime returns the runtime with the given name, using the socket from the worker config
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "", "docker":
+		return &dockerRuntime{client: docker.New(config.Current.DockerSocket, config.Current.DockerAPIVersion)}, nil
+	case "podman":
+		return &podmanRuntime{dockerRuntime{client: docker.New(config.Current.PodmanSocket, config.Current.DockerAPIVersion)}}, nil
+	}
+	return nil, fmt.Errorf("unknown runtime %q, expected docker or podman", name)
+}
+
+// Init picks the runtime of WORKER_RUNTIME. it is called at startup, a typo in WORKER_RUNTIME must stop the worker
+// instead of silently falling back to another engine.
+func Init() error {
+	rt, err := NewRuntime(config.Current.Runtime)
+	if err != nil {
+		return err
+	}
+	Current = rt
+	return nil
+}
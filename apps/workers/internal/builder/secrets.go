@@ -0,0 +1,95 @@
+// the build context is scanned for secrets right before the build (WORKER_SECRET_SCAN). with warn the findings
+// only go into the build log and the build report, with block they fail the build before anything is sent.
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"worker/internal/config"
+	"worker/internal/secrets"
+)
+
+// modes of WORKER_SECRET_SCAN
+const (
+	SecretScanOff   = "off"
+	SecretScanWarn  = "warn"
+	SecretScanBlock = "block"
+)
+
+// scanTarget is one build context, prefix tells its files apart from those of other contexts of a stack
+type scanTarget struct {
+	dir    string
+	prefix string
+}
+
+// scanSecrets scans the build contexts, the error is a *secrets.Error when the scan blocks the build
+func scanSecrets(targets []scanTarget, out io.Writer) ([]secrets.Finding, error) {
+	mode := strings.ToLower(config.Current.SecretScan)
+	switch mode {
+	case SecretScanOff:
+		return nil, nil
+	case SecretScanWarn, SecretScanBlock:
+	default:
+		log.Printf("⚠️ Unknown WORKER_SECRET_SCAN %q, using %s", config.Current.SecretScan, SecretScanWarn)
+		mode = SecretScanWarn
+	}
+
+	var all secrets.Report
+	for _, target := range targets {
+		report, err := secrets.Scan(target.dir)
+		if err != nil {
+			return nil, fmt.Errorf("secret scan of %s: %w", target.dir, err)
+		}
+		for _, f := range report.Findings {
+			f.File = target.prefix + f.File
+			all.Findings = append(all.Findings, f)
+		}
+		all.Files += report.Files
+		all.Truncated = all.Truncated || report.Truncated
+	}
+
+	if len(all.Findings) == 0 {
+		fmt.Fprintf(out, "🔐 Secret scan passed (%d files)\n", all.Files)
+		return nil, nil
+	}
+
+	icon := "⚠️"
+	if mode == SecretScanBlock {
+		icon = "🚫"
+	}
+	fmt.Fprintf(out, "%s Secret scan found %d possible secret(s) in %d files, add them to .dockerignore or mark the line with %s\n", icon, len(all.Findings), all.Files, secrets.AllowMarker)
+	for _, f := range all.Findings {
+		fmt.Fprintf(out, "%s %s\n", icon, f)
+	}
+
+	if mode == SecretScanBlock {
+		return all.Findings, &secrets.Error{Report: all}
+	}
+	return all.Findings, nil
+}
+
+// stackScanTargets are the distinct build contexts of a stack, named relative to the compose file
+func stackScanTargets(composeFile string, services map[string]composeService) []scanTarget {
+	base, _ := filepath.Abs(filepath.Dir(composeFile))
+	seen := make(map[string]bool)
+
+	var targets []scanTarget
+	for _, name := range sortedKeys(services) {
+		build := services[name].Build
+		if build == nil || !filepath.IsAbs(build.Context) || seen[build.Context] { // git urls are not on disk
+			continue
+		}
+		seen[build.Context] = true
+
+		prefix := ""
+		if rel, err := filepath.Rel(base, build.Context); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			prefix = filepath.ToSlash(rel) + "/"
+		}
+		targets = append(targets, scanTarget{dir: build.Context, prefix: prefix})
+	}
+	return targets
+}
@@ -0,0 +1,223 @@
+// a compose file comes from the repo, just like a Dockerfile, so it can't be trusted with the host. whatever would
+// give a service access to the host (privileges, host namespaces, host paths, devices) is refused, whether the
+// Dockerfile policy is enabled or not. this is checked on the resolved file, before the build and again before `up`.
+// before the build the paths the stack builds from and reads env files from are also checked to stay in the clone.
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"worker/internal/policy"
+)
+
+// rule of the violations found here
+const ruleStackIsolation = "stack-isolation"
+
+// service keys that are refused whatever their value
+var hostAccessKeys = []string{
+	"privileged", "cap_add", "devices", "device_cgroup_rules", "security_opt", "cgroup_parent", "volumes_from",
+}
+
+// service keys whose value must not share a namespace with the host or another container
+var namespaceKeys = []string{"network_mode", "pid", "ipc", "uts", "userns_mode", "cgroup"}
+
+// stackIsolation lists what in a resolved compose file would reach outside the stack's own containers
+func stackIsolation(stack map[string]any) []policy.Violation {
+	var violations []policy.Violation
+	refuse := func(file, format string, args ...any) {
+		violations = append(violations, policy.Violation{File: file, Rule: ruleStackIsolation, Message: fmt.Sprintf(format, args...)})
+	}
+
+	services, _ := stack["services"].(map[string]any)
+	for _, name := range sortedKeys(services) {
+		service, ok := services[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		file := "service " + name
+
+		for _, key := range hostAccessKeys {
+			if value, ok := service[key]; ok && value != nil && value != false {
+				refuse(file, "%s is not allowed", key)
+			}
+		}
+		for _, key := range namespaceKeys {
+			if value, _ := service[key].(string); value == "host" || strings.HasPrefix(value, "container:") {
+				refuse(file, "%s: %s is not allowed", key, value)
+			}
+		}
+
+		volumes, _ := service["volumes"].([]any)
+		for _, v := range volumes {
+			if source, ok := bindSource(v); ok {
+				refuse(file, "bind mount of host path %s is not allowed, use a named volume", source)
+			}
+		}
+
+		if build, ok := service["build"].(map[string]any); ok {
+			if network, _ := build["network"].(string); network == "host" {
+				refuse(file, "build network: host is not allowed")
+			}
+			for _, key := range []string{"privileged", "entitlements"} {
+				if value, ok := build[key]; ok && value != nil && value != false {
+					refuse(file, "build %s is not allowed", key)
+				}
+			}
+		}
+	}
+
+	// a local volume with driver options can be a bind mount of any host path
+	volumes, _ := stack["volumes"].(map[string]any)
+	for _, name := range sortedKeys(volumes) {
+		if volume, ok := volumes[name].(map[string]any); ok && volume["driver_opts"] != nil {
+			refuse("volume "+name, "driver_opts are not allowed")
+		}
+	}
+
+	// file and environment sources read the worker's host and the worker's own environment
+	for _, kind := range []string{"configs", "secrets"} {
+		entries, _ := stack[kind].(map[string]any)
+		for _, name := range sortedKeys(entries) {
+			entry, ok := entries[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, key := range []string{"file", "environment"} {
+				if _, ok := entry[key]; ok {
+					refuse(strings.TrimSuffix(kind, "s")+" "+name, "%s sources are not allowed", key)
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// bindSource is the host path of a bind mount, in the long syntax compose config resolves to or the short one
+func bindSource(volume any) (string, bool) {
+	switch v := volume.(type) {
+	case map[string]any:
+		source, _ := v["source"].(string)
+		return source, v["type"] == "bind"
+	case string:
+		source, _, ok := strings.Cut(v, ":")
+		if ok && (filepath.IsAbs(source) || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")) {
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// stackPaths lists the paths of a resolved compose file that lead out of the workspace. a build context, Dockerfile
+// or additional context there would send the worker's data folder or any host file to the engine, an env file would
+// be read into a service's environment. symlinks are followed, a link in the repo can point anywhere.
+func stackPaths(stack map[string]any, workspace, base string) []policy.Violation {
+	var violations []policy.Violation
+
+	root, err := resolvePath(workspace, "")
+	if err != nil {
+		return []policy.Violation{{Rule: ruleStackIsolation, Message: fmt.Sprintf("workspace %s can't be resolved: %v", workspace, err)}}
+	}
+	check := func(file, what, path string) {
+		if !localPath(path) {
+			return // git urls, docker-image://, service: and target: contexts
+		}
+		resolved, err := resolvePath(path, base)
+		if err != nil {
+			violations = append(violations, policy.Violation{File: file, Rule: ruleStackIsolation, Message: fmt.Sprintf("%s %s can't be resolved: %v", what, path, err)})
+			return
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			violations = append(violations, policy.Violation{File: file, Rule: ruleStackIsolation, Message: fmt.Sprintf("%s %s is outside the repository", what, path)})
+		}
+	}
+
+	services, _ := stack["services"].(map[string]any)
+	for _, name := range sortedKeys(services) {
+		service, ok := services[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		file := "service " + name
+
+		if build, ok := service["build"].(map[string]any); ok {
+			context, _ := build["context"].(string)
+			check(file, "build context", context)
+
+			if dockerfile, _ := build["dockerfile"].(string); dockerfile != "" && localPath(context) {
+				if !filepath.IsAbs(dockerfile) {
+					dockerfile = filepath.Join(context, dockerfile)
+				}
+				check(file, "build dockerfile", dockerfile)
+			}
+
+			switch contexts := build["additional_contexts"].(type) {
+			case map[string]any:
+				for _, key := range sortedKeys(contexts) {
+					value, _ := contexts[key].(string)
+					check(file, "build additional context "+key, value)
+				}
+			case []any:
+				for _, c := range contexts {
+					entry, _ := c.(string)
+					key, value, _ := strings.Cut(entry, "=")
+					check(file, "build additional context "+key, value)
+				}
+			}
+		}
+
+		switch envFiles := service["env_file"].(type) {
+		case string:
+			check(file, "env_file", envFiles)
+		case []any:
+			for _, e := range envFiles {
+				switch entry := e.(type) {
+				case string:
+					check(file, "env_file", entry)
+				case map[string]any:
+					path, _ := entry["path"].(string)
+					check(file, "env_file", path)
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// localPath tells a path on disk from the remote sources compose takes for contexts. compose config makes the
+// paths absolute, relative ones are only kept in case a version doesn't.
+func localPath(path string) bool {
+	return filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+}
+
+// resolvePath makes path absolute (relative to base) and follows its symlinks. a path that doesn't exist yet is
+// resolved as far as it does.
+func resolvePath(path, base string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	missing := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = filepath.Join(filepath.Base(path), missing)
+		path = parent
+	}
+}
@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStackPaths(t *testing.T) {
+	dir := t.TempDir()
+	workspace := filepath.Join(dir, "repos", "app-1")
+	outside := filepath.Join(dir, "data")
+	for _, d := range []string{filepath.Join(workspace, "web"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(workspace, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	web := filepath.Join(workspace, "web")
+
+	for _, tc := range []struct {
+		name    string
+		service map[string]any
+		refused string // part of the message, empty when the service is fine
+	}{
+		{"context in the repo", map[string]any{"build": map[string]any{"context": web, "dockerfile": "Dockerfile"}}, ""},
+		{"context outside", map[string]any{"build": map[string]any{"context": filepath.Join(workspace, "..", "..")}}, "build context"},
+		{"context through a symlink", map[string]any{"build": map[string]any{"context": filepath.Join(workspace, "linked")}}, "build context"},
+		{"relative context", map[string]any{"build": map[string]any{"context": "../../.."}}, "build context"},
+		{"dockerfile outside", map[string]any{"build": map[string]any{"context": web, "dockerfile": "../../../data/secrets.key"}}, "build dockerfile"},
+		{"absolute dockerfile", map[string]any{"build": map[string]any{"context": web, "dockerfile": "/etc/passwd"}}, "build dockerfile"},
+		{"remote context", map[string]any{"build": map[string]any{"context": "https://github.com/acme/app.git#main", "dockerfile": "../Dockerfile"}}, ""},
+		{"additional context in the repo", map[string]any{"build": map[string]any{"context": web, "additional_contexts": map[string]any{
+			"shared": workspace, "base": "docker-image://alpine:3", "deps": "service:deps", "stage": "target:build",
+		}}}, ""},
+		{"additional context outside", map[string]any{"build": map[string]any{"context": web, "additional_contexts": map[string]any{"root": "/"}}}, "additional context root"},
+		{"additional context list", map[string]any{"build": map[string]any{"context": web, "additional_contexts": []any{"data=" + outside}}}, "additional context data"},
+		{"env file in the repo", map[string]any{"image": "nginx", "env_file": []any{map[string]any{"path": filepath.Join(web, ".env"), "required": false}}}, ""},
+		{"env file outside", map[string]any{"image": "nginx", "env_file": []any{map[string]any{"path": filepath.Join(outside, "secrets.key"), "required": true}}}, "env_file"},
+		{"env file string", map[string]any{"image": "nginx", "env_file": "/proc/self/environ"}, "env_file"},
+	} {
+		stack := map[string]any{"services": map[string]any{"web": tc.service}}
+		violations := stackPaths(stack, workspace, workspace)
+
+		if tc.refused == "" {
+			if len(violations) != 0 {
+				t.Errorf("%s: refused %v", tc.name, violations)
+			}
+			continue
+		}
+		if len(violations) != 1 || violations[0].Rule != ruleStackIsolation || !strings.Contains(violations[0].Message, tc.refused) {
+			t.Errorf("%s: got %v, want one violation about the %s", tc.name, violations, tc.refused)
+		}
+	}
+}
@@ -1,64 +1,95 @@
 package builder
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os/exec"
+	"strconv"
+	"worker/internal/config"
 	portman "worker/internal/portMan"
 )
 
-// StartContainer starts a Docker container using the given image and port.
-// It checks if the container is already running, and if not, starts it.
-// If the image exposes a port, it maps a random available host port to the container port.
-func StartContainer(deploymentID string, imageName string, containerPort *int) error {
-	// 1. Check if container is already running
-	isRunning, err := IsContainerRunning(imageName)
+// labels on every container the worker starts, they are how its containers are found again
+const (
+	LabelDeployment = "blacktree.deployment"
+	LabelRelease    = "blacktree.release" // release the container runs, missing when it runs no release of this worker
+	LabelWorker     = "blacktree.worker"  // WORKER_ID of the worker that started it
+)
+
+// StartContainer starts the container of a deployment with the given image, environment (KEY=value), limits and
+// port and returns its id. when the deployment already has a running container that one is returned instead.
+// If a container port is given, a random available host port is mapped to it.
+func StartContainer(deploymentID string, imageName string, release int, env []string, resources Resources, containerPort *int) (string, error) {
+	// 1. Check if the deployment already has a running container
+	containers, err := DeploymentContainers(deploymentID, true)
 	if err != nil {
 		log.Printf("❌ Error checking container status: %v", err)
-		return err
+		return "", err
+	}
+
+	for _, c := range containers {
+		if c.Running {
+			log.Printf("⚠️ Container %s of deployment %s is already running", c.Name, deploymentID)
+			return c.ID, nil
+		}
 	}
 
-	if isRunning {
-		log.Printf("⚠️ Container for image %s is already running", imageName)
-		return nil
+	// one that stopped on its own (crashed, killed for its memory use) still holds the name
+	for _, c := range containers {
+		if err := Current.Remove(context.Background(), c.ID); err != nil {
+			return "", fmt.Errorf("failed to remove stopped container %s: %w", c.Name, err)
+		}
+	}
+
+	limits, err := resources.resolve()
+	if err != nil {
+		return "", err
 	}
 
 	// 2. Assign port if needed
-	var runCmd *exec.Cmd
+	opt := RunOptions{
+		Name:   ContainerName(deploymentID),
+		Image:  imageName,
+		Env:    env,
+		Labels: deploymentLabels(deploymentID, release),
+		Limits: limits,
+	}
 	if containerPort != nil {
 		hostPort, err := portman.GetFreePort()
 		if err != nil {
 			log.Printf("❌ Failed to get free host port: %v", err)
-			return err
+			return "", err
 		}
 
 		log.Printf("🔌 Mapping host port %d to container port %d for %s", hostPort, *containerPort, imageName)
-
-		// docker run -d -p hostPort:containerPort imageName
-		runCmd = exec.Command(
-			"docker", "run", "-d",
-			"-p", fmt.Sprintf("%d:%d", hostPort, *containerPort),
-			"--name", fmt.Sprintf("blacktree-%s", deploymentID[:8]),
-			imageName,
-		)
+		opt.Ports = map[int]int{*containerPort: hostPort} // same as -p hostPort:containerPort
 	} else {
 		// If no port needs to be mapped
 		log.Printf("No Port to map")
-		runCmd = exec.Command(
-			"docker", "run", "-d",
-			"--name", fmt.Sprintf("blacktree-%s", deploymentID[:8]),
-			imageName,
-		)
 	}
 
-	// Capture Docker output for better error logging
-	output, err := runCmd.CombinedOutput()
+	id, err := Current.Run(context.Background(), opt)
 	if err != nil {
 		log.Printf("❌ Failed to start container for %s: %v", imageName, err)
-		log.Printf("🪵 Docker output:\n%s", string(output))
-		return err
+		return "", err
+	}
+
+	log.Printf("✅ Successfully started container %s for image %s", opt.Name, imageName)
+	return id, nil
+}
+
+func deploymentLabels(deploymentID string, release int) map[string]string {
+	labels := map[string]string{
+		LabelDeployment: deploymentID,
+		LabelWorker:     config.Current.WorkerID,
 	}
+	if release > 0 {
+		labels[LabelRelease] = strconv.Itoa(release)
+	}
+	return labels
+}
 
-	log.Printf("✅ Successfully started container for image %s", imageName)
-	return nil
+// ContainerName is the name of the container that runs a deployment
+func ContainerName(deploymentID string) string {
+	return fmt.Sprintf("blacktree-%s", deploymentID[:8])
 }
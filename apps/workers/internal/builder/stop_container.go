@@ -1,46 +1,71 @@
-// stop all the contiainers created from the given image name and remove them.
+// stops and removes the containers of a deployment. they are found by their deployment label, never by the image
+// they run: other containers may run the same image, and a retag or rollback changes the image of ours.
+
 package builder
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
-	"strings"
+	"sync"
+	"time"
 )
 
-// StopContainer stops all containers created from the given image name and removes them.
-func StopContainer(imageName string) error {
-	// Step 1: List all container IDs using the image
-	cmd := exec.Command("docker", "ps", "-a", "-q", "--filter", fmt.Sprintf("ancestor=%s", imageName))
-	output, err := cmd.Output()
+// stopTimeout is how long a container gets to shut down after SIGTERM before it is killed (same as the cli default)
+const stopTimeout = 10 * time.Second
+
+// containers the worker stopped itself, so the supervisor doesn't take their exit for a crash. the die event
+// comes in on its own time, entries are forgotten after a minute when it never does (the container was already
+// stopped).
+var stopping = struct {
+	sync.Mutex
+	ids map[string]time.Time
+}{ids: make(map[string]time.Time)}
+
+func markStopping(id string) {
+	stopping.Lock()
+	defer stopping.Unlock()
+
+	for other, at := range stopping.ids {
+		if time.Since(at) > time.Minute {
+			delete(stopping.ids, other)
+		}
+	}
+	stopping.ids[id] = time.Now()
+}
+
+// StoppedByWorker tells whether the worker stopped the container itself
+func StoppedByWorker(id string) bool {
+	stopping.Lock()
+	defer stopping.Unlock()
+
+	_, ok := stopping.ids[id]
+	return ok
+}
+
+// StopDeployment stops and removes every container of a deployment, running or not.
+// a deployment without a container is not an error.
+func StopDeployment(deploymentID string) error {
+	ctx := context.Background()
+
+	containers, err := DeploymentContainers(deploymentID, true)
 	if err != nil {
-		return fmt.Errorf("failed to list containers for image %s: %w", imageName, err)
+		return err
 	}
 
-	containers := strings.Fields(string(output))
 	if len(containers) == 0 {
-		fmt.Printf("🛑 No containers found for image %s\n", imageName)
+		fmt.Printf("🛑 No containers found for deployment %s\n", deploymentID)
 		return nil
 	}
 
-	fmt.Printf("🛑 Found %d container(s) for image %s. Stopping...\n", len(containers), imageName)
-
-	// Step 2: Stop each container
-	for _, id := range containers {
-		stopCmd := exec.Command("docker", "stop", id)
-		if err := stopCmd.Run(); err != nil {
-			fmt.Printf("⚠️ Failed to stop container %s: %v\n", id, err)
-			continue
+	for _, container := range containers {
+		markStopping(container.ID)
+		if err := Current.Stop(ctx, container.ID, stopTimeout); err != nil {
+			return fmt.Errorf("failed to stop container %s: %w", container.Name, err)
 		}
-
-		// Step 3: Remove container after stopping
-		rmCmd := exec.Command("docker", "rm", id)
-		if err := rmCmd.Run(); err != nil {
-			fmt.Printf("⚠️ Failed to remove container %s: %v\n", id, err)
-			continue
+		if err := Current.Remove(ctx, container.ID); err != nil {
+			return fmt.Errorf("failed to remove container %s: %w", container.Name, err)
 		}
-
-		fmt.Printf("✅ Stopped and removed container %s\n", id)
+		fmt.Printf("✅ Stopped and removed container %s\n", container.Name)
 	}
-
 	return nil
 }
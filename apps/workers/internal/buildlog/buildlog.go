@@ -0,0 +1,259 @@
+// every build writes its full output to data/logs/<deploymentId>/<buildId>.log, where the build id is the id of
+// its job. builds running side by side no longer interleave on stdout, and the logs of failed builds survive a
+// restart. a log is capped at WORKER_BUILD_LOG_MAX_KB: the start and the end are kept (errors are at the end)
+// and the middle is dropped. old logs are pruned by count per deployment and by age.
+
+package buildlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"worker/internal/config"
+)
+
+// Dir is where the logs live
+const Dir = "data/logs"
+
+var deploymentIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Entry describes one stored build log
+type Entry struct {
+	BuildID   int64     `json:"buildId"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Writer is the log of one running build. it is safe to write to from the build only, Close it when the build is done.
+type Writer struct {
+	file    *os.File
+	limit   int64 // 0 = unlimited
+	head    int64 // bytes already written to the file
+	tail    []byte
+	dropped int64
+	closed  bool
+}
+
+// Create starts the log of a build, replacing an older log with the same id, and prunes the oldest logs of the deployment
+func Create(deploymentID string, buildID int64) (*Writer, error) {
+	dir, err := deploymentDir(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log folder: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, fileName(buildID)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build log: %w", err)
+	}
+
+	if err := prune(deploymentID, config.Current.BuildLogKeep); err != nil {
+		fmt.Printf("⚠️ Failed to prune build logs of %s: %v\n", deploymentID, err)
+	}
+
+	return &Writer{file: file, limit: config.Current.BuildLogMaxBytes}, nil
+}
+
+// Write keeps the first half of the limit in the file and the last half in memory until Close
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	n := len(p)
+	if w.limit <= 0 {
+		return w.file.Write(p)
+	}
+
+	if room := w.limit/2 - w.head; room > 0 {
+		chunk := p
+		if int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		written, err := w.file.Write(chunk)
+		w.head += int64(written)
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+
+	// everything after the head goes through the tail, which only keeps the last limit/2 bytes
+	w.tail = append(w.tail, p...)
+	if max := w.limit - w.limit/2; int64(len(w.tail)) > max {
+		cut := int64(len(w.tail)) - max
+		w.dropped += cut
+		w.tail = append(w.tail[:0], w.tail[cut:]...)
+	}
+	return n, nil
+}
+
+// Close writes the kept tail and closes the file
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.dropped > 0 {
+		fmt.Fprintf(w.file, "\n... %d bytes of build output truncated (WORKER_BUILD_LOG_MAX_KB) ...\n", w.dropped)
+	}
+	if len(w.tail) > 0 {
+		w.file.Write(w.tail)
+	}
+	return w.file.Close()
+}
+
+// List returns the stored logs of a deployment, newest build first
+func List(deploymentID string) ([]Entry, error) {
+	dir, err := deploymentDir(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		id, ok := buildID(file.Name())
+		if !ok {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{BuildID: id, Size: info.Size(), UpdatedAt: info.ModTime()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].BuildID > entries[j].BuildID })
+	return entries, nil
+}
+
+// Open opens the log of one build, buildID 0 means the newest one. it returns the build id that was opened.
+func Open(deploymentID string, buildID int64) (io.ReadCloser, int64, error) {
+	dir, err := deploymentDir(deploymentID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if buildID == 0 {
+		entries, err := List(deploymentID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(entries) == 0 {
+			return nil, 0, os.ErrNotExist
+		}
+		buildID = entries[0].BuildID
+	}
+
+	file, err := os.Open(filepath.Join(dir, fileName(buildID)))
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, buildID, nil
+}
+
+// Remove deletes every log of a deployment
+func Remove(deploymentID string) error {
+	dir, err := deploymentDir(deploymentID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// PruneOld deletes logs older than maxAge and folders of deployments that have no logs left. it returns how many logs were removed.
+func PruneOld(maxAge time.Duration) (int, error) {
+	deployments, err := os.ReadDir(Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, deployment := range deployments {
+		if !deployment.IsDir() {
+			continue
+		}
+
+		entries, err := List(deployment.Name())
+		if err != nil {
+			continue
+		}
+
+		kept := len(entries)
+		for _, entry := range entries {
+			if time.Since(entry.UpdatedAt) < maxAge {
+				continue
+			}
+			if err := os.Remove(filepath.Join(Dir, deployment.Name(), fileName(entry.BuildID))); err == nil {
+				removed++
+				kept--
+			}
+		}
+
+		if kept == 0 {
+			os.Remove(filepath.Join(Dir, deployment.Name())) // only succeeds when it is empty
+		}
+	}
+	return removed, nil
+}
+
+// prune keeps the newest keep logs of a deployment (0 keeps all)
+func prune(deploymentID string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	entries, err := List(deploymentID)
+	if err != nil || len(entries) <= keep {
+		return err
+	}
+
+	dir, _ := deploymentDir(deploymentID)
+	for _, entry := range entries[keep:] {
+		if err := os.Remove(filepath.Join(dir, fileName(entry.BuildID))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deploymentDir refuses ids that could point outside of the logs folder, they come from messages
+func deploymentDir(deploymentID string) (string, error) {
+	if !deploymentIDPattern.MatchString(deploymentID) {
+		return "", fmt.Errorf("invalid deployment id %q", deploymentID)
+	}
+	return filepath.Join(Dir, deploymentID), nil
+}
+
+func fileName(buildID int64) string {
+	return strconv.FormatInt(buildID, 10) + ".log"
+}
+
+func buildID(name string) (int64, bool) {
+	if !strings.HasSuffix(name, ".log") {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(name, ".log"), 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,216 @@
+// worker wide configuration. everything is read from the environment once at startup so that
+// the limits can be changed per host without rebuilding the worker.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	WorkerID           string        // identifies this worker process, e.g. as the owner of job leases
+	BuildLease         time.Duration // how long a claimed build job belongs to this worker without being renewed, renewed every third of it
+	BuildSweepInterval time.Duration // how often the builder looks for jobs it was not told about (recovery only)
+	BuildTimeout       time.Duration // how long one build may run before it is killed (0 = no limit)
+	BuildMemory        int64         // memory limit of a build in bytes (0 = unlimited)
+	BuildCPUs          float64       // how many cpus a build may use (0 = unlimited)
+	BuildLogMaxBytes   int64         // max size of one stored build log, the middle is dropped (0 = unlimited)
+	BuildLogKeep       int           // how many build logs are kept per deployment (0 = all)
+	BuildLogMaxAge     time.Duration // build logs older than this are removed by the janitor (0 = never)
+	ReleasesKeep       int           // how many release images are kept per deployment for rollbacks (0 = all)
+	AdminAddr          string        // listen address of the admin http server, "off" disables it
+	CloneTimeout       time.Duration // how long a single git clone may run before it is killed
+	MaxCheckoutBytes   int64         // max size of a single checkout on disk (0 = unlimited)
+	WorkspaceQuota     int64         // max bytes all workspaces of one deployment may use together (0 = unlimited)
+	SizeCheckInterval  time.Duration // how often the checkout size is measured while cloning
+	LocalSourceRoots   []string      // folders on the host that archive paths and local sources may come from
+	GCInterval         time.Duration // how often the workspace janitor runs (0 = never)
+	GCMaxAge           time.Duration // workspaces younger than this are never touched by the janitor
+	GCDryRun           bool          // only log what the janitor would delete
+	Runtime            string        // container engine, docker or podman
+	DockerSocket       string        // unix socket of the docker daemon
+	PodmanSocket       string        // unix socket of the podman api service
+	DockerAPIVersion   string        // engine api version used in request paths, empty for the daemon's own
+	ComposeCommand     []string      // cli that runs compose stacks, "<runtime> compose" when empty
+	PolicyRegistries   []string      // registries base images may come from, empty allows all
+	PolicyNonRoot      bool          // built images have to set a USER that is not root
+	PolicyNoRemoteAdd  bool          // Dockerfiles may not ADD urls or git repos
+	PolicyMaxLayers    int           // layers a Dockerfile may add to its base image (0 = unlimited)
+	SecretScan         string        // what possible secrets in the build context do: off, warn or block
+	Registry           string        // registry (host[:port][/namespace]) built images are pushed to, empty = no push
+	RegistryUsername   string
+	RegistryPassword   string
+	SecretsKey         string        // base64 AES-256 key the secrets of deployments are encrypted with, data/secrets.key when empty
+	ContainerCPUs      float64       // cpus of a deployment's container when it asks for none (0 = unlimited)
+	ContainerMemory    int64         // memory of a container in bytes when it asks for none (0 = unlimited)
+	ContainerPids      int64         // processes a container may have when it asks for none (0 = unlimited)
+	ContainerUlimits   []string      // name=soft[:hard], the hard limits are also the most a deployment may ask for
+	MaxContainerCPUs   float64       // most cpus a deployment may ask for (0 = no maximum)
+	MaxContainerMemory int64         // most memory in bytes a deployment may ask for (0 = no maximum)
+	MaxContainerSwap   int64         // most swap in bytes on top of the memory (0 = no maximum)
+	MaxContainerPids   int64         // most processes a deployment may ask for (0 = no maximum)
+	RestartBackoff     time.Duration // wait before the first restart of a crashed container, doubled for every further crash
+	RestartBackoffMax  time.Duration // longest wait between restarts
+	CrashLoopRestarts  int           // this many crashes within CrashLoopWindow stop the restarts
+	CrashLoopWindow    time.Duration // crashes older than this are forgotten, and with them the backoff
+	CrashLogLines      int           // lines of a crashed container's output sent with its status
+}
+
+// Current holds the config loaded from the environment when the package is initialized
+var Current = Load()
+
+// Load reads the config from the environment and falls back to defaults
+func Load() Config {
+	return Config{
+		WorkerID:           envString("WORKER_ID", defaultWorkerID()),
+		BuildLease:         envDuration("WORKER_BUILD_LEASE", 2*time.Minute),
+		BuildSweepInterval: envDuration("WORKER_BUILD_SWEEP_INTERVAL", time.Minute),
+		BuildTimeout:       envDuration("WORKER_BUILD_TIMEOUT", 30*time.Minute),
+		BuildMemory:        envInt64("WORKER_BUILD_MEMORY_MB", 0) * 1024 * 1024,
+		BuildCPUs:          envFloat("WORKER_BUILD_CPUS", 0),
+		BuildLogMaxBytes:   envInt64("WORKER_BUILD_LOG_MAX_KB", 5120) * 1024,
+		BuildLogKeep:       int(envInt64("WORKER_BUILD_LOG_KEEP", 10)),
+		BuildLogMaxAge:     envDuration("WORKER_BUILD_LOG_MAX_AGE", 30*24*time.Hour),
+		ReleasesKeep:       int(envInt64("WORKER_RELEASES_KEEP", 5)),
+		AdminAddr:          envString("WORKER_ADMIN_ADDR", "127.0.0.1:9091"),
+		CloneTimeout:       envDuration("WORKER_CLONE_TIMEOUT", 5*time.Minute),
+		MaxCheckoutBytes:   envInt64("WORKER_MAX_CHECKOUT_MB", 1024) * 1024 * 1024,
+		WorkspaceQuota:     envInt64("WORKER_WORKSPACE_QUOTA_MB", 2048) * 1024 * 1024,
+		SizeCheckInterval:  envDuration("WORKER_SIZE_CHECK_INTERVAL", 2*time.Second),
+		LocalSourceRoots:   envList("WORKER_LOCAL_SOURCE_ROOTS"),
+		GCInterval:         envDuration("WORKER_GC_INTERVAL", 10*time.Minute),
+		GCMaxAge:           envDuration("WORKER_GC_MAX_AGE", time.Hour),
+		GCDryRun:           envBool("WORKER_GC_DRY_RUN", false),
+		Runtime:            envString("WORKER_RUNTIME", "docker"),
+		DockerSocket:       envString("WORKER_DOCKER_SOCKET", defaultDockerSocket()),
+		PodmanSocket:       envString("WORKER_PODMAN_SOCKET", defaultPodmanSocket()),
+		DockerAPIVersion:   envString("WORKER_DOCKER_API_VERSION", "1.41"),
+		ComposeCommand:     strings.Fields(os.Getenv("WORKER_COMPOSE_COMMAND")),
+		PolicyRegistries:   envList("WORKER_POLICY_ALLOWED_REGISTRIES"),
+		PolicyNonRoot:      envBool("WORKER_POLICY_REQUIRE_NON_ROOT", false),
+		PolicyNoRemoteAdd:  envBool("WORKER_POLICY_NO_REMOTE_ADD", true),
+		PolicyMaxLayers:    int(envInt64("WORKER_POLICY_MAX_LAYERS", 0)),
+		SecretScan:         envString("WORKER_SECRET_SCAN", "warn"),
+		Registry:           strings.TrimSuffix(os.Getenv("WORKER_REGISTRY"), "/"),
+		RegistryUsername:   os.Getenv("WORKER_REGISTRY_USERNAME"),
+		RegistryPassword:   os.Getenv("WORKER_REGISTRY_PASSWORD"),
+		SecretsKey:         os.Getenv("WORKER_SECRETS_KEY"),
+		ContainerCPUs:      envFloat("WORKER_CONTAINER_CPUS", 1),
+		ContainerMemory:    envInt64("WORKER_CONTAINER_MEMORY_MB", 512) * 1024 * 1024,
+		ContainerPids:      envInt64("WORKER_CONTAINER_PIDS", 512),
+		ContainerUlimits:   envList("WORKER_CONTAINER_ULIMITS"),
+		MaxContainerCPUs:   envFloat("WORKER_CONTAINER_MAX_CPUS", 0),
+		MaxContainerMemory: envInt64("WORKER_CONTAINER_MAX_MEMORY_MB", 0) * 1024 * 1024,
+		MaxContainerSwap:   envInt64("WORKER_CONTAINER_MAX_SWAP_MB", 0) * 1024 * 1024,
+		MaxContainerPids:   envInt64("WORKER_CONTAINER_MAX_PIDS", 0),
+		RestartBackoff:     envDuration("WORKER_RESTART_BACKOFF", 2*time.Second),
+		RestartBackoffMax:  envDuration("WORKER_RESTART_BACKOFF_MAX", 5*time.Minute),
+		CrashLoopRestarts:  int(envInt64("WORKER_CRASH_LOOP_RESTARTS", 5)),
+		CrashLoopWindow:    envDuration("WORKER_CRASH_LOOP_WINDOW", 10*time.Minute),
+		CrashLogLines:      int(envInt64("WORKER_CRASH_LOG_LINES", 50)),
+	}
+}
+
+// defaultDockerSocket follows DOCKER_HOST when it points at a unix socket, like the cli does
+func defaultDockerSocket() string {
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return "/var/run/docker.sock"
+}
+
+// defaultPodmanSocket is the rootless socket of the user running the worker, or the system one for root
+func defaultPodmanSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Getuid() != 0 {
+		return dir + "/podman/podman.sock"
+	}
+	return "/run/podman/podman.sock"
+}
+
+func envString(key string, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
+// defaultWorkerID is <hostname>-<pid>, unique enough for several workers sharing a host
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("⚠️ Invalid duration for %s: %s, using %s", key, val, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envInt64(key string, fallback int64) int64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		log.Printf("⚠️ Invalid number for %s: %s, using %d", key, val, fallback)
+		return fallback
+	}
+	return i
+}
+
+func envFloat(key string, fallback float64) float64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f < 0 {
+		log.Printf("⚠️ Invalid number for %s: %s, using %g", key, val, fallback)
+		return fallback
+	}
+	return f
+}
+
+func envBool(key string, fallback bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Printf("⚠️ Invalid bool for %s: %s, using %t", key, val, fallback)
+		return fallback
+	}
+	return b
+}
+
+// envList reads a comma separated list, empty items are dropped
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,257 @@
+// detect looks at a workspace without a Dockerfile and writes one for it. the files at the root of the build
+// context decide the template: go.mod (go), package.json (node), requirements.txt or pyproject.toml (python),
+// index.html (static site served by an unprivileged nginx). the first one that matches wins, in that order.
+
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrUnknownStack is returned when nothing in the context matches a template
+var ErrUnknownStack = errors.New("no Dockerfile and none of go.mod, package.json, requirements.txt, pyproject.toml or index.html found")
+
+// ErrNoStartCommand is returned when the stack is known but there is no way to tell how the app is started
+var ErrNoStartCommand = errors.New("can't tell how to start the app, set startCommand")
+
+// Options change what the templates generate
+type Options struct {
+	Port         int    // port the app listens on, 0 for the template's default
+	StartCommand string // shell command that replaces the one the template picks
+}
+
+// Result is a generated Dockerfile
+type Result struct {
+	Template   string // go, node, python or static
+	Detail     string // what else was detected, e.g. the package manager
+	Port       int
+	Dockerfile string
+}
+
+type detector struct {
+	name     string
+	port     int // default port of the stack
+	matches  func(dir string) bool
+	generate func(dir string, data *templateData) error
+}
+
+var detectors = []detector{
+	{"go", 8080, hasFile("go.mod"), generateGo},
+	{"node", 3000, hasFile("package.json"), generateNode},
+	{"python", 8000, hasFile("requirements.txt", "pyproject.toml"), generatePython},
+	{"static", 8080, hasFile("index.html"), generateStatic},
+}
+
+// Generate picks the template for the build context dir and returns the Dockerfile it generates
+func Generate(dir string, opt Options) (*Result, error) {
+	for _, d := range detectors {
+		if !d.matches(dir) {
+			continue
+		}
+
+		data := &templateData{Template: d.name, Port: d.port}
+		if opt.Port > 0 {
+			data.Port = opt.Port
+		}
+
+		if err := d.generate(dir, data); err != nil {
+			return nil, fmt.Errorf("%s template: %w", d.name, err)
+		}
+
+		if opt.StartCommand != "" {
+			data.Cmd = shellCommand(opt.StartCommand)
+		}
+		if data.Cmd == "" {
+			return nil, fmt.Errorf("%s template: %w", d.name, ErrNoStartCommand)
+		}
+
+		dockerfile, err := render(d.name, data)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Template: d.name, Detail: data.Detail, Port: data.Port, Dockerfile: dockerfile}, nil
+	}
+	return nil, ErrUnknownStack
+}
+
+// Write generates the Dockerfile into path, and a .dockerignore into the context when it has none
+func Write(dir, path string, opt Options) (*Result, error) {
+	result, err := Generate(dir, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, []byte(result.Dockerfile), 0644); err != nil {
+		return nil, err
+	}
+
+	ignore := filepath.Join(dir, ".dockerignore")
+	if _, err := os.Stat(ignore); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(ignore, []byte(dockerignore), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// keeps the repo history, dependencies installed on the host and local env files out of the image
+const dockerignore = `.git
+node_modules
+__pycache__
+*.pyc
+.venv
+.env
+`
+
+var goVersionLine = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+)`)
+
+func generateGo(dir string, data *templateData) error {
+	data.Version = "1.22"
+	if mod, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		if m := goVersionLine.FindSubmatch(mod); m != nil {
+			data.Version = string(m[1])
+		}
+	}
+
+	// a main package at the root, otherwise the only one under cmd/
+	data.Package = "."
+	if !exists(filepath.Join(dir, "main.go")) {
+		if mains, _ := filepath.Glob(filepath.Join(dir, "cmd", "*", "main.go")); len(mains) == 1 {
+			data.Package = "./" + filepath.ToSlash(filepath.Dir(mustRel(dir, mains[0])))
+		}
+	}
+	data.Detail = "package " + data.Package
+	data.HasGoSum = exists(filepath.Join(dir, "go.sum"))
+	data.Cmd = exec("/app")
+	return nil
+}
+
+type packageJSON struct {
+	Main    string            `json:"main"`
+	Scripts map[string]string `json:"scripts"`
+	Engines struct {
+		Node string `json:"node"`
+	} `json:"engines"`
+}
+
+var majorVersion = regexp.MustCompile(`\d+`)
+
+func generateNode(dir string, data *templateData) error {
+	raw, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return err
+	}
+	var pkg packageJSON
+	if err := json.Unmarshal(raw, &pkg); err != nil {
+		return fmt.Errorf("invalid package.json: %w", err)
+	}
+
+	data.Version = "20"
+	if m := majorVersion.FindString(pkg.Engines.Node); m != "" {
+		data.Version = m
+	}
+
+	// the lockfile tells which package manager the repo uses, the install has to follow it exactly
+	switch {
+	case exists(filepath.Join(dir, "pnpm-lock.yaml")):
+		data.PackageManager, data.Install = "pnpm", "corepack enable && pnpm install --frozen-lockfile"
+	case exists(filepath.Join(dir, "yarn.lock")):
+		data.PackageManager, data.Install = "yarn", "corepack enable && yarn install --frozen-lockfile"
+	case exists(filepath.Join(dir, "package-lock.json")):
+		data.PackageManager, data.Install = "npm", "npm ci"
+	default:
+		data.PackageManager, data.Install = "npm", "npm install"
+	}
+	data.Detail = data.PackageManager
+
+	if _, ok := pkg.Scripts["build"]; ok {
+		data.Build = data.PackageManager + " run build"
+	}
+
+	switch {
+	case pkg.Scripts["start"] != "":
+		data.Cmd = exec(data.PackageManager, "start")
+	case pkg.Main != "" && exists(filepath.Join(dir, pkg.Main)):
+		data.Cmd = exec("node", pkg.Main)
+	case exists(filepath.Join(dir, "server.js")):
+		data.Cmd = exec("node", "server.js")
+	case exists(filepath.Join(dir, "index.js")):
+		data.Cmd = exec("node", "index.js")
+	}
+	return nil
+}
+
+var pythonVersion = regexp.MustCompile(`^\d+\.\d+`)
+
+func generatePython(dir string, data *templateData) error {
+	data.Version = "3.12"
+	if raw, err := os.ReadFile(filepath.Join(dir, ".python-version")); err == nil {
+		if m := pythonVersion.FindString(strings.TrimSpace(string(raw))); m != "" {
+			data.Version = m
+		}
+	}
+
+	if exists(filepath.Join(dir, "requirements.txt")) {
+		data.Detail = "requirements.txt"
+		data.Install = "pip install --no-cache-dir -r requirements.txt"
+	} else {
+		data.Detail = "pyproject.toml"
+		data.Install = "pip install --no-cache-dir ."
+	}
+
+	switch {
+	case exists(filepath.Join(dir, "manage.py")):
+		data.Cmd = exec("python", "manage.py", "runserver", fmt.Sprintf("0.0.0.0:%d", data.Port))
+	case exists(filepath.Join(dir, "main.py")):
+		data.Cmd = exec("python", "main.py")
+	case exists(filepath.Join(dir, "app.py")):
+		data.Cmd = exec("python", "app.py")
+	}
+	return nil
+}
+
+func generateStatic(dir string, data *templateData) error {
+	data.Cmd = exec("nginx", "-g", "daemon off;")
+	return nil
+}
+
+func hasFile(names ...string) func(dir string) bool {
+	return func(dir string) bool {
+		for _, name := range names {
+			if exists(filepath.Join(dir, name)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func exists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func mustRel(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// exec is the exec form of CMD, ["a", "b"]
+func exec(args ...string) string {
+	data, _ := json.Marshal(args)
+	return string(data)
+}
+
+// shellCommand runs a user supplied command through sh, so it may use pipes, && and $VARS
+func shellCommand(command string) string {
+	return exec("sh", "-c", command)
+}
@@ -0,0 +1,114 @@
+// the Dockerfile templates. every one of them builds in a full image and copies only what is needed to run into a
+// small one, so compilers and dev dependencies don't end up in the deployed image.
+
+package detect
+
+import (
+	"strings"
+	"text/template"
+)
+
+type templateData struct {
+	Template       string
+	Detail         string
+	Port           int
+	Version        string // go, node or python version of the base images
+	Package        string // go: main package to build
+	HasGoSum       bool
+	PackageManager string // node: npm, yarn or pnpm
+	Install        string // command that installs the dependencies
+	Build          string // node: build script, empty when there is none
+	Cmd            string // exec form of CMD
+}
+
+const header = `# generated by blacktree from the {{.Template}} template{{if .Detail}} ({{.Detail}}){{end}}.
+# add a Dockerfile to the repository to build it your own way.
+`
+
+var templates = map[string]string{
+	"go": header + `
+FROM golang:{{.Version}}-alpine AS build
+WORKDIR /src
+COPY go.mod {{if .HasGoSum}}go.sum {{end}}./
+RUN go mod download
+COPY . .
+RUN CGO_ENABLED=0 go build -trimpath -ldflags="-s -w" -o /out/app {{.Package}}
+
+FROM alpine:3.20
+RUN apk add --no-cache ca-certificates tzdata && adduser -D -H app
+COPY --from=build /out/app /app
+USER app
+ENV PORT={{.Port}}
+EXPOSE {{.Port}}
+CMD {{.Cmd}}
+`,
+
+	"node": header + `
+FROM node:{{.Version}}-alpine AS build
+WORKDIR /app
+COPY . .
+RUN {{.Install}}
+{{- if .Build}}
+RUN {{.Build}}
+{{- end}}
+{{- if eq .PackageManager "npm"}}
+RUN npm prune --omit=dev
+{{- end}}
+
+FROM node:{{.Version}}-alpine
+WORKDIR /app
+ENV NODE_ENV=production
+{{- if eq .PackageManager "npm"}}
+RUN npm config set update-notifier false
+{{- else}}
+RUN corepack enable
+{{- end}}
+COPY --from=build --chown=node:node /app /app
+USER node
+ENV PORT={{.Port}}
+EXPOSE {{.Port}}
+CMD {{.Cmd}}
+`,
+
+	"python": header + `
+FROM python:{{.Version}}-slim AS build
+WORKDIR /app
+RUN python -m venv /opt/venv
+ENV PATH=/opt/venv/bin:$PATH
+COPY . .
+RUN {{.Install}}
+
+FROM python:{{.Version}}-slim
+WORKDIR /app
+ENV PATH=/opt/venv/bin:$PATH PYTHONUNBUFFERED=1
+RUN useradd --no-create-home app
+COPY --from=build /opt/venv /opt/venv
+COPY --from=build --chown=app:app /app /app
+USER app
+ENV PORT={{.Port}}
+EXPOSE {{.Port}}
+CMD {{.Cmd}}
+`,
+
+	"static": header + `
+FROM nginxinc/nginx-unprivileged:alpine
+RUN sed -i -E 's/listen(\s+\[::\]:|\s+)8080;/listen\1{{.Port}};/' /etc/nginx/conf.d/default.conf
+COPY . /usr/share/nginx/html
+USER nginx
+EXPOSE {{.Port}}
+CMD {{.Cmd}}
+`,
+}
+
+func render(name string, data *templateData) (string, error) {
+	tmpl, err := template.New(name).Parse(templates[name])
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
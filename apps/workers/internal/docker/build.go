@@ -0,0 +1,246 @@
+// POST /build. the context folder is sent as a tar in the request body and the daemon answers with
+// a progress stream. the image id comes back as aux data at the end of the stream.
+
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type BuildOptions struct {
+	Tags       []string // e.g. "blacktree/app-1234abcd"
+	ContextDir string   // folder sent to the daemon
+	Dockerfile string   // path of the Dockerfile on disk, inside or outside the context. empty means <context>/Dockerfile
+	NoCache    bool
+	Pull       bool              // always pull a newer base image
+	Labels     map[string]string // labels on the built image
+	BuildArgs  map[string]string // --build-arg
+	Target     string            // --target stage
+	Secrets    []string          // podman only: "id=<id>,src=<file on the engine host>". docker gets them from the Session
+	BuildKit   bool              // docker: build with the engine's buildkit (RUN --mount)
+	Session    string            // docker with BuildKit: id of the session (StartSession) buildkit asks for the secrets
+	CacheFrom  []string          // images whose layers may be reused, e.g. the previous build of the same deployment
+	Memory     int64             // memory limit in bytes for the build containers, 0 = unlimited
+	CPUQuota   int64             // microseconds of cpu time per CPUPeriod, 0 = unlimited
+	CPUPeriod  int64             // microseconds, defaults to 100000 when CPUQuota is set
+}
+
+// BuildImage builds the context and returns the id of the new image. the build output is written to out.
+func (c *Client) BuildImage(ctx context.Context, opt BuildOptions, out io.Writer) (string, error) {
+	dockerfile := opt.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(opt.ContextDir, "Dockerfile")
+	}
+
+	// the tar is written while the request is being sent, so big contexts are never held in memory
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, opt.ContextDir, dockerfile))
+	}()
+
+	query := url.Values{}
+	for _, tag := range opt.Tags {
+		query.Add("t", tag)
+	}
+	query.Set("dockerfile", dockerfileInContext(opt.ContextDir, dockerfile))
+	query.Set("rm", "1")
+	query.Set("forcerm", "1") // also remove intermediate containers of a failed build
+	if opt.NoCache {
+		query.Set("nocache", "1")
+	}
+	if opt.Pull {
+		query.Set("pull", "1")
+	}
+	if len(opt.Labels) > 0 {
+		data, _ := json.Marshal(opt.Labels)
+		query.Set("labels", string(data))
+	}
+	if len(opt.BuildArgs) > 0 {
+		data, _ := json.Marshal(opt.BuildArgs)
+		query.Set("buildargs", string(data))
+	}
+	if opt.Target != "" {
+		query.Set("target", opt.Target)
+	}
+	if len(opt.Secrets) > 0 {
+		data, _ := json.Marshal(opt.Secrets)
+		query.Set("secrets", string(data))
+	}
+	if opt.BuildKit {
+		query.Set("version", "2")
+	}
+	if opt.Session != "" {
+		query.Set("session", opt.Session)
+	}
+	if len(opt.CacheFrom) > 0 {
+		data, _ := json.Marshal(opt.CacheFrom)
+		query.Set("cachefrom", string(data))
+	}
+	if opt.Memory > 0 {
+		query.Set("memory", strconv.FormatInt(opt.Memory, 10))
+		query.Set("memswap", strconv.FormatInt(opt.Memory, 10)) // same as memory means no swap on top
+	}
+	if opt.CPUQuota > 0 {
+		period := opt.CPUPeriod
+		if period == 0 {
+			period = 100000
+		}
+		query.Set("cpuperiod", strconv.FormatInt(period, 10))
+		query.Set("cpuquota", strconv.FormatInt(opt.CPUQuota, 10))
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-tar")
+
+	resp, err := c.do(ctx, http.MethodPost, "/build", query, pr, header)
+	if err != nil {
+		pr.CloseWithError(err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var imageID string
+	var traceErr error
+	trace := newTraceWriter(out)
+	err = readStream("build", resp.Body, out, func(msg Message) {
+		if msg.ID == buildkitTraceID {
+			if err := trace.write(msg.Aux); err != nil && traceErr == nil {
+				traceErr = err // the build goes on, only its progress can't be shown
+			}
+			return
+		}
+
+		var aux struct {
+			ID string `json:"ID"`
+		}
+		if len(msg.Aux) > 0 && json.Unmarshal(msg.Aux, &aux) == nil && aux.ID != "" {
+			imageID = aux.ID // moby.image.id with buildkit
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if traceErr != nil && out != nil {
+		fmt.Fprintf(out, "⚠️ %v\n", traceErr)
+	}
+
+	return imageID, nil
+}
+
+// outsideDockerfile is the name a Dockerfile from outside the context gets inside the tar
+const outsideDockerfile = ".blacktree.Dockerfile"
+
+// dockerfileInContext is the path of the Dockerfile inside the tar
+func dockerfileInContext(contextDir, dockerfile string) string {
+	rel, err := filepath.Rel(contextDir, dockerfile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return outsideDockerfile
+	}
+	return filepath.ToSlash(rel)
+}
+
+// writeContext tars the context folder, leaving out what .dockerignore excludes and .git folders: a clone's history
+// and remote have no business in an image. the Dockerfile is always sent, a Dockerfile from outside the context is
+// added under outsideDockerfile.
+func writeContext(w io.Writer, contextDir, dockerfile string) error {
+	ignore, err := ReadIgnore(contextDir)
+	if err != nil {
+		return fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+
+	dockerfileName := dockerfileInContext(contextDir, dockerfile)
+	tw := tar.NewWriter(w)
+
+	err = filepath.WalkDir(contextDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(contextDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if rel != dockerfileName && ignore.Match(rel) {
+			if d.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		return addFile(tw, p, rel, d)
+	})
+	if err != nil {
+		return err
+	}
+
+	if dockerfileName == outsideDockerfile {
+		info, err := os.Stat(dockerfile)
+		if err != nil {
+			return fmt.Errorf("failed to read Dockerfile: %w", err)
+		}
+		if err := addFile(tw, dockerfile, outsideDockerfile, fs.FileInfoToDirEntry(info)); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func addFile(tw *tar.Writer, p, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil // sockets and the like can't go into a tar, docker skips them too
+	}
+	header.Name = name
+	if d.IsDir() {
+		header.Name += "/"
+	}
+	// files in the image belong to root like with the cli, not to whoever runs the worker
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,231 @@
+// builds with version=2 run in the engine's buildkit. its progress doesn't come as stream lines but as
+// moby.buildkit.trace messages: base64 of a protobuf StatusResponse (github.com/moby/buildkit/api/services/control).
+// only the few fields needed to print the progress the way `buildx --progress plain` does are decoded here, by hand,
+// so the worker doesn't pull in protobuf and grpc for them.
+
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// id of the stream messages that carry buildkit progress
+const buildkitTraceID = "moby.buildkit.trace"
+
+// vertex is a step of the build as the progress stream reports it
+type vertex struct {
+	digest    string
+	name      string
+	cached    bool
+	completed bool
+	err       string
+}
+
+type vertexLog struct {
+	digest string
+	data   []byte
+}
+
+// traceWriter prints the buildkit progress, every step once with the number it was first seen under:
+// #5 [2/4] RUN npm ci, its output lines, then #5 CACHED, #5 DONE or #5 ERROR: ...
+type traceWriter struct {
+	out     io.Writer
+	numbers map[string]int
+	printed map[string]*vertex // what was already printed of each step
+}
+
+func newTraceWriter(out io.Writer) *traceWriter {
+	return &traceWriter{out: out, numbers: make(map[string]int), printed: make(map[string]*vertex)}
+}
+
+// write prints one moby.buildkit.trace message, aux is the json string of the base64 protobuf
+func (t *traceWriter) write(aux json.RawMessage) error {
+	var encoded string
+	if err := json.Unmarshal(aux, &encoded); err != nil {
+		return fmt.Errorf("invalid buildkit trace: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid buildkit trace: %w", err)
+	}
+
+	vertexes, logs, err := decodeStatus(data)
+	if err != nil {
+		return err
+	}
+	if t.out == nil {
+		return nil
+	}
+
+	for _, v := range vertexes {
+		t.vertex(v)
+	}
+	for _, l := range logs {
+		number := t.number(l.digest)
+		for _, line := range strings.SplitAfter(string(l.data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			fmt.Fprintf(t.out, "#%d %s", number, strings.TrimRight(line, "\n")+"\n")
+		}
+	}
+	return nil
+}
+
+func (t *traceWriter) number(digest string) int {
+	n, ok := t.numbers[digest]
+	if !ok {
+		n = len(t.numbers) + 1
+		t.numbers[digest] = n
+	}
+	return n
+}
+
+func (t *traceWriter) vertex(v vertex) {
+	n := t.number(v.digest)
+	seen, ok := t.printed[v.digest]
+	if !ok {
+		seen = &vertex{}
+		t.printed[v.digest] = seen
+	}
+
+	if seen.name == "" && v.name != "" {
+		seen.name = v.name
+		fmt.Fprintf(t.out, "#%d %s\n", n, v.name)
+	}
+	switch {
+	case v.cached && !seen.cached:
+		seen.cached = true
+		fmt.Fprintf(t.out, "#%d CACHED\n", n)
+	case v.err != "" && seen.err == "":
+		seen.err = v.err
+		fmt.Fprintf(t.out, "#%d ERROR: %s\n", n, v.err)
+	case v.completed && !seen.completed && !seen.cached && seen.err == "":
+		seen.completed = true
+		fmt.Fprintf(t.out, "#%d DONE\n", n)
+	}
+}
+
+// decodeStatus reads the vertexes (field 1) and logs (field 3) of a StatusResponse
+func decodeStatus(data []byte) ([]vertex, []vertexLog, error) {
+	var vertexes []vertex
+	var logs []vertexLog
+
+	err := protoFields(data, func(num int, value protoValue) error {
+		switch num {
+		case 1:
+			var v vertex
+			err := protoFields(value.bytes, func(num int, value protoValue) error {
+				switch num {
+				case 1:
+					v.digest = string(value.bytes)
+				case 3:
+					v.name = string(value.bytes)
+				case 4:
+					v.cached = value.varint != 0
+				case 6:
+					v.completed = true // a timestamp, only whether it is set matters
+				case 7:
+					v.err = string(value.bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			vertexes = append(vertexes, v)
+		case 3:
+			var l vertexLog
+			err := protoFields(value.bytes, func(num int, value protoValue) error {
+				switch num {
+				case 1:
+					l.digest = string(value.bytes)
+				case 4:
+					l.data = value.bytes
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			logs = append(logs, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid buildkit trace: %w", err)
+	}
+	return vertexes, logs, nil
+}
+
+// protoValue is a field of a protobuf message, varint for varint fields and bytes for length delimited ones
+type protoValue struct {
+	varint uint64
+	bytes  []byte
+}
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoFields calls fn for every field of the message. fixed size fields are skipped, nothing here needs them.
+func protoFields(data []byte, fn func(num int, value protoValue) error) error {
+	for len(data) > 0 {
+		key, n := protoVarint(data)
+		if n == 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+
+		var value protoValue
+		switch key & 7 {
+		case 0: // varint
+			value.varint, n = protoVarint(data)
+			if n == 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case 1: // 64 bit
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			data = data[8:]
+			continue
+		case 2: // length delimited
+			length, n := protoVarint(data)
+			if n == 0 || uint64(len(data)-n) < length {
+				return errProtoTruncated
+			}
+			value.bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case 5: // 32 bit
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			data = data[4:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+
+		if err := fn(int(key>>3), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoVarint decodes a varint and returns it with its length, 0 when data ends in the middle of it
+func protoVarint(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		value |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
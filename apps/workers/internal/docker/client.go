@@ -0,0 +1,136 @@
+// a small client for the Docker Engine API. it talks http over the unix socket of the daemon, so the worker
+// no longer needs the docker cli or a shell. only the endpoints the worker uses are here.
+// podman serves the same api on its own socket, so the client works with it as well.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	Socket     string // path of the unix socket, e.g. /var/run/docker.sock
+	APIVersion string // sent as /v<version>/ in front of every path, empty means the daemon's own version
+	http       *http.Client
+}
+
+// New returns a client for the daemon listening on socket. any http server on a unix socket works,
+// which is how the client can be pointed at a fake daemon.
+func New(socket string, apiVersion string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+		MaxIdleConns:    10,
+		IdleConnTimeout: 90 * time.Second,
+	}
+
+	return &Client{
+		Socket:     socket,
+		APIVersion: apiVersion,
+		http:       &http.Client{Transport: transport}, // no timeout, builds and log streams run for a long time. use the context
+	}
+}
+
+// Ping checks that the daemon answers
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends one request. body may be nil, an io.Reader (sent as is) or anything else (sent as json).
+// any status >= 400 (and 304, which docker uses for "already started/stopped") is turned into an *APIError and the body is closed.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	contentType := ""
+
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	u := "http://docker" + c.versionPrefix() + path // the host is ignored, every connection goes to the socket
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &ConnectionError{Socket: c.Socket, Err: err}
+	}
+
+	if resp.StatusCode >= 400 || resp.StatusCode == http.StatusNotModified {
+		defer resp.Body.Close()
+		return nil, newAPIError(method, path, resp)
+	}
+
+	return resp, nil
+}
+
+// doJSON sends the request and decodes the json answer into out (if out is not nil)
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, body any, out any) error {
+	resp, err := c.do(ctx, method, path, query, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode answer of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func (c *Client) versionPrefix() string {
+	if c.APIVersion == "" {
+		return ""
+	}
+	return "/v" + strings.TrimPrefix(c.APIVersion, "v")
+}
+
+// filters encodes the filters query parameter, e.g. {"label": ["a=b"]}
+func filters(f map[string][]string) string {
+	data, _ := json.Marshal(f)
+	return string(data)
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"worker/internal/docker/dockertest"
+)
+
+// newTestClient is a client for a fake daemon serving handler
+func newTestClient(t *testing.T, apiVersion string, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := dockertest.NewServer(t, handler)
+	return New(server.Socket, apiVersion)
+}
+
+func TestPingUsesAPIVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		path    string
+	}{
+		{"", "/_ping"},
+		{"1.41", "/v1.41/_ping"},
+		{"v1.43", "/v1.43/_ping"},
+	} {
+		var got string
+		client := newTestClient(t, tc.version, func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.Path
+			io.WriteString(w, "OK")
+		})
+
+		if err := client.Ping(context.Background()); err != nil {
+			t.Fatalf("version %q: %v", tc.version, err)
+		}
+		if got != tc.path {
+			t.Errorf("version %q: requested %s, want %s", tc.version, got, tc.path)
+		}
+	}
+}
+
+func TestCreateContainerSendsJSON(t *testing.T) {
+	client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/containers/create" {
+			dockertest.WriteError(w, http.StatusNotFound, "page not found")
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type %q, want application/json", ct)
+		}
+		if name := r.URL.Query().Get("name"); name != "blacktree-1234abcd" {
+			t.Errorf("name %q, want blacktree-1234abcd", name)
+		}
+
+		var config ContainerConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			t.Errorf("body: %v", err)
+		}
+		if config.Image != "blacktree/app-1234abcd" || config.HostConfig.Memory != 256<<20 {
+			t.Errorf("body: got image %q memory %d", config.Image, config.HostConfig.Memory)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"Id": "c0ffee", "Warnings": []}`)
+	})
+
+	id, err := client.CreateContainer(context.Background(), "blacktree-1234abcd", ContainerConfig{
+		Image:      "blacktree/app-1234abcd",
+		HostConfig: HostConfig{Memory: 256 << 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "c0ffee" {
+		t.Errorf("id %q, want c0ffee", id)
+	}
+}
+
+func TestDecodeFailure(t *testing.T) {
+	client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"Id": `)
+	})
+
+	_, err := client.InspectContainer(context.Background(), "c0ffee")
+	if err == nil {
+		t.Fatal("expected an error for a truncated answer")
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		t.Errorf("a decode failure is not an api error: %v", err)
+	}
+}
+
+func TestConnectionError(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "missing.sock")
+	client := New(socket, "")
+
+	err := client.Ping(context.Background())
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("expected a *ConnectionError, got %T %v", err, err)
+	}
+	if connErr.Socket != socket {
+		t.Errorf("socket %q, want %q", connErr.Socket, socket)
+	}
+	if IsNotFound(err) {
+		t.Error("an unreachable daemon is not a missing container")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := client.Ping(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// container endpoints: create, start, stop, kill, remove, inspect, list and wait.
+// the structs only have the fields the worker uses, the daemon ignores the rest.
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type PortBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort"`
+}
+
+type RestartPolicy struct {
+	Name              string `json:"Name"` // "", "no", "always", "unless-stopped", "on-failure"
+	MaximumRetryCount int    `json:"MaximumRetryCount,omitempty"`
+}
+
+// Ulimit is a resource limit of the processes in a container, like ulimit -n
+type Ulimit struct {
+	Name string `json:"Name"` // nofile, nproc, core, ...
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+type HostConfig struct {
+	PortBindings  map[string][]PortBinding `json:"PortBindings,omitempty"` // "3000/tcp" -> host ports
+	RestartPolicy RestartPolicy            `json:"RestartPolicy,omitempty"`
+	AutoRemove    bool                     `json:"AutoRemove,omitempty"`
+	NanoCPUs      int64                    `json:"NanoCpus,omitempty"`   // cpu quota in units of 1e-9 cpus
+	CPUShares     int64                    `json:"CpuShares,omitempty"`  // relative weight, 1024 when empty
+	Memory        int64                    `json:"Memory,omitempty"`     // bytes
+	MemorySwap    int64                    `json:"MemorySwap,omitempty"` // memory plus swap in bytes, Memory alone means no swap
+	PidsLimit     *int64                   `json:"PidsLimit,omitempty"`
+	Ulimits       []Ulimit                 `json:"Ulimits,omitempty"`
+}
+
+// ContainerConfig is the body of POST /containers/create
+type ContainerConfig struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Env          []string            `json:"Env,omitempty"` // KEY=value
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   HostConfig          `json:"HostConfig"`
+}
+
+// CreateContainer creates (but doesn't start) a container and returns its id
+func (c *Client) CreateContainer(ctx context.Context, name string, config ContainerConfig) (string, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	var created struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/create", query, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// StartContainer starts a created or stopped container. a container that is already running is not an error.
+func (c *Client) StartContainer(ctx context.Context, id string) error {
+	err := c.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil)
+	if errors.Is(err, ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+// StopContainer sends SIGTERM and kills the container when it is still running after timeout.
+// a container that is already stopped is not an error.
+func (c *Client) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{}
+	query.Set("t", strconv.Itoa(int(timeout.Seconds())))
+
+	err := c.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", query, nil, nil)
+	if errors.Is(err, ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+// KillContainer sends a signal to the container, SIGKILL when signal is empty
+func (c *Client) KillContainer(ctx context.Context, id string, signal string) error {
+	query := url.Values{}
+	if signal != "" {
+		query.Set("signal", signal)
+	}
+	return c.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/kill", query, nil, nil)
+}
+
+// RemoveContainer deletes the container. force also removes a running one, volumes removes its anonymous volumes.
+func (c *Client) RemoveContainer(ctx context.Context, id string, force bool, volumes bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+	if volumes {
+		query.Set("v", "1")
+	}
+	return c.doJSON(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id), query, nil, nil)
+}
+
+type ContainerState struct {
+	Status     string `json:"Status"` // created, running, paused, restarting, removing, exited, dead
+	Running    bool   `json:"Running"`
+	Restarting bool   `json:"Restarting"`
+	OOMKilled  bool   `json:"OOMKilled"`
+	Dead       bool   `json:"Dead"`
+	Pid        int    `json:"Pid"`
+	ExitCode   int    `json:"ExitCode"`
+	Error      string `json:"Error"`
+	StartedAt  string `json:"StartedAt"`
+	FinishedAt string `json:"FinishedAt"`
+}
+
+// ContainerInfo is the answer of GET /containers/{id}/json
+type ContainerInfo struct {
+	ID           string         `json:"Id"`
+	Name         string         `json:"Name"`
+	Image        string         `json:"Image"` // image id
+	RestartCount int            `json:"RestartCount"`
+	State        ContainerState `json:"State"`
+	Config       struct {
+		Image  string            `json:"Image"` // image name the container was created from
+		Labels map[string]string `json:"Labels"`
+		Tty    bool              `json:"Tty"`
+	} `json:"Config"`
+	HostConfig struct {
+		Memory int64 `json:"Memory"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Ports map[string][]PortBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// InspectContainer returns the container's config and state. ErrNotFound when it doesn't exist.
+func (c *Client) InspectContainer(ctx context.Context, id string) (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := c.doJSON(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Container is one entry of GET /containers/json
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"` // human readable, e.g. "Up 2 minutes"
+	Labels map[string]string `json:"Labels"`
+}
+
+// ListContainers lists containers matching the filters, e.g. {"label": ["a=b"]} or {"ancestor": ["image"]}.
+// all also returns stopped containers.
+func (c *Client) ListContainers(ctx context.Context, all bool, filter map[string][]string) ([]Container, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+	if len(filter) > 0 {
+		query.Set("filters", filters(filter))
+	}
+
+	var containers []Container
+	if err := c.doJSON(ctx, http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// WaitContainer blocks until the container stops and returns its exit code
+func (c *Client) WaitContainer(ctx context.Context, id string) (int, error) {
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/wait", nil, nil, &result); err != nil {
+		return 0, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, &StreamError{Op: "wait", Message: result.Error.Message}
+	}
+	return result.StatusCode, nil
+}
@@ -0,0 +1,138 @@
+// .dockerignore support for the build context. the cli used to do this for us, the api expects the
+// client to leave ignored files out of the tar itself.
+
+package docker
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ignoreRule struct {
+	pattern string // cleaned, slash separated, relative to the context
+	exclude bool   // false for "!pattern" exceptions
+}
+
+// Ignore is a parsed .dockerignore
+type Ignore struct {
+	rules []ignoreRule
+}
+
+// ReadIgnore parses <contextDir>/.dockerignore. a missing file ignores nothing.
+func ReadIgnore(contextDir string) (*Ignore, error) {
+	file, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Ignore{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ParseIgnore(lines), nil
+}
+
+// ParseIgnore builds the rules from the lines of a .dockerignore
+func ParseIgnore(lines []string) *Ignore {
+	ig := &Ignore{}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{exclude: true}
+		if strings.HasPrefix(line, "!") {
+			rule.exclude = false
+			line = strings.TrimSpace(line[1:])
+		}
+
+		line = path.Clean(filepath.ToSlash(line))
+		line = strings.TrimPrefix(line, "/")
+		if line == "." || line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		ig.rules = append(ig.rules, rule)
+	}
+
+	return ig
+}
+
+// Match reports whether the file (slash separated, relative to the context) is left out of the context.
+// like docker the last rule that matches wins, and a rule matching a folder also matches everything inside it.
+func (ig *Ignore) Match(rel string) bool {
+	rel = path.Clean(filepath.ToSlash(rel))
+	ignored := false
+
+	for _, rule := range ig.rules {
+		if matchWithParents(rule.pattern, rel) {
+			ignored = rule.exclude
+		}
+	}
+	return ignored
+}
+
+// HasExceptions reports whether there are "!" rules, then an ignored folder still has to be walked
+func (ig *Ignore) HasExceptions() bool {
+	for _, rule := range ig.rules {
+		if !rule.exclude {
+			return true
+		}
+	}
+	return false
+}
+
+func matchWithParents(pattern, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchGlob(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches slash separated paths, "**" stands for any number of folders
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,720 @@
+// a fake docker (or podman) engine that keeps its images and containers in memory. it answers the endpoints
+// the worker uses with the statuses and bodies the real engine sends, so the runtimes can be tested end to end
+// without one. containers don't run anything: starting one only prints what the `echo` of its image's CMD would.
+
+package dockertest
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type image struct {
+	id          string
+	tags        []string // name:tag
+	repoDigests []string // name@sha256:...
+	cmd         string
+}
+
+type container struct {
+	id       string
+	name     string
+	image    string // as given on create
+	env      []string
+	labels   map[string]string
+	ports    map[string]string // "8080/tcp" -> host port
+	memory   int64
+	running  bool
+	status   string
+	exitCode int
+	logs     string
+}
+
+// Engine serves the fake engine api on a unix socket
+type Engine struct {
+	*Server
+	Podman bool // report local images the way podman does, with localhost/<name>@sha256:... repo digests
+
+	// PushDigest decides whether a push reports its digest in the stream, some engines leave it out. true by default
+	PushDigest bool
+
+	mu         sync.Mutex
+	images     []*image
+	containers []*container
+	nextPort   int
+	failures   map[string]failure
+	requests   []Request
+	sessions   map[string]*session
+	secrets    map[string]string
+}
+
+// Request is a request the engine got, without the body
+type Request struct {
+	Method string
+	Path   string // without the api version
+	Query  map[string][]string
+	Header http.Header
+}
+
+type failure struct {
+	status  int // 0 fails inside the progress stream
+	message string
+}
+
+// NewEngine starts an empty docker engine
+func NewEngine(t testing.TB) *Engine {
+	t.Helper()
+	e := &Engine{
+		PushDigest: true,
+		nextPort:   32768,
+		failures:   make(map[string]failure),
+		sessions:   make(map[string]*session),
+		secrets:    make(map[string]string),
+	}
+	e.Server = NewServer(t, http.HandlerFunc(e.serve))
+	return e
+}
+
+// NewPodmanEngine starts an empty podman engine
+func NewPodmanEngine(t testing.TB) *Engine {
+	e := NewEngine(t)
+	e.Podman = true
+	return e
+}
+
+// Fail makes every request to method path answer status with message. path is without the api version,
+// e.g. /images/create, containers can be given by name. a status of 0 answers 200 and fails inside the progress
+// stream instead.
+func (e *Engine) Fail(method, path string, status int, message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures[method+" "+path] = failure{status: status, message: message}
+}
+
+// Requests returns the requests the engine got so far, oldest first
+func (e *Engine) Requests() []Request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Request(nil), e.requests...)
+}
+
+// LastRequest is the newest request to method path, nil when there was none
+func (e *Engine) LastRequest(method, path string) *Request {
+	requests := e.Requests()
+	for i := len(requests) - 1; i >= 0; i-- {
+		if requests[i].Method == method && requests[i].Path == path {
+			return &requests[i]
+		}
+	}
+	return nil
+}
+
+// AddImage gives the engine an image under ref, as if it was built there: it has no repo digest
+func (e *Engine) AddImage(ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	img := &image{id: digestOf("build " + ref)}
+	e.images = append(e.images, img)
+	e.addName(img, ref)
+}
+
+// HasImage tells whether the engine knows the reference
+func (e *Engine) HasImage(ref string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.findImage(ref) != nil
+}
+
+// Containers returns the names of the containers the engine has, running or not
+func (e *Engine) Containers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for _, c := range e.containers {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+func (e *Engine) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if loc := versionPrefix.FindStringIndex(path); loc != nil {
+		path = path[loc[1]-1:]
+	}
+
+	e.mu.Lock()
+	e.requests = append(e.requests, Request{Method: r.Method, Path: path, Query: r.URL.Query(), Header: r.Header.Clone()})
+	fail, failing := e.failures[r.Method+" "+path]
+	if id, action, ok := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/"); !failing && ok && strings.HasPrefix(path, "/containers/") {
+		if c := e.findContainer(id); c != nil {
+			fail, failing = e.failures[r.Method+" /containers/"+c.name+"/"+action]
+		}
+	}
+	e.mu.Unlock()
+
+	if failing {
+		io.Copy(io.Discard, r.Body)
+		if fail.status == 0 {
+			WriteStream(w, map[string]any{"errorDetail": map[string]string{"message": fail.message}, "error": fail.message})
+		} else {
+			WriteError(w, fail.status, fail.message)
+		}
+		return
+	}
+
+	switch {
+	case path == "/_ping":
+		io.WriteString(w, "OK")
+	case r.Method == http.MethodPost && path == "/session":
+		e.session(w, r)
+	case r.Method == http.MethodPost && path == "/build":
+		e.build(w, r)
+	case r.Method == http.MethodPost && path == "/images/create":
+		e.pull(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/push"):
+		e.push(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/push"))
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/tag"):
+		e.tag(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/tag"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+		e.inspectImage(w, strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json"))
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/images/"):
+		e.removeImage(w, strings.TrimPrefix(path, "/images/"))
+	case r.Method == http.MethodPost && path == "/containers/create":
+		e.create(w, r)
+	case r.Method == http.MethodGet && path == "/containers/json":
+		e.list(w, r)
+	case strings.HasPrefix(path, "/containers/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/")
+		e.containerAction(w, r, id, action)
+	default:
+		WriteError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// normalize adds the latest tag to references without a tag or digest
+func normalize(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+func repository(ref string) string {
+	name, _, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name
+}
+
+func digestOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (e *Engine) findImage(ref string) *image {
+	ref = normalize(ref)
+	for _, img := range e.images {
+		if img.id == ref || strings.TrimPrefix(img.id, "sha256:") == ref {
+			return img
+		}
+		for _, name := range append(append([]string{}, img.tags...), img.repoDigests...) {
+			if name == ref {
+				return img
+			}
+		}
+	}
+	return nil
+}
+
+// addName moves the tag to img, like the engine does when a build or a pull reuses a name
+func (e *Engine) addName(img *image, name string) {
+	name = normalize(name)
+	for _, other := range e.images {
+		other.tags = without(other.tags, name)
+	}
+	img.tags = append(img.tags, name)
+	e.dropUnnamed()
+}
+
+// dropUnnamed forgets the images without a tag, the real engine keeps them as dangling images
+func (e *Engine) dropUnnamed() {
+	kept := e.images[:0]
+	for _, img := range e.images {
+		if len(img.tags) > 0 {
+			kept = append(kept, img)
+		}
+	}
+	e.images = kept
+}
+
+func without(list []string, value string) []string {
+	kept := list[:0]
+	for _, v := range list {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func addUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+var echoCmd = regexp.MustCompile(`echo ([^&;"]+)`)
+
+func (e *Engine) build(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dockerfileName := query.Get("dockerfile")
+	if dockerfileName == "" {
+		dockerfileName = "Dockerfile"
+	}
+
+	// the context is read like the engine does, the Dockerfile has to be in it
+	files := make(map[string]string)
+	tr := tar.NewReader(r.Body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid build context: "+err.Error())
+			return
+		}
+		data, _ := io.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+
+	dockerfile, ok := files[dockerfileName]
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "Cannot locate specified Dockerfile: "+dockerfileName)
+		return
+	}
+
+	if err := e.buildSecrets(query.Get("session"), dockerfile); err != nil {
+		message := "failed to solve: " + err.Error()
+		WriteStream(w, map[string]any{"errorDetail": map[string]string{"message": message}, "error": message})
+		return
+	}
+
+	var messages []any
+	var cmd string
+	for i, line := range strings.Split(strings.TrimSpace(dockerfile), "\n") {
+		line = strings.TrimSpace(line)
+		messages = append(messages, map[string]string{"stream": fmt.Sprintf("Step %d : %s\n", i+1, line)})
+
+		// the only check a RUN can make here: that a file was left out of the context
+		if missing, ok := strings.CutPrefix(line, "RUN test ! -e /ctx/"); ok {
+			if _, sent := files[missing]; sent {
+				message := fmt.Sprintf("The command '/bin/sh -c test ! -e /ctx/%s' returned a non-zero code: 1", missing)
+				messages = append(messages, map[string]any{"errorDetail": map[string]any{"code": 1, "message": message}, "error": message})
+				WriteStream(w, messages...)
+				return
+			}
+		}
+		if rest, ok := strings.CutPrefix(line, "CMD "); ok {
+			cmd = rest
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name+"="+files[name])
+	}
+	sort.Strings(names)
+	img := &image{id: digestOf(strings.Join(names, "\n") + query.Get("buildargs")), cmd: cmd}
+
+	e.mu.Lock()
+	if existing := e.findImage(img.id); existing != nil {
+		img = existing
+	} else {
+		e.images = append(e.images, img)
+	}
+	for _, tag := range query["t"] {
+		e.addName(img, tag)
+		if e.Podman {
+			img.repoDigests = addUnique(img.repoDigests, "localhost/"+repository(normalize(tag))+"@"+img.id)
+		}
+	}
+	e.mu.Unlock()
+
+	if query.Get("version") == "2" {
+		messages = append(messages, map[string]any{"id": "moby.image.id", "aux": map[string]string{"ID": img.id}})
+	} else {
+		messages = append(messages, map[string]any{"aux": map[string]string{"ID": img.id}})
+	}
+	messages = append(messages, map[string]string{"stream": "Successfully built " + strings.TrimPrefix(img.id, "sha256:")[:12] + "\n"})
+	WriteStream(w, messages...)
+}
+
+func (e *Engine) pull(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("fromImage")
+	tag := r.URL.Query().Get("tag")
+	if name == "" {
+		WriteError(w, http.StatusBadRequest, "fromImage is required")
+		return
+	}
+
+	ref := name + ":" + tag
+	if strings.HasPrefix(tag, "sha256:") {
+		ref = name + "@" + tag
+	}
+
+	e.mu.Lock()
+	img := e.findImage(ref)
+	if img == nil {
+		if strings.HasPrefix(tag, "sha256:") {
+			e.mu.Unlock()
+			WriteStream(w, map[string]any{"errorDetail": map[string]string{"message": "manifest for " + ref + " not found: manifest unknown"}, "error": "manifest for " + ref + " not found: manifest unknown"})
+			return
+		}
+		// any tag exists in the fake registry, its content is its name
+		img = &image{id: digestOf("image " + ref), cmd: `["sh"]`}
+		e.images = append(e.images, img)
+		e.addName(img, ref)
+	}
+	digest := digestOf("manifest " + img.id)
+	img.repoDigests = addUnique(img.repoDigests, name+"@"+digest)
+	e.mu.Unlock()
+
+	WriteStream(w,
+		map[string]string{"status": "Pulling from " + name, "id": tag},
+		map[string]string{"status": "Downloading", "id": "a1b2c3", "progress": "[=====>   ]"},
+		map[string]string{"status": "Digest: " + digest},
+		map[string]string{"status": "Status: Downloaded newer image for " + ref},
+	)
+}
+
+func (e *Engine) push(w http.ResponseWriter, r *http.Request, name string) {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		tag = "latest"
+	}
+
+	// the engine refuses pushes without credentials, even empty ones
+	auth := r.Header.Get("X-Registry-Auth")
+	if auth == "" {
+		WriteError(w, http.StatusBadRequest, "Bad parameters and missing X-Registry-Auth: invalid X-Registry-Auth header: EOF")
+		return
+	}
+	if _, err := base64.URLEncoding.DecodeString(auth); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid X-Registry-Auth header: "+err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	img := e.findImage(name + ":" + tag)
+	if img == nil {
+		e.mu.Unlock()
+		WriteError(w, http.StatusNotFound, "An image does not exist locally with the tag: "+name)
+		return
+	}
+	digest := digestOf("manifest " + img.id)
+	img.repoDigests = addUnique(img.repoDigests, name+"@"+digest)
+	reportDigest := e.PushDigest
+	e.mu.Unlock()
+
+	messages := []any{
+		map[string]string{"status": "The push refers to repository [" + name + "]"},
+		map[string]string{"status": "Pushed", "id": "a1b2c3"},
+		map[string]string{"status": tag + ": digest: " + digest + " size: 528"},
+	}
+	if reportDigest {
+		messages = append(messages, map[string]any{"aux": map[string]any{"Tag": tag, "Digest": digest, "Size": 528}})
+	}
+	WriteStream(w, messages...)
+}
+
+func (e *Engine) tag(w http.ResponseWriter, r *http.Request, source string) {
+	repo := r.URL.Query().Get("repo")
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		tag = "latest"
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	img := e.findImage(source)
+	if img == nil {
+		WriteError(w, http.StatusNotFound, "No such image: "+source)
+		return
+	}
+	e.addName(img, repo+":"+tag)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (e *Engine) inspectImage(w http.ResponseWriter, ref string) {
+	e.mu.Lock()
+	img := e.findImage(ref)
+	if img == nil {
+		e.mu.Unlock()
+		WriteError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	answer := map[string]any{
+		"Id":          img.id,
+		"RepoTags":    append([]string{}, img.tags...),
+		"RepoDigests": append([]string{}, img.repoDigests...),
+		"Size":        1024,
+		"Config":      map[string]any{"User": ""},
+	}
+	e.mu.Unlock()
+	writeJSON(w, http.StatusOK, answer)
+}
+
+func (e *Engine) removeImage(w http.ResponseWriter, ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	img := e.findImage(ref)
+	if img == nil {
+		WriteError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+
+	ref = normalize(ref)
+	switch {
+	case strings.Contains(ref, "@"):
+		img.repoDigests = without(img.repoDigests, ref)
+		if len(img.tags) == 1 && repository(img.tags[0]) == repository(ref) {
+			img.tags = nil // a pinned reference removes the only name of its repository with it
+		}
+	case img.id == ref || strings.TrimPrefix(img.id, "sha256:") == ref:
+		img.tags = nil
+	default:
+		img.tags = without(img.tags, ref)
+	}
+
+	answer := []map[string]string{{"Untagged": ref}}
+	if len(img.tags) == 0 {
+		answer = append(answer, map[string]string{"Deleted": img.id})
+	}
+	e.dropUnnamed()
+	writeJSON(w, http.StatusOK, answer)
+}
+
+func (e *Engine) findContainer(id string) *container {
+	for _, c := range e.containers {
+		if c.id == id || c.name == strings.TrimPrefix(id, "/") || (len(id) >= 12 && strings.HasPrefix(c.id, id)) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (e *Engine) create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Image        string
+		Env          []string
+		Labels       map[string]string
+		ExposedPorts map[string]struct{}
+		HostConfig   struct {
+			PortBindings map[string][]struct{ HostPort string }
+			Memory       int64
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	name := r.URL.Query().Get("name")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	img := e.findImage(body.Image)
+	if img == nil {
+		WriteError(w, http.StatusNotFound, "No such image: "+body.Image)
+		return
+	}
+	if name != "" && e.findContainer(name) != nil {
+		WriteError(w, http.StatusConflict, fmt.Sprintf("Conflict. The container name \"/%s\" is already in use by another container", name))
+		return
+	}
+
+	c := &container{
+		id:     digestOf(fmt.Sprintf("container %s %d", name, len(e.requests)))[len("sha256:"):],
+		name:   name,
+		image:  body.Image,
+		env:    body.Env,
+		labels: body.Labels,
+		ports:  make(map[string]string),
+		memory: body.HostConfig.Memory,
+		status: "created",
+	}
+	if c.name == "" {
+		c.name = "fake_" + c.id[:8]
+	}
+	for port, bindings := range body.HostConfig.PortBindings {
+		for _, b := range bindings {
+			hostPort := b.HostPort
+			if hostPort == "" || hostPort == "0" {
+				hostPort = strconv.Itoa(e.nextPort)
+				e.nextPort++
+			}
+			c.ports[port] = hostPort
+		}
+	}
+	if match := echoCmd.FindStringSubmatch(img.cmd); match != nil {
+		c.logs = strings.TrimSpace(match[1]) + "\n"
+	}
+	e.containers = append(e.containers, c)
+
+	writeJSON(w, http.StatusCreated, map[string]any{"Id": c.id, "Warnings": []string{}})
+}
+
+func (e *Engine) list(w http.ResponseWriter, r *http.Request) {
+	all := r.URL.Query().Get("all") == "1"
+	var filter map[string][]string
+	if f := r.URL.Query().Get("filters"); f != "" {
+		if err := json.Unmarshal([]byte(f), &filter); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid filter: "+err.Error())
+			return
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	answer := []map[string]any{}
+	for _, c := range e.containers {
+		if !all && !c.running {
+			continue
+		}
+		if !hasLabels(c.labels, filter["label"]) {
+			continue
+		}
+		answer = append(answer, map[string]any{
+			"Id":     c.id,
+			"Names":  []string{"/" + c.name},
+			"Image":  c.image,
+			"State":  c.status,
+			"Labels": c.labels,
+		})
+	}
+	writeJSON(w, http.StatusOK, answer)
+}
+
+// hasLabels matches the label filters, "key" or "key=value"
+func hasLabels(labels map[string]string, filters []string) bool {
+	for _, f := range filters {
+		key, value, withValue := strings.Cut(f, "=")
+		got, ok := labels[key]
+		if !ok || (withValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) containerAction(w http.ResponseWriter, r *http.Request, id, action string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.findContainer(id)
+	if c == nil {
+		WriteError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && action == "start":
+		if c.running {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		c.running, c.status, c.exitCode = true, "running", 0
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && (action == "stop" || action == "kill"):
+		if !c.running {
+			if action == "kill" {
+				WriteError(w, http.StatusConflict, "Container "+id+" is not running")
+				return
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		c.running, c.status, c.exitCode = false, "exited", 137
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete && action == "":
+		if c.running && r.URL.Query().Get("force") != "1" {
+			WriteError(w, http.StatusConflict, "You cannot remove a running container "+c.id+". Stop the container before attempting removal or force remove")
+			return
+		}
+		for i, other := range e.containers {
+			if other == c {
+				e.containers = append(e.containers[:i], e.containers[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && action == "json":
+		ports := map[string][]map[string]string{}
+		if c.running {
+			for port, hostPort := range c.ports {
+				ports[port] = []map[string]string{{"HostIp": "0.0.0.0", "HostPort": hostPort}}
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"Id":    c.id,
+			"Name":  "/" + c.name,
+			"Image": "sha256:" + c.id,
+			"State": map[string]any{
+				"Status":   c.status,
+				"Running":  c.running,
+				"ExitCode": c.exitCode,
+			},
+			"Config":          map[string]any{"Image": c.image, "Labels": c.labels, "Env": c.env},
+			"HostConfig":      map[string]any{"Memory": c.memory},
+			"NetworkSettings": map[string]any{"Ports": ports},
+		})
+
+	case r.Method == http.MethodGet && action == "logs":
+		w.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+		w.WriteHeader(http.StatusOK)
+		if c.status == "created" || c.logs == "" {
+			return
+		}
+		frame := make([]byte, 8, 8+len(c.logs))
+		frame[0] = 1 // stdout
+		binary.BigEndian.PutUint32(frame[4:], uint32(len(c.logs)))
+		w.Write(append(frame, c.logs...))
+
+	default:
+		WriteError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, answer any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(answer)
+}
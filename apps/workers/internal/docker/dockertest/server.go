@@ -0,0 +1,70 @@
+// fake engines for tests. the docker client only talks http over a unix socket, so a test serves its own handler on
+// a socket in a temporary folder, the way net/http/httptest serves one on a tcp port, and points the client at it.
+
+package dockertest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Server is an http server listening on a unix socket
+type Server struct {
+	*httptest.Server
+	Socket string // path of the socket, for docker.New
+}
+
+// NewServer serves handler on a new socket until the test ends
+func NewServer(t testing.TB, handler http.Handler) *Server {
+	t.Helper()
+
+	// not t.TempDir(), its long path can go past the 108 bytes a socket path may have
+	dir, err := os.MkdirTemp("", "dockertest")
+	if err != nil {
+		t.Fatalf("dockertest: %v", err)
+	}
+	socket := filepath.Join(dir, "engine.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("dockertest: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+
+	t.Cleanup(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+	return &Server{Server: server, Socket: socket}
+}
+
+// WriteError answers the way the engine does when a request fails: the status and {"message": ...}
+func WriteError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// WriteStream answers with a progress stream, one json message per line the way build, pull and push do
+func WriteStream(w http.ResponseWriter, messages ...any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for _, msg := range messages {
+		encoder.Encode(msg)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
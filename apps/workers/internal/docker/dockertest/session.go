@@ -0,0 +1,156 @@
+// buildkit sessions of the fake engine. like the real one the engine takes over the connection of POST /session and
+// calls the client's grpc methods over http/2 on it, a build with ?session= asks it for the secrets its RUN
+// instructions mount.
+
+package dockertest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+type session struct {
+	conn    *http2.ClientConn
+	methods []string
+}
+
+// Secrets returns the build secrets the engine got from sessions so far, id -> value
+func (e *Engine) Secrets() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	secrets := make(map[string]string, len(e.secrets))
+	for id, value := range e.secrets {
+		secrets[id] = value
+	}
+	return secrets
+}
+
+func (e *Engine) session(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("X-Docker-Expose-Session-Uuid")
+	if id == "" || r.Header.Get("Upgrade") != "h2c" {
+		WriteError(w, http.StatusBadRequest, "session id and h2c upgrade expected")
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "connection can't be hijacked")
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+
+	transport := &http2.Transport{AllowHTTP: true}
+	client, err := transport.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	e.mu.Lock()
+	e.sessions[id] = &session{conn: client, methods: r.Header.Values("X-Docker-Expose-Session-Grpc-Method")}
+	e.mu.Unlock()
+}
+
+var secretMount = regexp.MustCompile(`--mount=type=secret,id=([^,\s]+)`)
+
+// buildSecrets asks the session of the build for every secret the Dockerfile mounts. buildkit mounts an empty file
+// for a secret the session doesn't have, so only other failures are errors.
+func (e *Engine) buildSecrets(sessionID, dockerfile string) error {
+	matches := secretMount.FindAllStringSubmatch(dockerfile, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	s := e.sessions[sessionID]
+	e.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	if !contains(s.methods, "/moby.buildkit.secrets.v1.Secrets/GetSecret") {
+		return fmt.Errorf("session %s has no secrets provider", sessionID)
+	}
+
+	if _, err := s.call("/grpc.health.v1.Health/Check", nil); err != nil {
+		return fmt.Errorf("session %s is not healthy: %w", sessionID, err)
+	}
+
+	for _, m := range matches {
+		request := binary.AppendUvarint([]byte{1<<3 | 2}, uint64(len(m[1]))) // GetSecretRequest{ID}
+		request = append(request, m[1]...)
+		answer, err := s.call("/moby.buildkit.secrets.v1.Secrets/GetSecret", request)
+		if err != nil {
+			if strings.Contains(err.Error(), "grpc status 5") {
+				continue
+			}
+			return err
+		}
+
+		// GetSecretResponse{Data}
+		if len(answer) < 2 || answer[0] != 1<<3|2 {
+			return fmt.Errorf("invalid secret answer %x", answer)
+		}
+		length, n := binary.Uvarint(answer[1:])
+		value := answer[1+n:]
+		if n <= 0 || uint64(len(value)) != length {
+			return fmt.Errorf("invalid secret answer %x", answer)
+		}
+
+		e.mu.Lock()
+		e.secrets[m[1]] = string(value)
+		e.mu.Unlock()
+	}
+	return nil
+}
+
+// call makes a unary grpc call and returns the answer message
+func (s *session) call(method string, request []byte) ([]byte, error) {
+	body := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(request)))
+	body = append(body, request...)
+
+	req, err := http.NewRequest(http.MethodPost, "http://session"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := s.conn.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body) // the trailers are only there after the body
+	if err != nil {
+		return nil, err
+	}
+
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		return nil, fmt.Errorf("%s: grpc status %s: %s", method, status, resp.Trailer.Get("Grpc-Message"))
+	}
+	if len(data) < 5 || int(binary.BigEndian.Uint32(data[1:5])) != len(data)-5 {
+		return nil, fmt.Errorf("%s: invalid grpc answer %x", method, data)
+	}
+	return data[5:], nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
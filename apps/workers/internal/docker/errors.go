@@ -0,0 +1,89 @@
+// errors returned by the client. callers check them with errors.Is / errors.As instead of matching on strings.
+
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")     // 404, no such container or image
+	ErrConflict    = errors.New("conflict")      // 409, e.g. a name that is already in use or removing a running container
+	ErrNotModified = errors.New("not modified")  // 304, container already started or stopped
+	ErrDaemon      = errors.New("daemon error")  // 500 and everything else the daemon got wrong
+	ErrBadRequest  = errors.New("bad parameter") // 400
+)
+
+// APIError is an answer with an error status from the daemon
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string // the "message" field the daemon sends back
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker %s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is(err, docker.ErrNotFound) and friends work on an *APIError
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrNotModified:
+		return e.StatusCode == http.StatusNotModified
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrDaemon:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+func newAPIError(method, path string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var answer struct {
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &answer) == nil && answer.Message != "" {
+		message = answer.Message
+	}
+
+	return &APIError{StatusCode: resp.StatusCode, Method: method, Path: path, Message: message}
+}
+
+// ConnectionError means the daemon could not be reached at all
+type ConnectionError struct {
+	Socket string
+	Err    error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("cannot reach docker daemon at %s: %v", e.Socket, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+// StreamError is an error the daemon reported inside a progress stream (build, pull, push).
+// the http status was 200 because the stream had already started.
+type StreamError struct {
+	Op      string // "build", "pull", ...
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("docker %s failed: %s", e.Op, e.Message)
+}
+
+// IsNotFound reports whether the container or image does not exist
+func IsNotFound(err error) bool { return errors.Is(err, ErrNotFound) }
@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	"worker/internal/docker/dockertest"
+)
+
+func TestAPIErrorMapping(t *testing.T) {
+	sentinels := []error{ErrNotFound, ErrConflict, ErrNotModified, ErrBadRequest, ErrDaemon}
+
+	for _, tc := range []struct {
+		status  int
+		body    string
+		want    error
+		message string
+	}{
+		{http.StatusNotFound, `{"message": "No such container: c0ffee"}`, ErrNotFound, "No such container: c0ffee"},
+		{http.StatusConflict, `{"message": "You cannot remove a running container c0ffee"}`, ErrConflict, "You cannot remove a running container c0ffee"},
+		{http.StatusBadRequest, `{"message": "invalid reference format"}`, ErrBadRequest, "invalid reference format"},
+		{http.StatusInternalServerError, `{"message": "driver failed"}`, ErrDaemon, "driver failed"},
+		{http.StatusBadGateway, "upstream gone\n", ErrDaemon, "upstream gone"}, // not json, the body is the message
+		{http.StatusNotFound, `{"message": ""}`, ErrNotFound, `{"message": ""}`},
+	} {
+		client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			io.WriteString(w, tc.body)
+		})
+
+		err := client.RemoveContainer(context.Background(), "c0ffee", false, false)
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("%d: expected an *APIError, got %T %v", tc.status, err, err)
+		}
+		if apiErr.StatusCode != tc.status || apiErr.Method != http.MethodDelete || apiErr.Path != "/containers/c0ffee" {
+			t.Errorf("%d: got %+v", tc.status, apiErr)
+		}
+		if apiErr.Message != tc.message {
+			t.Errorf("%d: message %q, want %q", tc.status, apiErr.Message, tc.message)
+		}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == tc.want) {
+				t.Errorf("%d: errors.Is(err, %v) = %t", tc.status, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestAPIErrorString(t *testing.T) {
+	err := &APIError{StatusCode: 409, Method: "POST", Path: "/containers/create", Message: "name in use"}
+	if got, want := err.Error(), "docker POST /containers/create: 409 name in use"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		dockertest.WriteError(w, http.StatusNotFound, "No such image: blacktree/app:latest")
+	})
+
+	_, err := client.InspectImage(context.Background(), "blacktree/app:latest")
+	if !IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		dockertest.WriteError(w, http.StatusConflict, `Conflict. The container name "/blacktree-1234abcd" is already in use`)
+	})
+
+	_, err := client.CreateContainer(context.Background(), "blacktree-1234abcd", ContainerConfig{Image: "busybox"})
+	if !errors.Is(err, ErrConflict) || IsNotFound(err) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+}
+
+// docker answers 304 when the container already is in the state asked for, start and stop treat that as done
+func TestNotModifiedIsNotAnError(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, "", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotModified)
+	})
+
+	if err := client.StartContainer(context.Background(), "c0ffee"); err != nil {
+		t.Errorf("start: %v", err)
+	}
+	if err := client.StopContainer(context.Background(), "c0ffee", time.Second); err != nil {
+		t.Errorf("stop: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("%d requests, want 2", calls)
+	}
+
+	err := client.KillContainer(context.Background(), "c0ffee", "")
+	if !errors.Is(err, ErrNotModified) {
+		t.Errorf("kill: expected ErrNotModified, got %v", err)
+	}
+}
+
+func TestStreamErrorString(t *testing.T) {
+	err := &StreamError{Op: "pull", Message: "manifest unknown"}
+	if got, want := err.Error(), "docker pull failed: manifest unknown"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
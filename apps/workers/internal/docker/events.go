@@ -0,0 +1,68 @@
+// GET /events streams what happens on the daemon (container died, oom, health_status, ...)
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type Event struct {
+	Type   string `json:"Type"`   // container, image, network, ...
+	Action string `json:"Action"` // start, die, oom, health_status: healthy, ...
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"` // labels, name, image, exitCode, ...
+	} `json:"Actor"`
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// Events streams daemon events matching the filters, e.g. {"type": ["container"], "label": ["a=b"]}.
+// the events channel is closed when the stream ends, the error channel then gets the reason (nil when ctx was cancelled).
+func (c *Client) Events(ctx context.Context, filter map[string][]string) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+
+		query := url.Values{}
+		if len(filter) > 0 {
+			query.Set("filters", filters(filter))
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, "/events", query, nil, nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var event Event
+			if err := decoder.Decode(&event); err != nil {
+				if ctx.Err() != nil {
+					err = nil
+				} else if err == io.EOF {
+					err = io.ErrUnexpectedEOF // the daemon never ends the stream on its own
+				}
+				errs <- err
+				return
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				errs <- nil
+				return
+			}
+		}
+	}()
+
+	return events, errs
+}
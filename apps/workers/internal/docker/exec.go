@@ -0,0 +1,57 @@
+// exec endpoints: run a command in a running container and wait for its exit code
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Exec runs cmd in the container, writes its stdout and stderr to out and returns its exit code.
+// when ctx ends first the command keeps running in the container, only the worker stops waiting for it.
+func (c *Client) Exec(ctx context.Context, id string, cmd []string, out io.Writer) (int, error) {
+	create := struct {
+		AttachStdout bool     `json:"AttachStdout"`
+		AttachStderr bool     `json:"AttachStderr"`
+		Cmd          []string `json:"Cmd"`
+	}{true, true, cmd}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", nil, create, &created); err != nil {
+		return 0, err
+	}
+
+	// without detach the daemon streams the output and closes the stream when the command is done
+	start := struct {
+		Detach bool `json:"Detach"`
+		Tty    bool `json:"Tty"`
+	}{false, false}
+
+	resp, err := c.do(ctx, http.MethodPost, "/exec/"+created.ID+"/start", nil, start, nil)
+	if err != nil {
+		return 0, err
+	}
+	body := bufio.NewReader(resp.Body)
+	if isMultiplexed(body) {
+		err = Demux(out, out, body)
+	} else {
+		_, err = io.Copy(out, body)
+	}
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	var inspect struct {
+		ExitCode int `json:"ExitCode"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &inspect); err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
@@ -0,0 +1,132 @@
+// image endpoints: pull, push, tag, inspect and remove
+
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthConfig are the credentials of a registry, the daemon gets them with every pull and push
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// header is X-Registry-Auth, base64url encoded json. push needs it even without credentials
+func (a *AuthConfig) header() http.Header {
+	if a == nil {
+		a = &AuthConfig{}
+	}
+	data, _ := json.Marshal(a)
+	return http.Header{"X-Registry-Auth": {base64.URLEncoding.EncodeToString(data)}}
+}
+
+// PullImage pulls the reference from its registry and writes the progress to out. auth may be nil.
+func (c *Client) PullImage(ctx context.Context, ref string, auth *AuthConfig, out io.Writer) error {
+	name, tag := splitReference(ref)
+
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag) // without a tag the daemon would pull every tag of the repository
+
+	var header http.Header
+	if auth != nil {
+		header = auth.header()
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/images/create", query, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readStream("pull", resp.Body, out, nil)
+}
+
+// PushImage pushes the image name:tag to its registry and returns the digest (sha256:...) the registry stored it
+// under. auth may be nil. the digest is empty when the engine didn't report it.
+func (c *Client) PushImage(ctx context.Context, ref string, auth *AuthConfig, out io.Writer) (string, error) {
+	name, tag := splitReference(ref)
+
+	query := url.Values{}
+	query.Set("tag", tag)
+
+	resp, err := c.do(ctx, http.MethodPost, "/images/"+name+"/push", query, nil, auth.header())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// the last message has {"aux": {"Tag": "...", "Digest": "sha256:...", "Size": 1234}}
+	var digest string
+	err = readStream("push", resp.Body, out, func(msg Message) {
+		var aux struct {
+			Digest string `json:"Digest"`
+		}
+		if len(msg.Aux) > 0 && json.Unmarshal(msg.Aux, &aux) == nil && aux.Digest != "" {
+			digest = aux.Digest
+		}
+	})
+	return digest, err
+}
+
+// TagImage gives an existing image another name, target is repo[:tag]
+func (c *Client) TagImage(ctx context.Context, source, target string) error {
+	repo, tag := splitReference(target)
+
+	query := url.Values{}
+	query.Set("repo", repo)
+	query.Set("tag", tag)
+
+	return c.doJSON(ctx, http.MethodPost, "/images/"+source+"/tag", query, nil, nil)
+}
+
+// ImageInfo is the answer of GET /images/{name}/json
+type ImageInfo struct {
+	ID          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"` // name@sha256:..., only for images that came from or went to a registry
+	Size        int64    `json:"Size"`
+	Config      struct {
+		User         string              `json:"User"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Labels       map[string]string   `json:"Labels"`
+	} `json:"Config"`
+}
+
+// InspectImage returns the image's metadata. ErrNotFound when it doesn't exist.
+func (c *Client) InspectImage(ctx context.Context, ref string) (*ImageInfo, error) {
+	var info ImageInfo
+	if err := c.doJSON(ctx, http.MethodGet, "/images/"+ref+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RemoveImage deletes the image, force also untags it from running containers
+func (c *Client) RemoveImage(ctx context.Context, ref string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+	return c.doJSON(ctx, http.MethodDelete, "/images/"+ref, query, nil, nil)
+}
+
+// splitReference splits name:tag or name@digest. the tag defaults to latest.
+func splitReference(ref string) (string, string) {
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		return name, digest // the api takes the digest in the tag parameter
+	}
+
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
@@ -0,0 +1,111 @@
+// GET /containers/{id}/logs. containers without a tty send stdout and stderr multiplexed in frames
+// with an 8 byte header, see https://docs.docker.com/engine/api/v1.41/#operation/ContainerAttach
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type LogsOptions struct {
+	Follow     bool      // keep streaming new lines until ctx is done or the container stops
+	Tail       int       // only the last n lines, 0 means all of them
+	Since      time.Time // only lines after this, zero means from the start
+	Timestamps bool
+}
+
+// ContainerLogs returns stdout and stderr of the container as one stream. the caller closes it.
+func (c *Client) ContainerLogs(ctx context.Context, id string, opt LogsOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	if opt.Follow {
+		query.Set("follow", "1")
+	}
+	if opt.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opt.Tail))
+	}
+	if !opt.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(opt.Since.Unix(), 10))
+	}
+	if opt.Timestamps {
+		query.Set("timestamps", "1")
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if !isMultiplexed(body) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{body, resp.Body}, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(Demux(pw, pw, body))
+		resp.Body.Close()
+	}()
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{pr, closerFunc(func() error {
+		pr.Close()
+		return resp.Body.Close()
+	})}, nil
+}
+
+// isMultiplexed peeks at the first frame header: stream type 0-2 followed by three zero bytes
+func isMultiplexed(r *bufio.Reader) bool {
+	header, err := r.Peek(4)
+	if err != nil {
+		return false
+	}
+	return header[0] <= 2 && header[1] == 0 && header[2] == 0 && header[3] == 0
+}
+
+// Demux splits a multiplexed stream into stdout and stderr. both may be the same writer.
+func Demux(stdout, stderr io.Writer, src io.Reader) error {
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var dst io.Writer
+		switch header[0] {
+		case 0, 1: // stdin is never sent, treat it like stdout
+			dst = stdout
+		case 2:
+			dst = stderr
+		default:
+			return fmt.Errorf("invalid log frame for stream %d", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(dst, src, size); err != nil {
+			return err
+		}
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,70 @@
+// build, pull and push answer with a stream of json messages instead of a single document.
+// each message is a line of output, a progress update, an error or aux data like the image id.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Message is one line of a progress stream
+type Message struct {
+	Stream      string          `json:"stream,omitempty"`   // build output
+	Status      string          `json:"status,omitempty"`   // pull/push status, e.g. "Downloading"
+	ID          string          `json:"id,omitempty"`       // layer the status is about
+	Progress    string          `json:"progress,omitempty"` // rendered progress bar
+	Error       string          `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail    `json:"errorDetail,omitempty"`
+	Aux         json.RawMessage `json:"aux,omitempty"` // e.g. {"ID": "sha256:..."} at the end of a build
+}
+
+type ErrorDetail struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// readStream decodes the stream until it ends, writes the human readable part to out and calls onMessage
+// (if not nil) for every message. an error message in the stream ends it with a *StreamError.
+func readStream(op string, body io.Reader, out io.Writer, onMessage func(Message)) error {
+	decoder := json.NewDecoder(body)
+
+	for {
+		var msg Message
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read %s progress: %w", op, err)
+		}
+
+		if onMessage != nil {
+			onMessage(msg)
+		}
+
+		if msg.Error != "" || msg.ErrorDetail != nil {
+			message := msg.Error
+			if message == "" {
+				message = msg.ErrorDetail.Message
+			}
+			return &StreamError{Op: op, Message: strings.TrimSpace(message)}
+		}
+
+		if out == nil {
+			continue
+		}
+
+		switch {
+		case msg.Stream != "":
+			io.WriteString(out, msg.Stream)
+		case msg.Status != "" && msg.Progress == "": // progress bars would flood the log, only the status changes are printed
+			if msg.ID != "" {
+				fmt.Fprintf(out, "%s: %s\n", msg.ID, msg.Status)
+			} else {
+				fmt.Fprintln(out, msg.Status)
+			}
+		}
+	}
+}
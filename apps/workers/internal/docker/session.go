@@ -0,0 +1,205 @@
+// buildkit gets build secrets from the client over a session: the client opens POST /session, the engine upgrades
+// the connection and then calls the client over it as a grpc (http/2) client. the worker answers the two methods
+// buildkit needs for secrets, moby.buildkit.secrets.v1.Secrets/GetSecret and the health check it uses to notice a
+// dead session. like the build trace (buildkit.go) the few protobuf fields are encoded by hand.
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	getSecretMethod   = "/moby.buildkit.secrets.v1.Secrets/GetSecret"
+	healthCheckMethod = "/grpc.health.v1.Health/Check"
+)
+
+// grpc status codes the session answers with
+const (
+	grpcOK            = 0
+	grpcNotFound      = 5
+	grpcUnimplemented = 12
+	grpcInternal      = 13
+)
+
+// Session is an open buildkit session, pass its ID with the build (BuildOptions.Session) and Close it after the build
+type Session struct {
+	ID   string
+	conn net.Conn
+	done chan struct{}
+}
+
+// StartSession opens a session that hands secrets (id -> value) to the builds using it
+func (c *Client) StartSession(ctx context.Context, secrets map[string]string) (*Session, error) {
+	id, err := sessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &ConnectionError{Socket: c.Socket, Err: err}
+	}
+
+	// the upgrade is sent by hand, net/http's client doesn't hand out the connection of a 101 for http/2
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://docker"+c.versionPrefix()+"/session", nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("X-Docker-Expose-Session-Uuid", id)
+	req.Header.Set("X-Docker-Expose-Session-Name", "blacktree")
+	req.Header.Set("X-Docker-Expose-Session-Sharedkey", id) // only used by buildkit to share caches between sessions
+	req.Header.Add("X-Docker-Expose-Session-Grpc-Method", getSecretMethod)
+	req.Header.Add("X-Docker-Expose-Session-Grpc-Method", healthCheckMethod)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, &ConnectionError{Socket: c.Socket, Err: err}
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, &ConnectionError{Socket: c.Socket, Err: err}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer conn.Close()
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return nil, newAPIError(http.MethodPost, "/session", resp)
+		}
+		return nil, fmt.Errorf("the engine did not upgrade the session connection: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	s := &Session{ID: id, conn: &bufferedConn{Conn: conn, reader: reader}, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		server := &http2.Server{}
+		server.ServeConn(s.conn, &http2.ServeConnOpts{Handler: secretsHandler(secrets)})
+	}()
+	return s, nil
+}
+
+// Close ends the session, buildkit can't ask for secrets anymore
+func (s *Session) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+func sessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// bufferedConn reads what the reader of the upgrade answer already buffered before the rest of the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// secretsHandler answers the grpc calls of the engine
+func secretsHandler(secrets map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := readGRPC(r.Body)
+		if err != nil {
+			writeGRPC(w, nil, grpcInternal, err.Error())
+			return
+		}
+
+		switch r.URL.Path {
+		case getSecretMethod:
+			var id string
+			err := protoFields(request, func(num int, value protoValue) error {
+				if num == 1 {
+					id = string(value.bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				writeGRPC(w, nil, grpcInternal, err.Error())
+				return
+			}
+			value, ok := secrets[id]
+			if !ok {
+				writeGRPC(w, nil, grpcNotFound, fmt.Sprintf("secret %s not found", id))
+				return
+			}
+			writeGRPC(w, protoBytes(1, []byte(value)), grpcOK, "")
+		case healthCheckMethod:
+			writeGRPC(w, []byte{1 << 3, 1}, grpcOK, "") // status: SERVING
+		default:
+			writeGRPC(w, nil, grpcUnimplemented, "unknown method "+r.URL.Path)
+		}
+	}
+}
+
+// readGRPC reads the single message of a unary call: 1 byte compression flag, 4 bytes length, the message
+func readGRPC(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, fmt.Errorf("invalid grpc request: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed grpc requests are not supported")
+	}
+	message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, fmt.Errorf("invalid grpc request: %w", err)
+	}
+	return message, nil
+}
+
+// writeGRPC answers a unary call, with message when the status is ok
+func writeGRPC(w http.ResponseWriter, message []byte, status int, errMessage string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	if status == grpcOK {
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+		w.Write(prefix[:])
+		w.Write(message)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status))
+	if errMessage != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", errMessage)
+	}
+}
+
+// protoBytes encodes a length delimited field
+func protoBytes(num int, value []byte) []byte {
+	data := binary.AppendUvarint(nil, uint64(num)<<3|2)
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	return append(data, value...)
+}
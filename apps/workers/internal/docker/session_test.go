@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"worker/internal/docker/dockertest"
+)
+
+// buildkit asks the session for the secrets the Dockerfile mounts, they never go into the build request
+func TestBuildWithSession(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	client := New(engine.Socket, "1.43")
+
+	dir := t.TempDir()
+	dockerfile := "FROM busybox\nRUN --mount=type=secret,id=npm_token --mount=type=secret,id=missing cat /run/secrets/npm_token\n"
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := client.StartSession(context.Background(), map[string]string{"npm_token": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	var out strings.Builder
+	if _, err := client.BuildImage(context.Background(), BuildOptions{ContextDir: dir, BuildKit: true, Session: session.ID}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if secrets := engine.Secrets(); len(secrets) != 1 || secrets["npm_token"] != "s3cret" {
+		t.Errorf("the engine got the secrets %v", secrets)
+	}
+	for _, req := range engine.Requests() {
+		for _, values := range req.Query {
+			if strings.Contains(strings.Join(values, " "), "s3cret") {
+				t.Errorf("%s %s sent the secret in %v", req.Method, req.Path, req.Query)
+			}
+		}
+	}
+	if build := engine.LastRequest(http.MethodPost, "/build"); build.Query["session"][0] != session.ID {
+		t.Errorf("build sent session %v, want %s", build.Query["session"], session.ID)
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("the build output has the secret: %s", out.String())
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	handler := secretsHandler(map[string]string{"npm_token": "s3cret"})
+	for path, want := range map[string]string{
+		"/moby.filesync.v1.Auth/Credentials":          "12",
+		"/moby.buildkit.secrets.v1.Secrets/GetSecret": "5", // id "other"
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "http://session"+path, strings.NewReader("\x00\x00\x00\x00\x07\x0a\x05other"))
+		handler(w, req)
+		if got := w.Header().Get(http.TrailerPrefix + "Grpc-Status"); got != want {
+			t.Errorf("%s: grpc status %q, want %s", path, got, want)
+		}
+	}
+}
+
+func TestStartSessionRefused(t *testing.T) {
+	server := dockertest.NewServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		dockertest.WriteError(w, http.StatusNotFound, "page not found")
+	}))
+
+	if _, err := New(server.Socket, "").StartSession(context.Background(), nil); !IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+// the janitor cleans up workspaces that nobody is going to build anymore. failed builds never delete their
+// workspace, and folders of crashed clones never make it into the jobs table, so without this tmp/repos only grows.
+// it compares what is on disk with the jobs and the status in sqlite.
+
+package janitor
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	"worker/internal/store"
+	"worker/internal/tracker"
+	"worker/internal/utils"
+)
+
+type Options struct {
+	MaxAge time.Duration // workspaces younger than this are left alone, they might still be cloning or building
+	DryRun bool          // only report what would be deleted
+}
+
+// Report is what one sweep found (or would have found in dry run mode)
+type Report struct {
+	Orphaned       int   // folders without a job
+	Stale          int   // workspaces whose job or deployment is finished, failed or gone
+	MissingFolders int   // waiting or building jobs whose folder no longer exists
+	ReclaimedBytes int64 // size of the deleted folders
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d orphaned, %d stale, %d missing folders, %s reclaimed",
+		r.Orphaned, r.Stale, r.MissingFolders, utils.HumanBytes(r.ReclaimedBytes))
+}
+
+// job stages and statuses in which a workspace is still needed
+var activeStatuses = map[string]bool{
+	"cloning":           true, // a new clone is running, the previous workspace may still be building
+	store.StageCloned:   true,
+	store.StageBuilding: true,
+}
+
+// Sweep runs one reconcile pass over tmp/repos
+func Sweep(opt Options) (Report, error) {
+	var report Report
+	now := time.Now()
+
+	jobs, err := store.ListJobs()
+	if err != nil {
+		return report, fmt.Errorf("failed to load jobs: %w", err)
+	}
+
+	tracked := make(map[string]bool)
+
+	// only the newest workspace of a deployment can still be built, older ones are left from failed builds
+	newest := make(map[string]int64)
+	for _, job := range jobs {
+		if job.ID > newest[job.DeploymentID] {
+			newest[job.DeploymentID] = job.ID
+		}
+	}
+
+	// 1. workspaces that have a job
+	for _, job := range jobs {
+		tracked[job.Workspace] = true
+		folder := filepath.Join(tracker.WorkspaceDir, job.Workspace)
+		old := now.Sub(time.Unix(job.UpdatedAt, 0)) >= opt.MaxAge
+
+		if _, err := os.Stat(folder); os.IsNotExist(err) {
+			finished := !activeStatuses[job.Stage]
+			if finished && !old {
+				continue // built workspaces are removed right away, keep the job around for a while
+			}
+
+			if !finished {
+				report.MissingFolders++
+				log.Printf("🧹 Job %d (%s) is %s but has no folder", job.ID, job.DeploymentID, job.Stage)
+			}
+			if !opt.DryRun {
+				store.DeleteJob(job.Workspace)
+			}
+			continue
+		}
+
+		if !old {
+			continue
+		}
+
+		stale, reason, err := isStale(job, newest[job.DeploymentID])
+		if err != nil {
+			log.Printf("⚠️ Failed to check deployment %s: %v", job.DeploymentID, err)
+			continue
+		}
+		if !stale {
+			continue
+		}
+
+		size, _ := utils.DirSize(folder)
+		report.Stale++
+		report.ReclaimedBytes += size
+		log.Printf("🧹 Stale workspace %s (%s): %s", job.Workspace, job.DeploymentID, reason)
+
+		if !opt.DryRun {
+			if err := os.RemoveAll(folder); err != nil {
+				log.Printf("⚠️ Failed to remove %s: %v", folder, err)
+				continue
+			}
+			store.DeleteJob(job.Workspace)
+		}
+	}
+
+	// 2. folders without a job
+	dirs, err := os.ReadDir(tracker.WorkspaceDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return report, nil
+		}
+		return report, fmt.Errorf("failed to read %s: %w", tracker.WorkspaceDir, err)
+	}
+
+	for _, dir := range dirs {
+		if tracked[dir.Name()] {
+			continue
+		}
+
+		info, err := dir.Info()
+		if err != nil || now.Sub(info.ModTime()) < opt.MaxAge {
+			continue // could be a clone that is still running
+		}
+
+		folder := filepath.Join(tracker.WorkspaceDir, dir.Name())
+		size, _ := utils.DirSize(folder)
+		report.Orphaned++
+		report.ReclaimedBytes += size
+		log.Printf("🧹 Orphaned workspace %s", dir.Name())
+
+		if !opt.DryRun {
+			if err := os.RemoveAll(folder); err != nil {
+				log.Printf("⚠️ Failed to remove %s: %v", folder, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// isStale decides from the job and the sqlite row whether the workspace is still needed
+func isStale(job store.Job, newest int64) (bool, string, error) {
+	if job.ID < newest {
+		return true, "superseded by a newer workspace", nil
+	}
+
+	if !activeStatuses[job.Stage] {
+		return true, "job is " + job.Stage, nil
+	}
+
+	info, err := store.ReadWorker(job.DeploymentID)
+	if err != nil {
+		return false, "", err
+	}
+
+	if info == nil {
+		return true, "deployment not in database", nil
+	}
+
+	if activeStatuses[info.Status] {
+		return false, "", nil
+	}
+
+	return true, "deployment is " + info.Status, nil
+}
@@ -0,0 +1,129 @@
+// a small Dockerfile parser, just enough for the policy: instructions with the line they start on, continuation lines,
+// comments, parser directives and heredocs. it doesn't try to understand the commands inside RUN.
+
+package policy
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Directive is a parser directive from the top of the Dockerfile, e.g. # syntax=docker/dockerfile:1
+type Directive struct {
+	Line  int
+	Name  string // lower case
+	Value string
+}
+
+// Instruction is one Dockerfile instruction, continuation lines joined
+type Instruction struct {
+	Line    int    // line it starts on, 1 based
+	Command string // upper case, e.g. FROM
+	Args    string // everything after the command
+}
+
+var (
+	escapeDirective = regexp.MustCompile(`(?i)^#\s*escape\s*=\s*([\\` + "`" + `])\s*$`)
+	otherDirective  = regexp.MustCompile(`(?i)^#\s*([a-z]+)\s*=\s*(.*?)\s*$`)
+	heredocStart    = regexp.MustCompile(`<<(-?)["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+)
+
+// Parse splits a Dockerfile into its instructions and parser directives
+func Parse(r io.Reader) ([]Instruction, []Directive, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	escape := `\`
+	directives := true // parser directives are only read before anything else
+	var (
+		instructions []Instruction
+		found        []Directive
+		current      *Instruction
+		lineNumber   int
+	)
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+
+		if directives {
+			if m := escapeDirective.FindStringSubmatch(trimmed); m != nil {
+				escape = m[1]
+				continue
+			}
+			if m := otherDirective.FindStringSubmatch(trimmed); m != nil {
+				found = append(found, Directive{Line: lineNumber, Name: strings.ToLower(m[1]), Value: m[2]})
+			} else {
+				directives = false
+			}
+		}
+
+		// comments are dropped, also between continuation lines
+		if strings.HasPrefix(trimmed, "#") || (trimmed == "" && current == nil) {
+			continue
+		}
+
+		if current == nil {
+			command, args, _ := strings.Cut(trimmed, " ")
+			current = &Instruction{Line: lineNumber, Command: strings.ToUpper(command), Args: strings.TrimSpace(args)}
+		} else {
+			current.Args += " " + trimmed
+		}
+
+		if strings.HasSuffix(current.Args, escape) {
+			current.Args = strings.TrimSpace(strings.TrimSuffix(current.Args, escape))
+			continue
+		}
+
+		// the body of a heredoc belongs to the instruction, it is not parsed as instructions
+		if m := heredocStart.FindStringSubmatch(current.Args); m != nil {
+			for scanner.Scan() {
+				lineNumber++
+				body := scanner.Text()
+				if m[1] == "-" {
+					body = strings.TrimLeft(body, "\t")
+				}
+				if strings.TrimRight(body, "\r") == m[2] {
+					break
+				}
+			}
+		}
+
+		instructions = append(instructions, *current)
+		current = nil
+	}
+
+	if current != nil {
+		instructions = append(instructions, *current)
+	}
+	return instructions, found, scanner.Err()
+}
+
+// mounts returns the values of every --mount flag of a RUN, flags only keeps the last one
+func mounts(args string) []string {
+	var values []string
+	for strings.HasPrefix(args, "--") {
+		flag, rest, _ := strings.Cut(args, " ")
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		if strings.EqualFold(name, "mount") {
+			values = append(values, value)
+		}
+		args = strings.TrimSpace(rest)
+	}
+	return values
+}
+
+// flags splits the leading --name=value flags off the arguments
+func flags(args string) (map[string]string, string) {
+	found := make(map[string]string)
+	for strings.HasPrefix(args, "--") {
+		flag, rest, _ := strings.Cut(args, " ")
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		found[strings.ToLower(name)] = value
+		args = strings.TrimSpace(rest)
+	}
+	return found, args
+}
@@ -0,0 +1,418 @@
+// policy checks a Dockerfile before it is built: where the base images come from (and every other image the build
+// runs, like the frontend of a syntax directive or the image of a RUN --mount), that the image doesn't run as root,
+// that nothing is downloaded with ADD and how many layers the image adds. every violation names the line it is on,
+// so the report can be fixed without building.
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// names of the rules, they are part of every violation
+const (
+	RuleAllowedRegistries = "allowed-registries"
+	RuleNonRootUser       = "non-root-user"
+	RuleNoRemoteAdd       = "no-remote-add"
+	RuleMaxLayers         = "max-layers"
+)
+
+// Policy is what a Dockerfile has to follow, the zero value allows everything
+type Policy struct {
+	AllowedRegistries []string // registries (or registry/namespace prefixes) base images may come from, empty allows all
+	RequireNonRoot    bool     // the built stage has to switch to a USER that is not root
+	NoRemoteAdd       bool     // ADD may not download urls or git repos
+	MaxLayers         int      // layers the built stage may add on top of its base image (0 = unlimited)
+}
+
+// Options are the build options that change what the Dockerfile builds
+type Options struct {
+	BuildArgs map[string]string
+	Target    string // stage that is built, the last one when empty
+}
+
+// Violation is one broken rule
+type Violation struct {
+	File    string // Dockerfile or compose service it is in
+	Line    int    // 0 when it has no line, e.g. the image of a compose service
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s (%s)", v.File, v.Line, v.Message, v.Rule)
+	}
+	return fmt.Sprintf("%s: %s (%s)", v.File, v.Message, v.Rule)
+}
+
+// Error is returned when a Dockerfile breaks the policy, it lists every violation
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, 0, len(e.Violations)+1)
+	lines = append(lines, fmt.Sprintf("Dockerfile policy: %d violation(s)", len(e.Violations)))
+	for _, v := range e.Violations {
+		lines = append(lines, v.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Enabled tells if the policy checks anything at all
+func (p Policy) Enabled() bool {
+	return len(p.AllowedRegistries) > 0 || p.RequireNonRoot || p.NoRemoteAdd || p.MaxLayers > 0
+}
+
+// CheckFile parses the Dockerfile at path and checks it, name is how the file is called in the report
+func (p Policy) CheckFile(path, name string, opt Options) ([]Violation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return p.Check(f, name, opt)
+}
+
+// Check parses a Dockerfile and returns the violations, ordered by line
+func (p Policy) Check(r io.Reader, name string, opt Options) ([]Violation, error) {
+	instructions, directives, err := Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	c := checker{policy: p, file: name}
+	c.stages = splitStages(instructions, opt.BuildArgs)
+
+	// the frontend image parses the Dockerfile and runs on the worker like a base image, the build arg replaces the directive
+	for _, d := range directives {
+		if d.Name == "syntax" {
+			c.checkRegistry(d.Line, d.Value, "syntax directive frontend")
+		}
+	}
+	if frontend, ok := opt.BuildArgs["BUILDKIT_SYNTAX"]; ok {
+		c.checkRegistry(0, frontend, "BUILDKIT_SYNTAX frontend")
+	}
+
+	for i := range c.stages {
+		c.checkStage(i)
+	}
+
+	if target := c.target(opt.Target); target >= 0 {
+		c.checkUser(target)
+		c.checkLayers(target)
+	}
+
+	sort.SliceStable(c.violations, func(i, j int) bool { return c.violations[i].Line < c.violations[j].Line })
+	return c.violations, nil
+}
+
+// CheckImage checks an image reference that is used without a Dockerfile, e.g. the image of a compose service
+func (p Policy) CheckImage(image, name string) []Violation {
+	c := checker{policy: p, file: name}
+	c.checkRegistry(0, image, "image")
+	return c.violations
+}
+
+type stage struct {
+	name         string // lower case alias, empty without AS
+	from         Instruction
+	image        string // base image with the build args filled in
+	parent       int    // index of the stage it is based on, -1 for an image
+	instructions []Instruction
+}
+
+type checker struct {
+	policy     Policy
+	file       string
+	stages     []stage
+	violations []Violation
+}
+
+func (c *checker) add(line int, rule, format string, args ...any) {
+	c.violations = append(c.violations, Violation{File: c.file, Line: line, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// splitStages groups the instructions by FROM. ARGs before the first FROM are the only ones FROM can use,
+// their defaults are overridden by the build args.
+func splitStages(instructions []Instruction, buildArgs map[string]string) []stage {
+	globals := make(map[string]string)
+	var stages []stage
+
+	for _, in := range instructions {
+		if in.Command == "FROM" {
+			_, args := flags(in.Args)
+			fields := strings.Fields(args)
+
+			s := stage{from: in, parent: -1}
+			if len(fields) > 0 {
+				s.image = expand(fields[0], globals)
+			}
+			if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+				s.name = strings.ToLower(fields[2])
+			}
+			s.parent = stageIndex(stages, s.image)
+			stages = append(stages, s)
+			continue
+		}
+
+		if len(stages) == 0 {
+			if in.Command == "ARG" {
+				for _, arg := range strings.Fields(in.Args) {
+					key, value, hasDefault := strings.Cut(arg, "=")
+					if v, ok := buildArgs[key]; ok {
+						value, hasDefault = v, true
+					}
+					if hasDefault { // without a value it stays unknown, the report then shows the variable
+						globals[key] = strings.Trim(value, `"'`)
+					}
+				}
+			}
+			continue
+		}
+		stages[len(stages)-1].instructions = append(stages[len(stages)-1].instructions, in)
+	}
+	return stages
+}
+
+// stageIndex finds an earlier stage by alias or number, -1 when ref is an image
+func stageIndex(stages []stage, ref string) int {
+	if n, err := strconv.Atoi(ref); err == nil && n >= 0 && n < len(stages) {
+		return n
+	}
+	for i, s := range stages {
+		if s.name != "" && s.name == strings.ToLower(ref) {
+			return i
+		}
+	}
+	return -1
+}
+
+// target is the stage that gets built, -1 when the Dockerfile has no stages or the target doesn't exist
+// (the build fails on that by itself)
+func (c *checker) target(name string) int {
+	if name == "" {
+		return len(c.stages) - 1
+	}
+	for i, s := range c.stages {
+		if s.name == strings.ToLower(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkStage runs the rules that apply to every stage, built or not: a stage that is only used to compile still
+// runs its base image and its downloads on the worker
+func (c *checker) checkStage(i int) {
+	s := c.stages[i]
+	if s.parent < 0 {
+		c.checkRegistry(s.from.Line, s.image, "base image")
+	}
+
+	for _, in := range s.instructions {
+		switch in.Command {
+		case "COPY", "ADD":
+			flagValues, _ := flags(in.Args)
+			if from, ok := flagValues["from"]; ok && stageIndex(c.stages[:i], from) < 0 {
+				c.checkRegistry(in.Line, from, "COPY --from image")
+			}
+		case "RUN":
+			for _, mount := range mounts(in.Args) {
+				if from, ok := mountFrom(mount); ok && stageIndex(c.stages[:i], from) < 0 {
+					c.checkRegistry(in.Line, from, "RUN --mount from image")
+				}
+			}
+		}
+		if in.Command == "ADD" && c.policy.NoRemoteAdd {
+			for _, src := range addSources(in.Args) {
+				if isRemote(src) {
+					c.add(in.Line, RuleNoRemoteAdd, "ADD downloads %s, fetch it with RUN and check its checksum, or COPY it from the repo", src)
+				}
+			}
+		}
+	}
+}
+
+// checkRegistry checks where an image comes from
+func (c *checker) checkRegistry(line int, image, what string) {
+	allowed := c.policy.AllowedRegistries
+	if len(allowed) == 0 || strings.EqualFold(image, "scratch") {
+		return
+	}
+
+	if image == "" || strings.Contains(image, "$") {
+		c.add(line, RuleAllowedRegistries, "%s %q can't be resolved, set its build arg or use a fixed image", what, image)
+		return
+	}
+
+	name := normalizeImage(image)
+	for _, prefix := range allowed {
+		prefix = strings.TrimSuffix(strings.ToLower(prefix), "/")
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return
+		}
+	}
+	c.add(line, RuleAllowedRegistries, "%s %s is not from an allowed registry (%s)", what, image, strings.Join(allowed, ", "))
+}
+
+// checkUser follows the built stage back through the stages it is based on to the last USER
+func (c *checker) checkUser(target int) {
+	for i := target; i >= 0; i = c.stages[i].parent {
+		instructions := c.stages[i].instructions
+		for j := len(instructions) - 1; j >= 0; j-- {
+			in := instructions[j]
+			if in.Command != "USER" {
+				continue
+			}
+			if c.policy.RequireNonRoot && isRoot(in.Args) {
+				c.add(in.Line, RuleNonRootUser, "USER %s runs the container as root", in.Args)
+			}
+			return
+		}
+	}
+
+	if c.policy.RequireNonRoot {
+		s := c.stages[target]
+		c.add(s.from.Line, RuleNonRootUser, "stage %s never sets a USER, the image would run as the user of %s, usually root", stageLabel(s, target), s.image)
+	}
+}
+
+// checkLayers counts the instructions that add a layer, in the built stage and the stages it is based on
+func (c *checker) checkLayers(target int) {
+	limit := c.policy.MaxLayers
+	if limit <= 0 {
+		return
+	}
+
+	var chain []int
+	for i := target; i >= 0; i = c.stages[i].parent {
+		chain = append([]int{i}, chain...)
+	}
+
+	count := 0
+	var over *Instruction
+	for _, i := range chain {
+		for _, in := range c.stages[i].instructions {
+			switch in.Command {
+			case "RUN", "COPY", "ADD":
+				count++
+				if count == limit+1 {
+					in := in
+					over = &in
+				}
+			}
+		}
+	}
+
+	if over != nil {
+		c.add(over.Line, RuleMaxLayers, "stage %s adds %d layers, at most %d are allowed, combine RUN steps to stay below", stageLabel(c.stages[target], target), count, limit)
+	}
+}
+
+func stageLabel(s stage, i int) string {
+	if s.name != "" {
+		return s.name
+	}
+	return strconv.Itoa(i)
+}
+
+// mountFrom is the from= of a --mount value (type=cache,target=/x,from=image), the stage or image it mounts from
+func mountFrom(mount string) (string, bool) {
+	for _, option := range strings.Split(strings.Trim(mount, `"'`), ",") {
+		key, value, _ := strings.Cut(option, "=")
+		if strings.EqualFold(strings.TrimSpace(key), "from") {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+// addSources returns the sources of an ADD, both the shell and the json form
+func addSources(args string) []string {
+	_, args = flags(args)
+
+	var parts []string
+	if strings.HasPrefix(args, "[") {
+		if err := json.Unmarshal([]byte(args), &parts); err != nil {
+			parts = strings.Fields(args)
+		}
+	} else {
+		parts = strings.Fields(args)
+	}
+
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts[:len(parts)-1] // the last one is the destination
+}
+
+var remoteSource = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://|git@)`)
+
+func isRemote(src string) bool {
+	return remoteSource.MatchString(src)
+}
+
+// isRoot tells if a USER value is root, user or user:group by name or id. values with variables can't be told
+func isRoot(value string) bool {
+	user, _, _ := strings.Cut(strings.TrimSpace(value), ":")
+	return user == "" || user == "root" || user == "0"
+}
+
+// normalizeImage turns an image reference into registry/path, the way the engine resolves it:
+// nginx is docker.io/library/nginx, ghcr.io/org/app:1 is ghcr.io/org/app
+func normalizeImage(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	image = strings.ToLower(image)
+
+	first, rest, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		if first == "index.docker.io" || first == "registry-1.docker.io" {
+			first = "docker.io"
+		}
+		return first + "/" + rest
+	}
+	if !found {
+		return "docker.io/library/" + image
+	}
+	return "docker.io/" + image
+}
+
+var variable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::([-+])([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// expand fills in $VAR, ${VAR}, ${VAR:-default} and ${VAR:+alt}. unknown variables are left as they are,
+// so the registry check can tell that the image is not known
+func expand(s string, vars map[string]string) string {
+	return variable.ReplaceAllStringFunc(s, func(match string) string {
+		m := variable.FindStringSubmatch(match)
+		name := m[1] + m[4]
+		value, ok := vars[name]
+
+		switch m[2] {
+		case "-":
+			if !ok || value == "" {
+				return m[3]
+			}
+		case "+":
+			if ok && value != "" {
+				return m[3]
+			}
+			return ""
+		}
+		if !ok {
+			return match
+		}
+		return value
+	})
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func check(t *testing.T, p Policy, dockerfile string, opt Options) []Violation {
+	t.Helper()
+	violations, err := p.Check(strings.NewReader(dockerfile), "Dockerfile", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return violations
+}
+
+func TestAllowedRegistries(t *testing.T) {
+	p := Policy{AllowedRegistries: []string{"registry.test", "docker.io/library"}}
+
+	for _, tc := range []struct {
+		name       string
+		dockerfile string
+		buildArgs  map[string]string
+		lines      []int // lines of the violations, 0 for the build args
+	}{
+		{"allowed base", "FROM node:20\nRUN npm ci\n", nil, nil},
+		{"other base", "FROM ghcr.io/evil/node:20\n", nil, []int{1}},
+		{"copy from image", "FROM node:20\nCOPY --from=ghcr.io/evil/tools /bin/x /bin/x\n", nil, []int{2}},
+		{"copy from stage", "FROM node:20 AS build\nFROM registry.test/base\nCOPY --from=build /app /app\n", nil, nil},
+		{"allowed syntax", "# syntax=docker.io/library/frontend:1\nFROM node:20\n", nil, nil},
+		{"other syntax", "# syntax=docker/dockerfile:1.7\nFROM node:20\n", nil, []int{1}},
+		{"syntax after escape", "# escape=`\n#syntax = ghcr.io/evil/frontend\nFROM node:20\n", nil, []int{2}},
+		{"comment is no directive", "FROM node:20\n# syntax=ghcr.io/evil/frontend\n", nil, nil},
+		{"syntax build arg", "FROM node:20\n", map[string]string{"BUILDKIT_SYNTAX": "ghcr.io/evil/frontend"}, []int{0}},
+		{"mount from image", "FROM node:20\nRUN --mount=type=bind,from=ghcr.io/evil/tools,target=/t /t/run\n", nil, []int{2}},
+		{"every mount", "FROM node:20\nRUN --mount=type=cache,target=/root/.npm --mount=from=ghcr.io/evil/tools,target=/t \\\n  npm ci\n", nil, []int{2}},
+		{"mount from stage", "FROM node:20 AS deps\nFROM node:20\nRUN --mount=type=bind,from=deps,target=/deps cp -r /deps .\n", nil, nil},
+		{"mount from variable", "FROM node:20\nRUN --mount=from=$TOOLS,target=/t /t/run\n", nil, []int{2}},
+		{"mount without from", "FROM node:20\nRUN --mount=type=secret,id=npmrc npm ci\n", nil, nil},
+	} {
+		violations := check(t, p, tc.dockerfile, Options{BuildArgs: tc.buildArgs})
+
+		var lines []int
+		for _, v := range violations {
+			if v.Rule != RuleAllowedRegistries {
+				t.Errorf("%s: unexpected rule %s", tc.name, v.Rule)
+			}
+			lines = append(lines, v.Line)
+		}
+		if len(lines) != len(tc.lines) || (len(lines) > 0 && lines[0] != tc.lines[0]) {
+			t.Errorf("%s: violations on lines %v, want %v: %v", tc.name, lines, tc.lines, violations)
+		}
+	}
+}
+
+// without a list of registries any image may be used, the directive included
+func TestRegistriesNotRestricted(t *testing.T) {
+	dockerfile := "# syntax=ghcr.io/any/frontend\nFROM ghcr.io/any/base\nRUN --mount=from=ghcr.io/any/tools,target=/t /t/run\n"
+	if violations := check(t, Policy{}, dockerfile, Options{}); len(violations) != 0 {
+		t.Errorf("got %v", violations)
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	instructions, directives, err := Parse(strings.NewReader("# syntax=docker/dockerfile:1\n# check=skip=all\n\n# syntax=ignored\nFROM scratch\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(directives) != 2 || directives[0] != (Directive{Line: 1, Name: "syntax", Value: "docker/dockerfile:1"}) || directives[1].Name != "check" {
+		t.Errorf("directives %+v", directives)
+	}
+	if len(instructions) != 1 || instructions[0].Line != 5 {
+		t.Errorf("instructions %+v", instructions)
+	}
+}
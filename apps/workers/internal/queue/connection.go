@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/streadway/amqp"
@@ -11,18 +12,115 @@ type DeploymentMessage struct { // this is the message that is recieved from the
 	DeploymentID    string `json:"deploymentId"`
 	Token           string `json:"token"` // optional
 	Repository      string `json:"repository"`
+	SourceType      string `json:"sourceType"` // "git" (default), "archive", "local" or "image"
+	SourcePath      string `json:"sourcePath"` // archive path/url or local folder when SourceType is not git
+	Image           string `json:"image"`      // prebuilt image reference when SourceType is image, e.g. registry:5000/app@sha256:...; for trigger the pushed digest to pull when this worker doesn't have it
 	Branch          string `json:"branch"`
 	DockerfilePath  string `json:"dockerFilePath"`
-	ComposeFilePath string `json:"composeFilePath"`
+	ComposeFilePath string `json:"composeFilePath"`        // relative to the repo root, deploys all of its services as one stack
+	PublicService   string `json:"publicService"`          // service of the compose stack that gets PortNumber, optional with one service
+	StartCommand    string `json:"startCommand"`           // replaces the start command of a generated Dockerfile
+	NoDockerfileGen bool   `json:"noDockerfileGeneration"` // fail instead of generating a Dockerfile when the repo has none
 	ContextDir      string `json:"contextDir"`
 	CreatedAt       string `json:"createdAt"`
 	PortNumber      string `json:"portNumber"` // the port number to which the container is listening at x:3000
 	AutoDeploy      bool
+	WatchPaths      []string          `json:"watchPaths"`    // path globs that trigger a rebuild, defaults to the context dir
+	Force           bool              `json:"force"`         // build even when no watched path changed
+	BuildArgs       map[string]string `json:"buildArgs"`     // --build-arg values, visible in the image history
+	BuildTarget     string            `json:"buildTarget"`   // --target stage of a multi-stage Dockerfile
+	BuildSecrets    map[string]string `json:"buildSecrets"`  // id -> value, mounted with RUN --mount=type=secret,id=<id>
+	CacheMounts     []string          `json:"cacheMounts"`   // package manager caches kept between builds: npm, go, pip
+	BuildID         int64             `json:"buildId"`       // logs message: which build, 0 for the latest
+	Release         int               `json:"release"`       // rollback message: release to go back to, 0 for the one before the current
+	Env             map[string]string `json:"env"`           // update-env message: variables to set in the container
+	Secrets         map[string]string `json:"secrets"`       // update-env message: variables that are stored encrypted
+	UnsetEnv        []string          `json:"unsetEnv"`      // update-env message: variables or secrets to remove
+	Resources       *Resources        `json:"resources"`     // limits of the container, the worker defaults when missing
+	RestartPolicy   string            `json:"restartPolicy"` // no, on-failure (default) or always
+	HealthCheck     *HealthCheck      `json:"healthCheck"`   // running is only reported once it passes
+}
+
+// HealthCheck says how the health of a deployment's container is checked. durations are like 30s or 1m
+type HealthCheck struct {
+	Type               string   `json:"type"`                         // http, tcp or command
+	Path               string   `json:"path,omitempty"`               // http: path on portNumber that answers 2xx or 3xx, / by default
+	Command            []string `json:"command,omitempty"`            // command: run in the container, exit code 0 is healthy
+	StartPeriod        string   `json:"startPeriod,omitempty"`        // failures this long after the start don't count, 30s by default
+	Interval           string   `json:"interval,omitempty"`           // 10s by default
+	Timeout            string   `json:"timeout,omitempty"`            // 3s by default
+	HealthyThreshold   int      `json:"healthyThreshold,omitempty"`   // passes in a row to be healthy, 1 by default
+	UnhealthyThreshold int      `json:"unhealthyThreshold,omitempty"` // failures in a row to be unhealthy, 3 by default
+}
+
+// Resources are the limits of a deployment's container, what is left out takes the worker default
+type Resources struct {
+	CPUs         float64  `json:"cpus,omitempty"`         // e.g. 0.5 for half a cpu
+	CPUShares    int64    `json:"cpuShares,omitempty"`    // relative weight when cpus are contended, 1024 by default
+	MemoryMB     int64    `json:"memoryMb,omitempty"`     // memory limit
+	MemorySwapMB int64    `json:"memorySwapMb,omitempty"` // memory plus swap, no swap when it is left out
+	PidsLimit    int64    `json:"pidsLimit,omitempty"`    // processes and threads
+	Ulimits      []string `json:"ulimits,omitempty"`      // name=soft[:hard], e.g. nofile=1024:4096
+}
+
+// String keeps the token, the secrets and the env values out of logs, the message is printed when it is received
+func (m DeploymentMessage) String() string {
+	redacted := m
+	if redacted.Token != "" {
+		redacted.Token = "[redacted]"
+	}
+	redacted.BuildSecrets = redactValues(m.BuildSecrets)
+	redacted.Env = redactValues(m.Env)
+	redacted.Secrets = redactValues(m.Secrets)
+
+	type plain DeploymentMessage // without the String method, so Sprintf doesn't call it again
+	return fmt.Sprintf("%+v", plain(redacted))
+}
+
+// redactValues keeps the keys and hides the values
+func redactValues(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return values
+	}
+	redacted := make(map[string]string, len(values))
+	for key := range values {
+		redacted[key] = "[redacted]"
+	}
+	return redacted
 }
 
 type Response struct {
-	DeploymentID string `json:"deploymentId"`
-	Status       string
+	DeploymentID  string `json:"deploymentId"`
+	Status        string
+	ErrorCode     string          `json:"errorCode,omitempty"`     // set when status is failed, e.g. CLONE_TIMEOUT
+	Error         string          `json:"error,omitempty"`         // human readable reason for the failure
+	Message       string          `json:"message,omitempty"`       // extra info, e.g. why a build was skipped
+	BuildID       int64           `json:"buildId,omitempty"`       // build the logs belong to
+	Logs          string          `json:"logs,omitempty"`          // build log, answer to a logs message
+	CacheHitRatio float64         `json:"cacheHitRatio,omitempty"` // share of build steps that came from the cache
+	Release       int             `json:"release,omitempty"`       // release that was built or rolled back to
+	Image         string          `json:"image,omitempty"`         // immutable image of that release
+	Digest        string          `json:"digest,omitempty"`        // name@sha256:... the release can be pulled by from the registry
+	Services      []ServiceStatus `json:"services,omitempty"`      // answer to a status message, one entry per container
+	Violations    []Violation     `json:"violations,omitempty"`    // Dockerfile policy violations of a failed build
+	Secrets       []Violation     `json:"secrets,omitempty"`       // possible secrets in the build context, never their values
+	ExitCode      int             `json:"exitCode,omitempty"`      // of a container that stopped on its own
+}
+
+// Violation is a problem found before the build: a broken rule of the Dockerfile policy or a possible secret.
+// line is 0 when it is about a whole file
+type Violation struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ServiceStatus is the state of one container of a deployment, a stack has one per service
+type ServiceStatus struct {
+	Service   string `json:"service,omitempty"` // compose service, empty for single container deployments
+	Container string `json:"container"`
+	State     string `json:"state"` // created, running, exited, ...
 }
 
 var (
@@ -0,0 +1,296 @@
+// deploy from a tarball or zip instead of a git repo. the archive can be a path on the worker host
+// (inside WORKER_LOCAL_SOURCE_ROOTS) or an http(s) url, and is unpacked into a normal workspace.
+
+package repo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"worker/internal/config"
+)
+
+type ArchiveInput struct {
+	Source string // required, path or http(s) url of a .tar, .tar.gz/.tgz or .zip
+}
+
+// FetchArchive downloads (if needed) and unpacks the archive into a new workspace.
+// it returns the workspace folder.
+func FetchArchive(opt ArchiveInput, deploymentId string) (string, error) {
+	fmt.Printf("📦 Fetching archive %s\n", opt.Source)
+
+	name := SourceName(SourceArchive, opt.Source)
+	folder, err := newWorkspace(name)
+	if err != nil {
+		return "", err
+	}
+
+	limit, limitCode, err := checkoutLimit(deploymentId)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if config.Current.CloneTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), config.Current.CloneTimeout)
+	}
+	defer cancel()
+
+	archivePath, cleanup, err := openArchive(ctx, opt.Source, limit, limitCode)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	guard := &sizeGuard{limit: limit, code: limitCode}
+	if err := extractArchive(ctx, archivePath, folder, guard); err != nil {
+		os.RemoveAll(folder) // never leave half extracted archives behind
+		return "", err
+	}
+
+	if err := verifyLinks(folder); err != nil {
+		os.RemoveAll(folder)
+		return "", err
+	}
+
+	fmt.Println("✅ Archive unpacked successfully")
+	return folder, nil
+}
+
+// openArchive returns a local file path for the archive. remote archives are downloaded into tmp/archives
+// and removed again by the returned cleanup func.
+func openArchive(ctx context.Context, source string, limit int64, limitCode string) (string, func(), error) {
+	noop := func() {}
+
+	if !isRemote(source) {
+		p, err := checkHostPath(source)
+		if err != nil {
+			return "", noop, err
+		}
+		return p, noop, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return "", noop, &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("invalid archive url: %w", err)}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", noop, fetchError(ctx, fmt.Errorf("failed to download archive: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", noop, &CloneError{Code: ErrCodeFetchFailed, Err: fmt.Errorf("archive download returned %s", resp.Status)}
+	}
+
+	if err := os.MkdirAll("tmp/archives", 0755); err != nil {
+		return "", noop, fmt.Errorf("failed to create archives directory: %w", err)
+	}
+
+	file, err := os.CreateTemp("tmp/archives", "download-*")
+	if err != nil {
+		return "", noop, fmt.Errorf("failed to create archive file: %w", err)
+	}
+	defer file.Close()
+	cleanup := func() { os.Remove(file.Name()) }
+
+	// the compressed archive is held to the same limit as the unpacked workspace
+	guard := &sizeGuard{limit: limit, code: limitCode}
+	if err := guard.copy(file, resp.Body); err != nil {
+		cleanup()
+		var cloneErr *CloneError
+		if errors.As(err, &cloneErr) {
+			return "", noop, err
+		}
+		return "", noop, fetchError(ctx, fmt.Errorf("failed to download archive: %w", err))
+	}
+
+	return file.Name(), cleanup, nil
+}
+
+// extractArchive sniffs the format from the first bytes and unpacks it into folder
+func extractArchive(ctx context.Context, archivePath, folder string, guard *sizeGuard) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return &CloneError{Code: ErrCodeFetchFailed, Err: fmt.Errorf("failed to open archive: %w", err)}
+	}
+	defer file.Close()
+
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(512)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(ctx, file, info.Size(), folder, guard)
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return &CloneError{Code: ErrCodeFetchFailed, Err: fmt.Errorf("invalid gzip archive: %w", err)}
+		}
+		defer gz.Close()
+		return extractTar(ctx, gz, folder, guard)
+
+	case len(magic) > 262 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+		return extractTar(ctx, reader, folder, guard)
+	}
+
+	return &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("unsupported archive format, expected tar, tar.gz or zip")}
+}
+
+func extractTar(ctx context.Context, r io.Reader, folder string, guard *sizeGuard) error {
+	tr := tar.NewReader(r)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return fetchError(ctx, err)
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &CloneError{Code: ErrCodeFetchFailed, Err: fmt.Errorf("invalid tar archive: %w", err)}
+		}
+
+		target, err := safeJoin(folder, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(folder, target); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, os.FileMode(header.Mode), guard); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := writeLink(folder, target, header.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("hard link %q is not allowed", header.Name)}
+		default:
+			fmt.Printf("⚠️ Skipping %q, unsupported tar entry type %c\n", header.Name, header.Typeflag)
+		}
+	}
+}
+
+func extractZip(ctx context.Context, r io.ReaderAt, size int64, folder string, guard *sizeGuard) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return &CloneError{Code: ErrCodeFetchFailed, Err: fmt.Errorf("invalid zip archive: %w", err)}
+	}
+
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return fetchError(ctx, err)
+		}
+
+		target, err := safeJoin(folder, f.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(folder, target); err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+
+		case mode&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			linkTarget, err := io.ReadAll(io.LimitReader(rc, 4096))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := writeLink(folder, target, string(linkTarget)); err != nil {
+				return err
+			}
+
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = writeFile(target, rc, mode, guard)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+
+		default:
+			fmt.Printf("⚠️ Skipping %q, unsupported zip entry\n", f.Name)
+		}
+	}
+	return nil
+}
+
+// writeFile creates target with the content of r. setuid and friends are dropped from the mode.
+func writeFile(target string, r io.Reader, mode os.FileMode, guard *sizeGuard) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// O_EXCL so an entry can never overwrite a symlink created by an earlier entry
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("failed to create %q: %w", target, err)}
+	}
+	defer out.Close()
+
+	return guard.copy(out, r)
+}
+
+func writeLink(root, target, linkTarget string) error {
+	if err := checkLink(root, target, linkTarget); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(linkTarget, target)
+}
+
+// fetchError turns a context error into the timeout code so the api sees the same code as for git clones
+func fetchError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &CloneError{Code: ErrCodeCloneTimeout, Err: fmt.Errorf("fetching the source did not finish within %s", config.Current.CloneTimeout)}
+	}
+	return &CloneError{Code: ErrCodeFetchFailed, Err: err}
+}
@@ -1,12 +1,12 @@
 package repo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"time"
-	"worker/internal/tracker"
+	"worker/internal/config"
 	"worker/internal/utils"
 )
 
@@ -16,9 +16,10 @@ type CloneRepoInput struct {
 	Token   *string // optional (nil if not provided)
 }
 
-// CloneRepo clones the Git repo into a uniquely named folder under ./repos/
-func CloneRepo(opt CloneRepoInput, deploymentId string) error {
+// CloneRepo clones the Git repo into a uniquely named folder under ./repos/ and returns that folder
+func CloneRepo(opt CloneRepoInput, deploymentId string) (string, error) {
 	// Inject token if present
+	publicURL := opt.RepoURL
 	if opt.Token != nil {
 		opt.RepoURL = utils.InjectTokesInUrl(opt.RepoURL, opt.Token)
 	}
@@ -30,34 +31,81 @@ func CloneRepo(opt CloneRepoInput, deploymentId string) error {
 	repoName := utils.GetRepoName(opt.RepoURL)
 
 	// Create timestamped folder
-	timestamp := time.Now().Unix()
-	var folderName string = fmt.Sprint(repoName, "-", timestamp)
-	folder := filepath.Join("tmp/repos", folderName)
+	folder, err := newWorkspace(repoName)
+	if err != nil {
+		return "", err
+	}
+
+	// work out how many bytes this checkout is allowed to take
+	limit, limitCode, err := checkoutLimit(deploymentId)
+	if err != nil {
+		return "", err
+	}
 
-	// Ensure base repos/ directory exists
-	if err := os.MkdirAll("tmp/repos", 0755); err != nil {
-		return fmt.Errorf("failed to create repos directory: %w", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	if config.Current.CloneTimeout > 0 { // 0 means the clone may take as long as it wants
+		ctx, cancel = context.WithTimeout(context.Background(), config.Current.CloneTimeout)
 	}
+	defer cancel()
 
 	// Prepare clone command
-	cmd := exec.Command("git", "clone", "--branch", opt.Branch, opt.RepoURL, folder)
+	cmd := exec.CommandContext(ctx, "git", "clone", "--branch", opt.Branch, opt.RepoURL, folder)
 	// cmd.Stdout = os.Stdout // Redirect stdout to terminall
 	// cmd.Stderr = os.Stderr // Redirect stderr to terminal
 
 	fmt.Printf("🚀 Cloning into: %s\n", folder)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git clone failed: %w", err)
+	if err := cmd.Start(); err != nil {
+		return "", &CloneError{Code: ErrCodeCloneFailed, Err: fmt.Errorf("git clone failed to start: %w", err)}
+	}
+
+	// watch the folder while git is writing into it and kill the clone as soon as it gets too big
+	tooLarge := make(chan int64, 1)
+	done := make(chan struct{})
+	if limit > 0 {
+		go watchCheckoutSize(folder, limit, cancel, tooLarge, done)
 	}
 
-	// storing the entry in tracker
-	tracker.SaveEntry(tracker.RepoEntry{
-		DeploymentID: deploymentId, // taken from the input
-		Path:         folderName,
-		Repo:         repoName,
-		Status:       "cloned",
-		CreatedAt:    timestamp,
-	})
+	err = cmd.Wait()
+	close(done)
+
+	if err != nil {
+		os.RemoveAll(folder) // never leave half cloned repos behind
+
+		select {
+		case size := <-tooLarge:
+			return "", &CloneError{Code: limitCode, Err: fmt.Errorf("checkout reached %d bytes, limit is %d bytes", size, limit)}
+		default:
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", &CloneError{Code: ErrCodeCloneTimeout, Err: fmt.Errorf("git clone did not finish within %s", config.Current.CloneTimeout)}
+		}
+
+		return "", &CloneError{Code: ErrCodeCloneFailed, Err: fmt.Errorf("git clone failed: %w", err)}
+	}
+
+	// the watcher only samples the folder, so check the final size once more
+	if limit > 0 {
+		size, err := utils.DirSize(folder)
+		if err != nil {
+			os.RemoveAll(folder)
+			return "", &CloneError{Code: ErrCodeCloneFailed, Err: fmt.Errorf("failed to measure checkout: %w", err)}
+		}
+
+		if size > limit {
+			os.RemoveAll(folder)
+			return "", &CloneError{Code: limitCode, Err: fmt.Errorf("checkout is %d bytes, limit is %d bytes", size, limit)}
+		}
+	}
+
+	// the clone keeps its remote in .git/config, without this the token would go wherever the workspace goes
+	if opt.Token != nil {
+		if out, err := exec.Command("git", "-C", folder, "remote", "set-url", "origin", publicURL).CombinedOutput(); err != nil {
+			os.RemoveAll(folder)
+			return "", &CloneError{Code: ErrCodeCloneFailed, Err: fmt.Errorf("failed to remove the token from the remote: %v: %s", err, out)}
+		}
+	}
 
 	fmt.Println("✅ Repository cloned successfully")
-	return nil
-}
\ No newline at end of file
+	return folder, nil
+}
@@ -0,0 +1,50 @@
+// small git helpers used to work out what changed between two deployments of the same repo
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// HeadCommit returns the sha of HEAD in a cloned workspace
+func HeadCommit(folder string) (string, error) {
+	out, err := git(folder, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ChangedFiles lists the files that differ between two commits, relative to the repo root.
+// it fails if from is not in the clone (force push, shallow history), callers should rebuild in that case.
+func ChangedFiles(folder, from, to string) ([]string, error) {
+	// -z keeps paths as they are, without it git quotes unusual names and splitting on spaces breaks the others
+	out, err := git(folder, "diff", "--name-only", "--no-renames", "-z", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, name := range strings.Split(out, "\x00") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+func git(folder string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", folder}, args...)...)
+
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out.String(), nil
+}
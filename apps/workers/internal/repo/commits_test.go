@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-q")
+	write("README.md", "a")
+	run("add", "-A")
+	run("commit", "-q", "-m", "first")
+	from, err := HeadCommit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := []string{"docs/getting started.md", "src/ünïcode.go", "README.md"}
+	for _, name := range changed {
+		write(name, "b")
+	}
+	run("add", "-A")
+	run("commit", "-q", "-m", "second")
+	to, err := HeadCommit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ChangedFiles(dir, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	sort.Strings(changed)
+	if !reflect.DeepEqual(files, changed) {
+		t.Errorf("changed files %q, want %q", files, changed)
+	}
+}
@@ -0,0 +1,28 @@
+package repo
+
+import "fmt"
+
+// error codes sent back to the api when a clone fails
+const (
+	ErrCodeCloneFailed   = "CLONE_FAILED"
+	ErrCodeCloneTimeout  = "CLONE_TIMEOUT"
+	ErrCodeCloneTooLarge = "CLONE_TOO_LARGE"
+	ErrCodeQuotaExceeded = "WORKSPACE_QUOTA_EXCEEDED"
+	ErrCodeSourceInvalid = "SOURCE_INVALID"      // unknown source type or a path outside the allowed roots
+	ErrCodeFetchFailed   = "SOURCE_FETCH_FAILED" // archive could not be downloaded or read
+	ErrCodeUnsafeSource  = "UNSAFE_SOURCE"       // path traversal or symlink pointing out of the workspace
+)
+
+// CloneError is returned by CloneRepo so the handler can tell the api why the clone failed
+type CloneError struct {
+	Code string
+	Err  error
+}
+
+func (e *CloneError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *CloneError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,121 @@
+// deploy straight from a folder on the worker host, for self hosted setups where the code is already there.
+// the folder is copied into a workspace so the build never touches the original files.
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"worker/internal/config"
+)
+
+type LocalDirInput struct {
+	Path string // required, absolute path inside WORKER_LOCAL_SOURCE_ROOTS
+}
+
+// CopyLocalDir copies the folder into a new workspace. it returns the workspace folder.
+func CopyLocalDir(opt LocalDirInput, deploymentId string) (string, error) {
+	fmt.Printf("📁 Copying local folder %s\n", opt.Path)
+
+	source, err := checkHostPath(opt.Path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return "", &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("source path %s is not a folder", opt.Path)}
+	}
+
+	name := SourceName(SourceLocal, source)
+	folder, err := newWorkspace(name)
+	if err != nil {
+		return "", err
+	}
+
+	limit, limitCode, err := checkoutLimit(deploymentId)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if config.Current.CloneTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), config.Current.CloneTimeout)
+	}
+	defer cancel()
+
+	guard := &sizeGuard{limit: limit, code: limitCode}
+	if err := copyDir(ctx, source, folder, guard); err != nil {
+		os.RemoveAll(folder)
+		return "", err
+	}
+
+	if err := verifyLinks(folder); err != nil {
+		os.RemoveAll(folder)
+		return "", err
+	}
+
+	fmt.Println("✅ Local folder copied successfully")
+	return folder, nil
+}
+
+// copyDir copies regular files, folders and symlinks that stay inside the source. anything else is skipped.
+func copyDir(ctx context.Context, source, folder string, guard *sizeGuard) error {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	return filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return &CloneError{Code: ErrCodeFetchFailed, Err: err}
+		}
+		if err := ctx.Err(); err != nil {
+			return fetchError(ctx, err)
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		target, err := safeJoin(folder, rel)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+
+		case d.Type()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return writeLink(folder, target, linkTarget)
+
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			in, err := os.Open(p)
+			if err != nil {
+				return &CloneError{Code: ErrCodeFetchFailed, Err: err}
+			}
+			defer in.Close()
+
+			return writeFile(target, in, info.Mode(), guard)
+		}
+
+		fmt.Printf("⚠️ Skipping %s, not a regular file\n", p)
+		return nil
+	})
+}
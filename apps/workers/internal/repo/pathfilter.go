@@ -0,0 +1,75 @@
+// watched path globs for monorepos. a deployment only rebuilds when a changed file matches one of its globs.
+// globs are slash separated and relative to the repo root, * matches inside one segment and ** matches any number of segments.
+// a glob without wildcards matches that file or everything under that folder.
+
+package repo
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultWatchPaths watches the whole context dir, which is the whole repo when the context is "."
+func DefaultWatchPaths(contextDir string) []string {
+	dir := strings.Trim(path.Clean("/"+strings.TrimPrefix(contextDir, ".")), "/")
+	if dir == "" {
+		return []string{"**"}
+	}
+	return []string{dir}
+}
+
+// MatchAny reports whether any of the files matches any of the globs
+func MatchAny(globs, files []string) bool {
+	for _, file := range files {
+		for _, glob := range globs {
+			if MatchPath(glob, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchPath matches a single repo relative file against a glob
+func MatchPath(glob, file string) bool {
+	glob = strings.Trim(path.Clean("/"+strings.TrimPrefix(glob, "./")), "/")
+	file = strings.Trim(path.Clean("/"+file), "/")
+
+	if glob == "" {
+		return true
+	}
+
+	if !strings.ContainsAny(glob, "*?[") {
+		return file == glob || strings.HasPrefix(file, glob+"/")
+	}
+
+	return matchSegments(strings.Split(glob, "/"), strings.Split(file, "/"))
+}
+
+func matchSegments(glob, file []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			// ** swallows zero or more segments, try every split
+			for i := 0; i <= len(file); i++ {
+				if matchSegments(glob[1:], file[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(file) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(glob[0], file[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		glob, file = glob[1:], file[1:]
+	}
+
+	// a glob that names a folder also matches what is inside it, e.g. services/*
+	return true
+}
@@ -0,0 +1,201 @@
+// helpers shared by the non git sources: checking host paths and keeping extracted files inside the workspace
+
+package repo
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"worker/internal/config"
+)
+
+// source types that can come in DeploymentMessage.SourceType
+const (
+	SourceGit     = "git"     // default, clone Repository
+	SourceArchive = "archive" // tar, tar.gz or zip from a path or an http(s) url
+	SourceLocal   = "local"   // folder on the worker host
+	SourceImage   = "image"   // prebuilt image, nothing to fetch or build
+)
+
+// SourceName returns a short name for the source which is used for the workspace folder and the image name
+func SourceName(sourceType, location string) string {
+	if sourceType == "" || sourceType == SourceGit {
+		return sanitizeName(strings.TrimSuffix(path.Base(location), ".git"))
+	}
+
+	if sourceType == SourceImage {
+		name, _, _ := strings.Cut(location, "@")
+		name = path.Base(name)
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[:i] // drop the tag
+		}
+		return sanitizeName(name)
+	}
+
+	if u, err := url.Parse(location); err == nil && isRemote(location) {
+		location = u.Path
+	}
+
+	name := filepath.Base(filepath.Clean(location))
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return sanitizeName(name)
+}
+
+// sanitizeName keeps the name usable as a folder and a docker image name
+func sanitizeName(name string) string {
+	name = strings.ToLower(name)
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+
+	out := strings.Trim(b.String(), "-._")
+	if out == "" {
+		return "source"
+	}
+	return out
+}
+
+func isRemote(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// checkHostPath makes sure a path on the worker host is inside one of the configured source roots.
+// symlinks are resolved first so a link inside a root cannot point somewhere else.
+func checkHostPath(p string) (string, error) {
+	if len(config.Current.LocalSourceRoots) == 0 {
+		return "", &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("local sources are disabled, set WORKER_LOCAL_SOURCE_ROOTS to allow them")}
+	}
+
+	if !filepath.IsAbs(p) {
+		return "", &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("source path %s must be absolute", p)}
+	}
+
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("failed to resolve source path: %w", err)}
+	}
+
+	for _, root := range config.Current.LocalSourceRoots {
+		resolvedRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+
+		if within(resolvedRoot, resolved) {
+			return resolved, nil
+		}
+	}
+
+	return "", &CloneError{Code: ErrCodeSourceInvalid, Err: fmt.Errorf("source path %s is outside the allowed roots", p)}
+}
+
+// within reports whether target is root itself or somewhere below it
+func within(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel))
+}
+
+// safeJoin joins an entry name from an archive or a folder onto the workspace root and rejects anything
+// that would end up outside of it (absolute names, ../ and friends)
+func safeJoin(root, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("absolute path %q is not allowed", name)}
+	}
+
+	target := filepath.Join(root, name)
+	if !within(root, target) {
+		return "", &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("path %q escapes the workspace", name)}
+	}
+	return target, nil
+}
+
+// checkParents makes sure none of the folders between root and target is a symlink,
+// otherwise writing target would follow the link out of the workspace
+func checkParents(root, target string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil // nothing below this exists yet
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("path %q goes through a symlink", target)}
+		}
+	}
+	return nil
+}
+
+// checkLink allows a symlink only when it is relative and points to something inside the workspace
+func checkLink(root, linkPath, linkTarget string) error {
+	if filepath.IsAbs(linkTarget) {
+		return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("symlink %q points to absolute path %q", linkPath, linkTarget)}
+	}
+
+	resolved := filepath.Join(filepath.Dir(linkPath), linkTarget)
+	if !within(root, resolved) {
+		return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("symlink %q points out of the workspace", linkPath)}
+	}
+	return nil
+}
+
+// verifyLinks walks the finished workspace and resolves every symlink for real. checkLink only looks at the
+// link text, so a chain of links that are fine on their own could still end up outside, this catches that.
+func verifyLinks(root string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		resolved, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			if os.IsNotExist(err) { // dangling link, all we can check is where it would point
+				linkTarget, err := os.Readlink(p)
+				if err != nil {
+					return err
+				}
+				return checkLink(root, p, linkTarget)
+			}
+			return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("failed to resolve symlink %q: %w", p, err)}
+		}
+
+		if !within(resolvedRoot, resolved) {
+			return &CloneError{Code: ErrCodeUnsafeSource, Err: fmt.Errorf("symlink %q resolves out of the workspace", p)}
+		}
+		return nil
+	})
+}
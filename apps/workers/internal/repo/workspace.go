@@ -0,0 +1,150 @@
+// every source (git, archive, local folder) ends up in its own workspace under tmp/repos.
+// this file holds the parts they share: naming the folder and the size limits.
+// the handler records the finished workspace as a job in sqlite (store.CreateJob).
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"worker/internal/config"
+	"worker/internal/store"
+	"worker/internal/tracker"
+	"worker/internal/utils"
+)
+
+const workspaceRoot = tracker.WorkspaceDir
+
+// newWorkspace picks a fresh <name>-<timestamp> folder under tmp/repos. the folder itself is not created.
+func newWorkspace(name string) (string, error) {
+	timestamp := time.Now().Unix()
+	var folderName string = fmt.Sprint(name, "-", timestamp)
+	folder := filepath.Join(workspaceRoot, folderName)
+
+	// two clones of the same repo in the same second would share a folder, and a failed clone removes its folder
+	for i := 1; pathExists(folder); i++ {
+		folderName = fmt.Sprint(name, "-", timestamp, "-", i)
+		folder = filepath.Join(workspaceRoot, folderName)
+	}
+
+	// Ensure base repos/ directory exists
+	if err := os.MkdirAll(workspaceRoot, 0755); err != nil {
+		return "", fmt.Errorf("failed to create repos directory: %w", err)
+	}
+
+	return folder, nil
+}
+
+// checkoutLimit returns the max bytes the next checkout may use and the error code to report when it goes over.
+// it is the smaller one of the per checkout limit and what is left of the deployment's workspace quota.
+func checkoutLimit(deploymentId string) (int64, string, error) {
+	limit := config.Current.MaxCheckoutBytes
+	code := ErrCodeCloneTooLarge
+
+	if config.Current.WorkspaceQuota <= 0 {
+		return limit, code, nil
+	}
+
+	used, err := workspaceUsage(deploymentId)
+	if err != nil {
+		return 0, "", &CloneError{Code: ErrCodeCloneFailed, Err: fmt.Errorf("failed to measure workspace usage: %w", err)}
+	}
+
+	remaining := config.Current.WorkspaceQuota - used
+	if remaining <= 0 {
+		return 0, "", &CloneError{Code: ErrCodeQuotaExceeded, Err: fmt.Errorf("deployment already uses %d bytes, quota is %d bytes", used, config.Current.WorkspaceQuota)}
+	}
+
+	if limit <= 0 || remaining < limit {
+		return remaining, ErrCodeQuotaExceeded, nil
+	}
+	return limit, code, nil
+}
+
+// workspaceUsage adds up the size of every workspace the deployment has jobs for
+func workspaceUsage(deploymentId string) (int64, error) {
+	jobs, err := store.ListJobsForDeployment(deploymentId)
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for _, job := range jobs {
+		size, err := utils.DirSize(filepath.Join(workspaceRoot, job.Workspace))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		used += size
+	}
+	return used, nil
+}
+
+// watchCheckoutSize measures the folder every few seconds and cancels the clone once it crosses the limit
+func watchCheckoutSize(folder string, limit int64, cancel context.CancelFunc, tooLarge chan<- int64, done <-chan struct{}) {
+	ticker := time.NewTicker(config.Current.SizeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			size, err := utils.DirSize(folder)
+			if err != nil {
+				continue // folder might not exist yet
+			}
+
+			if size > limit {
+				fmt.Printf("⚠️ Checkout %s is %d bytes, over the limit of %d bytes. Killing clone\n", folder, size, limit)
+				tooLarge <- size
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// DiscardWorkspace removes a workspace folder and its job, if it has one
+func DiscardWorkspace(folder string) error {
+	if err := store.DeleteJob(filepath.Base(folder)); err != nil {
+		return err
+	}
+	return os.RemoveAll(folder)
+}
+
+// sizeGuard counts the bytes written into a workspace that is filled by the worker itself (archives, local folders)
+type sizeGuard struct {
+	limit   int64 // 0 means unlimited
+	code    string
+	written int64
+}
+
+// copy writes src into dst and stops with a CloneError as soon as the workspace goes over the limit
+func (g *sizeGuard) copy(dst io.Writer, src io.Reader) error {
+	if g.limit <= 0 {
+		n, err := io.Copy(dst, src)
+		g.written += n
+		return err
+	}
+
+	remaining := g.limit - g.written
+	n, err := io.Copy(dst, io.LimitReader(src, remaining+1)) // one extra byte tells us it went over
+	g.written += n
+	if err != nil {
+		return err
+	}
+
+	if g.written > g.limit {
+		return &CloneError{Code: g.code, Err: fmt.Errorf("workspace reached %d bytes, limit is %d bytes", g.written, g.limit)}
+	}
+	return nil
+}
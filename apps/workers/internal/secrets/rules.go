@@ -0,0 +1,129 @@
+// the built-in rules. content rules match one line at a time, file rules match the name of the file.
+// none of them keeps the value it matched, a finding only says what was found where.
+
+package secrets
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type contentRule struct {
+	id          string
+	description string
+	pattern     *regexp.Regexp
+	minEntropy  float64 // the last group of the match has to be at least this random, 0 = no check
+	placeholder bool    // the last group is skipped when it looks like a placeholder or a variable
+}
+
+var contentRules = []contentRule{
+	{id: "private-key", description: "private key", pattern: regexp.MustCompile(`-----BEGIN (RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY( BLOCK)?-----`)},
+	{id: "aws-access-key-id", description: "AWS access key id", pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{id: "aws-secret-access-key", description: "AWS secret access key", pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`), minEntropy: 3.5},
+	{id: "github-token", description: "GitHub token", pattern: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{id: "gitlab-token", description: "GitLab token", pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{id: "slack-token", description: "Slack token", pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{id: "slack-webhook", description: "Slack webhook url", pattern: regexp.MustCompile(`https://hooks\.slack\.com/services/T[A-Za-z0-9_]+/B[A-Za-z0-9_]+/[A-Za-z0-9_]+`)},
+	{id: "stripe-key", description: "Stripe live key", pattern: regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}`)},
+	{id: "google-api-key", description: "Google API key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{id: "npm-token", description: "npm token", pattern: regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b|_authToken\s*=\s*([^\s$]{10,})`)},
+	{id: "sendgrid-key", description: "SendGrid key", pattern: regexp.MustCompile(`\bSG\.[A-Za-z0-9_-]{22}\.[A-Za-z0-9_-]{43}\b`)},
+	{id: "url-credentials", description: "password in a url", pattern: regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^\s:/@"']+:([^\s:/@"']{4,})@`), placeholder: true},
+	{
+		id:          "secret-assignment",
+		description: "high-entropy value assigned to a secret-like name",
+		pattern:     regexp.MustCompile(`(?i)[\w.-]*(secret|token|passw(or)?d|pwd|api[_-]?key|access[_-]?key|private[_-]?key|credential)[\w.-]*["']?\s*[:=]\s*["'` + "`" + `]?([A-Za-z0-9+/=_!@%^&*~-]{12,})`),
+		minEntropy:  3.5,
+		placeholder: true,
+	},
+	{id: "high-entropy-string", description: "high-entropy string", pattern: regexp.MustCompile(`["'` + "`" + `]([A-Za-z0-9+/=_-]{32,})["'` + "`" + `]`), minEntropy: 4.5},
+}
+
+type fileRule struct {
+	id          string
+	description string
+	matches     func(name string) bool // base name of the file
+}
+
+var fileRules = []fileRule{
+	{"env-file", "environment file", isEnvFile},
+	{"ssh-private-key", "ssh private key", oneOf("id_rsa", "id_dsa", "id_ecdsa", "id_ed25519")},
+	{"keystore", "key store", hasExt(".p12", ".pfx", ".jks", ".keystore")},
+	{"credentials-file", "credentials file", oneOf(".netrc", ".git-credentials", ".pgpass", ".htpasswd")},
+}
+
+// examples of env files are meant to be committed
+var envExampleSuffixes = []string{".example", ".sample", ".template", ".dist", ".defaults"}
+
+func isEnvFile(name string) bool {
+	if name != ".env" && !strings.HasPrefix(name, ".env.") && !strings.HasSuffix(name, ".env") {
+		return false
+	}
+	for _, suffix := range envExampleSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func oneOf(names ...string) func(string) bool {
+	return func(name string) bool {
+		for _, n := range names {
+			if name == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func hasExt(exts ...string) func(string) bool {
+	return func(name string) bool {
+		return oneOf(exts...)(strings.ToLower(path.Ext(name)))
+	}
+}
+
+// files that are full of hashes and generated strings, scanning them only gives noise
+var skippedFiles = oneOf("package-lock.json", "yarn.lock", "pnpm-lock.yaml", "go.sum", "poetry.lock", "Pipfile.lock", "Cargo.lock", "composer.lock", "Gemfile.lock")
+
+func skippedFile(name string) bool {
+	return skippedFiles(name) || strings.HasSuffix(name, ".min.js") || strings.HasSuffix(name, ".map")
+}
+
+var placeholderWords = []string{"example", "changeme", "change_me", "xxxx", "****", "your", "dummy", "placeholder", "redacted", "<", "{{", "${", "$("}
+
+// placeholder tells values like ${DB_PASSWORD}, <password> or changeme from real ones
+func placeholder(value string) bool {
+	lower := strings.ToLower(value)
+	if strings.HasPrefix(lower, "$") {
+		return true
+	}
+	for _, word := range placeholderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// entropy is the shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+
+	n := float64(len([]rune(s)))
+	var bits float64
+	for _, c := range counts {
+		p := float64(c) / n
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
@@ -0,0 +1,190 @@
+// secrets scans a build context for credentials before they get baked into an image. only what the build would
+// send is scanned: files excluded by .dockerignore are skipped, the same way the context tar skips them.
+// a line with blacktree:allow-secret on it is never reported, for test fixtures and the like.
+
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"worker/internal/docker"
+)
+
+// AllowMarker on a line keeps it out of the findings
+const AllowMarker = "blacktree:allow-secret"
+
+const (
+	maxFileSize = 2 * 1024 * 1024 // bigger files are assets or data, not config
+	maxFindings = 100             // the report stops here, one leaked file is enough to act on
+)
+
+// Finding is a possible secret. it never holds the value, only where it is and what it looks like
+type Finding struct {
+	File        string // slash separated, relative to the scanned folder
+	Line        int    // 0 for findings about the whole file
+	Rule        string
+	Description string
+}
+
+func (f Finding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s (%s)", f.File, f.Line, f.Description, f.Rule)
+	}
+	return fmt.Sprintf("%s: %s (%s)", f.File, f.Description, f.Rule)
+}
+
+// Report is the result of a scan
+type Report struct {
+	Findings  []Finding
+	Files     int  // files that were read
+	Truncated bool // more than maxFindings were found, the rest is not listed
+}
+
+// Error is returned when the scan blocks the build
+type Error struct {
+	Report Report
+}
+
+func (e *Error) Error() string {
+	lines := []string{fmt.Sprintf("secret scan: %d possible secret(s) in the build context", len(e.Report.Findings))}
+	for _, f := range e.Report.Findings {
+		lines = append(lines, f.String())
+	}
+	if e.Report.Truncated {
+		lines = append(lines, "...")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Scan walks the folder the way the build context is put together and checks every file that would be sent
+func Scan(dir string) (*Report, error) {
+	ignore, err := docker.ReadIgnore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+
+	report := &Report{}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		// .git is never sent to the engine (docker.writeContext) and the clone's remote holds no token
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if ignore.Match(rel) {
+			if d.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil // symlinks are sent as links, what they point at is not in the context
+		}
+
+		if len(report.Findings) >= maxFindings {
+			report.Truncated = true
+			return filepath.SkipAll
+		}
+		return scanFile(p, rel, report)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		if report.Findings[i].File != report.Findings[j].File {
+			return report.Findings[i].File < report.Findings[j].File
+		}
+		return report.Findings[i].Line < report.Findings[j].Line
+	})
+	if len(report.Findings) > maxFindings {
+		report.Findings, report.Truncated = report.Findings[:maxFindings], true
+	}
+	return report, nil
+}
+
+func scanFile(p, rel string, report *Report) error {
+	name := path.Base(rel)
+	for _, rule := range fileRules {
+		if rule.matches(name) {
+			report.Findings = append(report.Findings, Finding{File: rel, Rule: rule.id, Description: rule.description + " in the build context"})
+		}
+	}
+
+	if skippedFile(name) {
+		return nil
+	}
+
+	info, err := os.Stat(p)
+	if err != nil || info.Size() > maxFileSize {
+		return nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// binaries have no lines worth reading
+	head := make([]byte, 8000)
+	n, _ := io.ReadFull(file, head)
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	report.Files++
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxFileSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.Contains(text, AllowMarker) {
+			continue
+		}
+		for _, rule := range contentRules {
+			if rule.matchesLine(text) {
+				report.Findings = append(report.Findings, Finding{File: rel, Line: line, Rule: rule.id, Description: rule.description})
+				break // one finding per line is enough to find it
+			}
+		}
+	}
+	return nil // lines longer than the buffer are minified code, the rest of the file is skipped
+}
+
+func (r contentRule) matchesLine(line string) bool {
+	for _, m := range r.pattern.FindAllStringSubmatch(line, -1) {
+		value := m[len(m)-1]
+		if value == "" {
+			value = m[0]
+		}
+		if r.placeholder && placeholder(value) {
+			continue
+		}
+		if r.minEntropy > 0 && entropy(value) < r.minEntropy {
+			continue
+		}
+		return true
+	}
+	return false
+}
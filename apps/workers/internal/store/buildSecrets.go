@@ -0,0 +1,88 @@
+// build secrets are needed until the build of a deployment is done, and again when a build is recovered after a
+// restart, so they are kept in the worker row: one json object, encrypted like the secrets of the environment.
+
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"worker/internal/vault"
+)
+
+// SealBuildSecrets encrypts the build secrets of a deployment for the buildSecrets column, nothing when there are none
+func SealBuildSecrets(deploymentID string, secrets map[string]string) (sql.NullString, error) {
+	if len(secrets) == 0 {
+		return sql.NullString{Valid: false}, nil
+	}
+
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return sql.NullString{Valid: false}, err
+	}
+	sealed, err := vault.Seal(string(data), buildSecretsContext(deploymentID))
+	if err != nil {
+		return sql.NullString{Valid: false}, fmt.Errorf("failed to encrypt the build secrets: %w", err)
+	}
+	return sql.NullString{String: sealed, Valid: true}, nil
+}
+
+// OpenBuildSecrets decrypts the build secrets of a deployment. rows of older workers still hold plain json.
+func OpenBuildSecrets(w *Worker) (map[string]string, error) {
+	if !w.BuildSecrets.Valid || w.BuildSecrets.String == "" {
+		return nil, nil
+	}
+
+	data := w.BuildSecrets.String
+	if vault.IsSealed(data) {
+		var err error
+		if data, err = vault.Open(data, buildSecretsContext(w.DeploymentID)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt the build secrets: %w", err)
+		}
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal([]byte(data), &secrets); err != nil {
+		return nil, fmt.Errorf("invalid build secrets stored: %w", err) // never the value, it holds the secrets
+	}
+	return secrets, nil
+}
+
+// SealStoredBuildSecrets encrypts the build secrets older workers stored as plain json
+func SealStoredBuildSecrets() error {
+	rows, err := DB.Query(`SELECT deploymentId, buildSecrets FROM worker WHERE buildSecrets IS NOT NULL AND buildSecrets NOT LIKE 'v1:%'`)
+	if err != nil {
+		return err
+	}
+	plain := make(map[string]string)
+	for rows.Next() {
+		var id, secrets string
+		if err := rows.Scan(&id, &secrets); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[id] = secrets
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, secrets := range plain {
+		sealed, err := vault.Seal(secrets, buildSecretsContext(id))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the build secrets of %s: %w", id, err)
+		}
+		if _, err := DB.Exec(`UPDATE worker SET buildSecrets = ? WHERE deploymentId = ?`, sealed, id); err != nil {
+			return err
+		}
+		log.Printf("🔐 Encrypted the stored build secrets of %s", id)
+	}
+	return nil
+}
+
+// buildSecretsContext binds the sealed secrets to their row
+func buildSecretsContext(deploymentID string) string {
+	return "buildSecrets/" + deploymentID
+}
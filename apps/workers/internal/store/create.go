@@ -2,6 +2,8 @@
 
 package store
 
+import "database/sql"
+
 // import (
 // "database/sql"
 
@@ -11,24 +13,58 @@ package store
 
 // )
 
+// execer is satisfied by both *sql.DB and *sql.Tx so the same query can run inside or outside a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 func InsertWorker(w Worker) error {
+	return insertWorker(DB, w)
+}
+
+func insertWorker(ex execer, w Worker) error {
 	// prepare the SQL statement
 	query := `
 INSERT INTO worker (
-	deploymentId, status, composePath, imageName, contextDir, dockerfilePath, containerName, port
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	deploymentId, status, composePath, imageName, contextDir, dockerfilePath, containerName, port, autoDeploy, sourceImage, imageDigest,
+	watchPaths, commitSha, lastDeployedSha, sourceType, repository, branch, sourcePath, buildArgs, buildTarget, buildSecrets,
+	cacheMounts, publicService, startCommand, noDockerfileGeneration, dockerfileTemplate, resources,
+	restartPolicy, healthCheck, runState
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(deploymentId) DO UPDATE SET
 	status = excluded.status,
 	composePath = excluded.composePath,
 	imageName = excluded.imageName,
 	contextDir = excluded.contextDir,
 	dockerfilePath = excluded.dockerfilePath,
-	containerName = excluded.containerName,
+	containerName = COALESCE(excluded.containerName, worker.containerName), -- a rebuild doesn't stop the running container
 	port = excluded.port,
+	autoDeploy = excluded.autoDeploy,
+	sourceImage = excluded.sourceImage,
+	imageDigest = excluded.imageDigest,
+	watchPaths = excluded.watchPaths,
+	commitSha = excluded.commitSha,
+	lastDeployedSha = COALESCE(excluded.lastDeployedSha, worker.lastDeployedSha), -- a new clone must not forget what was deployed
+	sourceType = COALESCE(excluded.sourceType, worker.sourceType),
+	repository = COALESCE(excluded.repository, worker.repository),
+	branch = COALESCE(excluded.branch, worker.branch),
+	sourcePath = COALESCE(excluded.sourcePath, worker.sourcePath),
+	buildArgs = excluded.buildArgs,
+	buildTarget = excluded.buildTarget,
+	buildSecrets = excluded.buildSecrets,
+	cacheMounts = excluded.cacheMounts,
+	publicService = excluded.publicService,
+	startCommand = excluded.startCommand,
+	noDockerfileGeneration = excluded.noDockerfileGeneration,
+	dockerfileTemplate = excluded.dockerfileTemplate,
+	resources = excluded.resources,
+	restartPolicy = excluded.restartPolicy,
+	healthCheck = excluded.healthCheck,
+	runState = COALESCE(excluded.runState, worker.runState), -- the container keeps running through a rebuild
 	updatedAt = CURRENT_TIMESTAMP
 `
 
-	_, err := DB.Exec(query,
+	_, err := ex.Exec(query,
 		w.DeploymentID,
 		w.Status,
 		w.ComposePath,
@@ -37,6 +73,28 @@ ON CONFLICT(deploymentId) DO UPDATE SET
 		w.DockerfilePath,
 		w.ContainerName,
 		w.Port,
+		w.AutoDeploy,
+		w.SourceImage,
+		w.ImageDigest,
+		w.WatchPaths,
+		w.CommitSHA,
+		w.LastDeployedSHA,
+		w.SourceType,
+		w.Repository,
+		w.Branch,
+		w.SourcePath,
+		w.BuildArgs,
+		w.BuildTarget,
+		w.BuildSecrets,
+		w.CacheMounts,
+		w.PublicService,
+		w.StartCommand,
+		w.NoDockerfileGen,
+		w.DockerfileTemplate,
+		w.Resources,
+		w.RestartPolicy,
+		w.HealthCheck,
+		w.RunState,
 	)
 	return err
 
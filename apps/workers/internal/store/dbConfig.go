@@ -14,15 +14,38 @@ import (
 )
 
 type Worker struct {
-	DeploymentID   string
-	Status         string
-	ComposePath    sql.NullString
-	ImageName      sql.NullString
-	ContextDir     sql.NullString
-	DockerfilePath sql.NullString
-	ContainerName  sql.NullString
-	Port           sql.NullInt64 // the port to which the container is listening at x:3000
-	AutoDeploy     bool          // whether this deployment should be auto-redeployed on updates
+	DeploymentID       string
+	Status             string
+	ComposePath        sql.NullString
+	ImageName          sql.NullString
+	ContextDir         sql.NullString
+	DockerfilePath     sql.NullString
+	ContainerName      sql.NullString
+	Port               sql.NullInt64  // the port to which the container is listening at x:3000
+	AutoDeploy         bool           // whether this deployment should be auto-redeployed on updates
+	SourceImage        sql.NullString // image reference for deployments that run a prebuilt image
+	ImageDigest        sql.NullString // repo digest (name@sha256:...) of the image in use, if known
+	WatchPaths         sql.NullString // json array of path globs, empty means the context dir
+	CommitSHA          sql.NullString // commit of the current workspace
+	LastDeployedSHA    sql.NullString // commit of the last successful build, used to skip builds with no relevant changes
+	SourceType         sql.NullString // git, archive, local or image. kept so an interrupted clone can be started again
+	Repository         sql.NullString // git url (without token)
+	Branch             sql.NullString
+	SourcePath         sql.NullString // archive path/url or local folder
+	BuildArgs          sql.NullString // json object of --build-arg values
+	BuildTarget        sql.NullString // stage of a multi-stage Dockerfile to build
+	BuildSecrets       sql.NullString // json object of build secrets, only ever handed to the engine as secret mounts
+	CacheMounts        sql.NullString // json array of package managers whose download cache is kept between builds
+	CurrentRelease     sql.NullInt64  // number of the release in use, only written by the release functions
+	ImageRelease       sql.NullInt64  // release the image name points at, the next trigger starts it. only written by the release functions
+	PublicService      sql.NullString // service of a compose stack that gets the port
+	StartCommand       sql.NullString // start command for a generated Dockerfile
+	NoDockerfileGen    bool           // don't generate a Dockerfile when the repo has none
+	DockerfileTemplate sql.NullString // template the Dockerfile of the last clone was generated from, empty when the repo has one
+	Resources          sql.NullString // json object of container limits, the worker defaults when empty
+	RestartPolicy      sql.NullString // what the supervisor does when the container stops, on-failure when empty
+	HealthCheck        sql.NullString // json object of the health check, none when empty
+	RunState           sql.NullString // starting, running, unhealthy, crashed, ... of the container, see SetRunState
 }
 
 var DB *sql.DB // this is exported gloabally so other files in same package can use it without importing and passing as parameter
@@ -43,10 +66,10 @@ func InitDB(path string) error {
 	createTable := `
 	CREATE TABLE IF NOT EXISTS worker (
 		deploymentId   TEXT PRIMARY KEY NOT NULL,
-		status         TEXT NOT NULL CHECK(status IN ('cloned', 'built', 'failed', 'building', 'stopped')),
+		status         TEXT NOT NULL,
 		createdAt      DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updatedAt      DATETIME DEFAULT CURRENT_TIMESTAMP,
-		composePath    TEXT UNIQUE,
+		composePath    TEXT,
 		imageName      TEXT UNIQUE,
 		contextDir     TEXT,
 		dockerfilePath TEXT,
@@ -64,6 +87,22 @@ func InitDB(path string) error {
 
 	}
 
+	if _, err := DB.Exec(createJobsTable); err != nil {
+		return fmt.Errorf("jobs table creation failed: %w", err)
+	}
+
+	if _, err := DB.Exec(createReleasesTable); err != nil {
+		return fmt.Errorf("releases table creation failed: %w", err)
+	}
+
+	if _, err := DB.Exec(createEnvTable); err != nil {
+		return fmt.Errorf("env table creation failed: %w", err)
+	}
+
+	if err := migrate(); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
 	return nil
 }
 
@@ -14,4 +14,4 @@ func DeleteWorker(deploymentID string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,116 @@
+// environment variables of a deployment, handed to its container when it is started. secrets are stored
+// encrypted and only decrypted by ReadEnv, right before the container is created.
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"worker/internal/vault"
+)
+
+const createEnvTable = `
+	CREATE TABLE IF NOT EXISTS env (
+		deploymentId TEXT NOT NULL,
+		name         TEXT NOT NULL,
+		value        TEXT NOT NULL,
+		secret       INTEGER NOT NULL DEFAULT 0,
+		updatedAt    INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+		PRIMARY KEY (deploymentId, name)
+	);
+`
+
+type EnvVar struct {
+	Name   string
+	Value  string // plain text, secrets are only encrypted in the table
+	Secret bool
+}
+
+// UpdateEnv sets the variables and removes the unset ones in one go. a name keeps only its newest value,
+// a variable that becomes a secret (or stops being one) is replaced.
+func UpdateEnv(deploymentID string, set []EnvVar, unset []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range unset {
+		if _, err := tx.Exec(`DELETE FROM env WHERE deploymentId = ? AND name = ?`, deploymentID, name); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range set {
+		value := v.Value
+		if v.Secret {
+			if value, err = vault.Seal(v.Value, envContext(deploymentID, v.Name)); err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", v.Name, err)
+			}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO env (deploymentId, name, value, secret, updatedAt)
+			VALUES (?, ?, ?, ?, strftime('%s', 'now'))
+			ON CONFLICT(deploymentId, name) DO UPDATE SET
+				value = excluded.value,
+				secret = excluded.secret,
+				updatedAt = excluded.updatedAt
+		`, deploymentID, v.Name, value, v.Secret)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReadEnv returns the variables of a deployment sorted by name, secrets decrypted
+func ReadEnv(deploymentID string) ([]EnvVar, error) {
+	rows, err := DB.Query(`SELECT name, value, secret FROM env WHERE deploymentId = ?`, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vars []EnvVar
+	for rows.Next() {
+		var v EnvVar
+		if err := rows.Scan(&v.Name, &v.Value, &v.Secret); err != nil {
+			return nil, err
+		}
+		if v.Secret {
+			if v.Value, err = vault.Open(v.Value, envContext(deploymentID, v.Name)); err != nil {
+				return nil, fmt.Errorf("secret %s of %s: %w", v.Name, deploymentID, err)
+			}
+		}
+		vars = append(vars, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars, nil
+}
+
+// CountEnv returns how many plain variables and secrets a deployment has, without decrypting anything
+func CountEnv(deploymentID string) (int, int, error) {
+	var plain, secret sql.NullInt64
+	err := DB.QueryRow(`
+		SELECT SUM(secret = 0), SUM(secret = 1) FROM env WHERE deploymentId = ?
+	`, deploymentID).Scan(&plain, &secret)
+	return int(plain.Int64), int(secret.Int64), err
+}
+
+// DeleteEnv removes all variables of a deployment
+func DeleteEnv(deploymentID string) error {
+	_, err := DB.Exec(`DELETE FROM env WHERE deploymentId = ?`, deploymentID)
+	return err
+}
+
+// envContext binds a sealed value to its row
+func envContext(deploymentID, name string) string {
+	return "env/" + deploymentID + "/" + name
+}
@@ -0,0 +1,357 @@
+// the jobs table replaces ./data/repos.json. every workspace under tmp/repos is one job that goes
+// cloned -> building -> built/failed. the job and the worker row are always changed in the same transaction,
+// so a crash can never leave them disagreeing, and the lease says which worker process is building it right now.
+
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// job stages
+const (
+	StageCloned   = "cloned"   // workspace is ready, waiting for a builder
+	StageBuilding = "building" // claimed by a builder, see LeaseOwner
+	StageBuilt    = "built"
+	StageFailed   = "failed"
+)
+
+// ErrLeaseLost is returned when a job is no longer leased to the worker building it, another worker may have taken it over
+var ErrLeaseLost = errors.New("the build lease of the job was lost")
+
+type Job struct {
+	ID             int64
+	DeploymentID   string
+	Workspace      string // folder name inside tmp/repos
+	Repo           string // short name of the source, only for logs
+	Stage          string
+	Attempts       int            // how many times a builder claimed this job
+	LeaseOwner     sql.NullString // worker id holding the lease while building
+	LeaseExpiresAt sql.NullInt64  // unix seconds, after this another builder may take the job over
+	CreatedAt      int64          // unix seconds
+	UpdatedAt      int64          // unix seconds
+	ImageID        sql.NullString // image the build produced
+	CachedSteps    sql.NullInt64  // build steps that came from the cache
+	TotalSteps     sql.NullInt64
+}
+
+const createJobsTable = `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		deploymentId   TEXT NOT NULL,
+		workspace      TEXT NOT NULL UNIQUE,
+		repo           TEXT,
+		stage          TEXT NOT NULL CHECK(stage IN ('cloned', 'building', 'built', 'failed')),
+		attempts       INTEGER NOT NULL DEFAULT 0,
+		leaseOwner     TEXT,
+		leaseExpiresAt INTEGER,
+		createdAt      INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+		updatedAt      INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+	);
+
+	CREATE INDEX IF NOT EXISTS jobs_stage ON jobs (stage);
+	CREATE INDEX IF NOT EXISTS jobs_deployment ON jobs (deploymentId);
+`
+
+const jobColumns = `id, deploymentId, workspace, repo, stage, attempts, leaseOwner, leaseExpiresAt, createdAt, updatedAt,
+	imageId, cachedSteps, totalSteps`
+
+// CreateJob stores a freshly cloned workspace and the deployment's worker row in one transaction and returns the job id
+func CreateJob(job Job, w Worker) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // no-op after commit
+
+	if err := insertWorker(tx, w); err != nil {
+		return 0, fmt.Errorf("failed to write worker row: %w", err)
+	}
+
+	createdAt := job.CreatedAt
+	if createdAt == 0 {
+		createdAt = time.Now().Unix()
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO jobs (deploymentId, workspace, repo, stage, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, job.DeploymentID, job.Workspace, job.Repo, StageCloned, createdAt, createdAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write job: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// ImportJob inserts a job as it is, used for migrating repos.json. workspaces that are already known are ignored.
+func ImportJob(job Job) error {
+	_, err := DB.Exec(`
+		INSERT OR IGNORE INTO jobs (deploymentId, workspace, repo, stage, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, job.DeploymentID, job.Workspace, job.Repo, job.Stage, job.CreatedAt, job.CreatedAt)
+	return err
+}
+
+// ClaimJob takes the oldest job that is waiting to be built (or whose builder lost its lease), moves it to building
+// and leases it to owner. the worker row is set to building in the same transaction. returns nil when there is nothing to do.
+func ClaimJob(owner string, lease time.Duration) (*Job, error) {
+	return claim(owner, lease, `(stage = 'cloned' OR (stage = 'building' AND leaseExpiresAt < ?))`, time.Now().Unix())
+}
+
+// ClaimJobByID claims one specific job, used when the cloner hands a job straight to the scheduler.
+// returns nil when the job was already claimed, superseded or is gone.
+func ClaimJobByID(id int64, owner string, lease time.Duration) (*Job, error) {
+	return claim(owner, lease, `stage = 'cloned' AND id = ?`, id)
+}
+
+func claim(owner string, lease time.Duration, where string, args ...any) (*Job, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+
+	// only the newest workspace of a deployment is worth building, older ones were superseded
+	row := tx.QueryRow(`
+		SELECT `+jobColumns+`
+		FROM jobs j
+		WHERE `+where+`
+		  AND NOT EXISTS (SELECT 1 FROM jobs newer WHERE newer.deploymentId = j.deploymentId AND newer.id > j.id)
+		ORDER BY id
+		LIMIT 1
+	`, args...)
+
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expires := now + int64(lease.Seconds())
+	_, err = tx.Exec(`
+		UPDATE jobs
+		SET stage = 'building', attempts = attempts + 1, leaseOwner = ?, leaseExpiresAt = ?, updatedAt = ?
+		WHERE id = ?
+	`, owner, expires, now, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := updateStatus(tx, job.DeploymentID, "building"); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	job.Stage = StageBuilding
+	job.Attempts++
+	job.LeaseOwner = sql.NullString{String: owner, Valid: true}
+	job.LeaseExpiresAt = sql.NullInt64{Int64: expires, Valid: true}
+	return job, nil
+}
+
+// RenewLease extends the lease of a building job by lease from now, the builder calls it while the build runs.
+// returns ErrLeaseLost when the job is not leased to its owner anymore.
+func RenewLease(job *Job, lease time.Duration) error {
+	res, err := DB.Exec(`
+		UPDATE jobs
+		SET leaseExpiresAt = ?
+		WHERE id = ? AND stage = 'building' AND leaseOwner = ?
+	`, time.Now().Unix()+int64(lease.Seconds()), job.ID, job.LeaseOwner.String)
+	if err != nil {
+		return err
+	}
+	return leaseHeld(res)
+}
+
+// FinishJob moves a building job to built or failed, releases the lease and updates the worker row.
+// on success the built commit is remembered as the last deployed one. returns ErrLeaseLost, and changes nothing,
+// when the job is not leased to its owner anymore.
+func FinishJob(job *Job, stage string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE jobs
+		SET stage = ?, leaseOwner = NULL, leaseExpiresAt = NULL, updatedAt = ?
+		WHERE id = ? AND stage = 'building' AND leaseOwner = ?
+	`, stage, time.Now().Unix(), job.ID, job.LeaseOwner.String)
+	if err != nil {
+		return err
+	}
+	if err := leaseHeld(res); err != nil {
+		return err
+	}
+
+	if err := updateStatus(tx, job.DeploymentID, stage); err != nil {
+		return err
+	}
+
+	if stage == StageBuilt {
+		_, err = tx.Exec(`
+			UPDATE worker
+			SET lastDeployedSha = commitSha
+			WHERE deploymentId = ? AND commitSha IS NOT NULL
+		`, job.DeploymentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ResetJob puts a building job back to cloned and drops its lease, used on startup for builds a restart interrupted.
+// only a job leased to owner or whose lease ran out is reset, another worker may still be building the others.
+// the worker row goes back to cloned with it. returns whether the job was reset.
+func ResetJob(job *Job, owner string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	res, err := tx.Exec(`
+		UPDATE jobs
+		SET stage = 'cloned', leaseOwner = NULL, leaseExpiresAt = NULL, updatedAt = ?
+		WHERE id = ? AND stage = 'building'
+		  AND (leaseOwner IS NULL OR leaseOwner = ? OR leaseExpiresAt IS NULL OR leaseExpiresAt < ?)
+	`, now, job.ID, owner, now)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := updateStatus(tx, job.DeploymentID, StageCloned); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	job.Stage = StageCloned
+	job.LeaseOwner = sql.NullString{}
+	job.LeaseExpiresAt = sql.NullInt64{}
+	return true, nil
+}
+
+// leaseHeld turns an update of a leased job that matched no row into ErrLeaseLost
+func leaseHeld(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// LatestJob returns the newest job of a deployment, nil when it has none
+func LatestJob(deploymentID string) (*Job, error) {
+	row := DB.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE deploymentId = ? ORDER BY id DESC LIMIT 1`, deploymentID)
+
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// SetBuildInfo records what a build produced and how much of it came from the cache
+func SetBuildInfo(jobID int64, imageID string, cachedSteps, totalSteps int) error {
+	_, err := DB.Exec(`
+		UPDATE jobs
+		SET imageId = ?, cachedSteps = ?, totalSteps = ?
+		WHERE id = ?
+	`, imageID, cachedSteps, totalSteps, jobID)
+	return err
+}
+
+// ListJobs returns every job, oldest first
+func ListJobs() ([]Job, error) {
+	return queryJobs(`SELECT ` + jobColumns + ` FROM jobs ORDER BY id`)
+}
+
+// ListJobsForDeployment returns the jobs of one deployment, oldest first
+func ListJobsForDeployment(deploymentID string) ([]Job, error) {
+	return queryJobs(`SELECT `+jobColumns+` FROM jobs WHERE deploymentId = ? ORDER BY id`, deploymentID)
+}
+
+// DeleteJob removes the job of a workspace, the folder itself is left alone
+func DeleteJob(workspace string) error {
+	_, err := DB.Exec(`DELETE FROM jobs WHERE workspace = ?`, workspace)
+	return err
+}
+
+func queryJobs(query string, args ...any) ([]Job, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var repo sql.NullString
+
+	err := row.Scan(
+		&job.ID,
+		&job.DeploymentID,
+		&job.Workspace,
+		&repo,
+		&job.Stage,
+		&job.Attempts,
+		&job.LeaseOwner,
+		&job.LeaseExpiresAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.ImageID,
+		&job.CachedSteps,
+		&job.TotalSteps,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Repo = repo.String
+	return &job, nil
+}
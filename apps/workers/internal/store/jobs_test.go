@@ -0,0 +1,127 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func useTestDB(t *testing.T) {
+	t.Helper()
+	if err := InitDB(filepath.Join(t.TempDir(), "database.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Close()
+		DB = nil
+	})
+}
+
+// claimed creates a job for deploymentID and claims it for owner
+func claimed(t *testing.T, deploymentID, owner string, lease time.Duration) *Job {
+	t.Helper()
+	id, err := CreateJob(Job{DeploymentID: deploymentID, Workspace: deploymentID + "-1"}, Worker{DeploymentID: deploymentID, Status: StageCloned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := ClaimJobByID(id, owner, lease)
+	if err != nil || job == nil {
+		t.Fatalf("claim: %+v (err %v)", job, err)
+	}
+	return job
+}
+
+func TestRenewLease(t *testing.T) {
+	useTestDB(t)
+	job := claimed(t, "a", "worker-1", time.Minute)
+
+	if err := RenewLease(job, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := LatestJob("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.LeaseExpiresAt.Int64 < time.Now().Add(59*time.Minute).Unix() {
+		t.Errorf("lease expires at %d, want an hour from now", latest.LeaseExpiresAt.Int64)
+	}
+
+	// the lease runs out and the sweep of another worker takes the job over
+	if _, err := DB.Exec(`UPDATE jobs SET leaseExpiresAt = ? WHERE id = ?`, time.Now().Unix()-1, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	other, err := ClaimJob("worker-2", time.Minute)
+	if err != nil || other == nil || other.ID != job.ID {
+		t.Fatalf("takeover: %+v (err %v)", other, err)
+	}
+
+	if err := RenewLease(job, time.Hour); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("renewing a lost lease: %v, want ErrLeaseLost", err)
+	}
+	if err := FinishJob(job, StageBuilt); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("finishing with a lost lease: %v, want ErrLeaseLost", err)
+	}
+
+	latest, err = LatestJob("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Stage != StageBuilding || latest.LeaseOwner.String != "worker-2" {
+		t.Errorf("job is %s leased to %q, want building leased to worker-2", latest.Stage, latest.LeaseOwner.String)
+	}
+	if err := FinishJob(other, StageBuilt); err != nil {
+		t.Errorf("finishing with the lease: %v", err)
+	}
+}
+
+func TestResetJob(t *testing.T) {
+	useTestDB(t)
+
+	tests := []struct {
+		name    string
+		owner   string
+		expired bool
+		reset   bool
+	}{
+		{"own lease", "worker-1", false, true},
+		{"lease of a live worker", "worker-2", false, false},
+		{"expired lease of another worker", "worker-2", true, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := string(rune('a' + i))
+			job := claimed(t, id, tt.owner, time.Minute)
+			if tt.expired {
+				if _, err := DB.Exec(`UPDATE jobs SET leaseExpiresAt = ? WHERE id = ?`, time.Now().Unix()-1, job.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reset, err := ResetJob(job, "worker-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reset != tt.reset {
+				t.Errorf("reset = %v, want %v", reset, tt.reset)
+			}
+
+			latest, err := LatestJob(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := ReadWorker(id)
+			if err != nil || w == nil {
+				t.Fatalf("worker row: %v", err)
+			}
+			want := StageBuilding
+			if tt.reset {
+				want = StageCloned
+			}
+			if latest.Stage != want || w.Status != want {
+				t.Errorf("job %s, row %s, want both %s", latest.Stage, w.Status, want)
+			}
+		})
+	}
+}
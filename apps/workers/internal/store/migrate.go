@@ -0,0 +1,173 @@
+// the worker table was created with CREATE TABLE IF NOT EXISTS, so databases from older workers
+// don't get new columns or relaxed constraints. this fixes up whatever is missing when the worker starts.
+
+package store
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+)
+
+type column struct {
+	name       string
+	definition string
+}
+
+// columns added after the first version of the worker table, in the order they were added
+var workerColumns = []column{
+	{"sourceImage", "TEXT"},     // image reference as sent by the api for image deployments
+	{"imageDigest", "TEXT"},     // repo digest of the image that is actually used
+	{"watchPaths", "TEXT"},      // json array of path globs that trigger a rebuild
+	{"commitSha", "TEXT"},       // commit of the workspace that is being built
+	{"lastDeployedSha", "TEXT"}, // commit of the last successful build
+	{"sourceType", "TEXT"},      // where the source comes from, needed to clone again after a restart
+	{"repository", "TEXT"},
+	{"branch", "TEXT"},
+	{"sourcePath", "TEXT"},
+	{"buildArgs", "TEXT"},    // json object
+	{"buildTarget", "TEXT"},  // multi-stage target
+	{"buildSecrets", "TEXT"}, // json object, never logged
+	{"cacheMounts", "TEXT"},  // json array of package managers
+	{"currentRelease", "INTEGER"},
+	{"publicService", "TEXT"}, // compose service that gets the port
+	{"startCommand", "TEXT"},
+	{"noDockerfileGeneration", "INTEGER DEFAULT 0"},
+	{"dockerfileTemplate", "TEXT"}, // go, node, python or static when the Dockerfile was generated
+	{"resources", "TEXT"},          // json object of container limits
+	{"restartPolicy", "TEXT"},      // no, on-failure or always
+	{"healthCheck", "TEXT"},        // json object, running waits for it to pass
+	{"runState", "TEXT"},           // state of the deployment's container, status is the build stage while it rebuilds
+	{"imageRelease", "INTEGER"},    // release the image name points at, ahead of currentRelease until it is triggered
+}
+
+// columns added after the first version of the jobs table
+var addedJobColumns = []column{
+	{"imageId", "TEXT"},        // image the build produced
+	{"cachedSteps", "INTEGER"}, // build steps taken from the cache
+	{"totalSteps", "INTEGER"},
+}
+
+// columns added after the first version of the releases table
+var addedReleaseColumns = []column{
+	{"digest", "TEXT"}, // name@sha256:... in the registry
+}
+
+// the first worker table only allowed five statuses, so running, cloning and everything after failed the update silently
+var statusCheck = regexp.MustCompile(`\s*CHECK\s*\(\s*status\s+IN\s*\([^)]*\)\s*\)`)
+
+// composePath was unique, so two deployments with a docker-compose.yml at the same place couldn't both be stored
+var composePathUnique = regexp.MustCompile(`(?i)(composePath\s+TEXT)\s+UNIQUE`)
+
+func migrate() error {
+	if err := relaxWorkerConstraints(); err != nil {
+		return fmt.Errorf("failed to relax worker constraints: %w", err)
+	}
+
+	if err := addColumns("worker", workerColumns); err != nil {
+		return err
+	}
+	// rows from before runState kept the state of their container in status
+	if _, err := DB.Exec(`
+		UPDATE worker SET runState = status
+		WHERE runState IS NULL AND status IN ('starting', 'running', 'unhealthy')
+	`); err != nil {
+		return fmt.Errorf("failed to fill in runState: %w", err)
+	}
+	// before imageRelease the image name always pointed at the release in use
+	if _, err := DB.Exec(`
+		UPDATE worker SET imageRelease = currentRelease
+		WHERE imageRelease IS NULL AND currentRelease IS NOT NULL
+	`); err != nil {
+		return fmt.Errorf("failed to fill in imageRelease: %w", err)
+	}
+	if err := addColumns("jobs", addedJobColumns); err != nil {
+		return err
+	}
+	return addColumns("releases", addedReleaseColumns)
+}
+
+// addColumns adds the columns the table doesn't have yet
+func addColumns(table string, columns []column) error {
+	existing, err := tableColumns(table)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range columns {
+		if existing[col.name] {
+			continue
+		}
+
+		log.Printf("🛠️ Adding column %s to %s table", col.name, table)
+		if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.name, err)
+		}
+	}
+	return nil
+}
+
+// relaxWorkerConstraints rebuilds the worker table without the CHECK on status and the UNIQUE on composePath.
+// sqlite can't drop a constraint, so the table is copied into a new one created from its own schema minus the constraints.
+func relaxWorkerConstraints() error {
+	var schema string
+	err := DB.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'worker'`).Scan(&schema)
+	if err != nil {
+		return err
+	}
+
+	if !statusCheck.MatchString(schema) && !composePathUnique.MatchString(schema) {
+		return nil
+	}
+
+	log.Println("🛠️ Rebuilding worker table without the status check and the unique compose path")
+
+	newSchema := statusCheck.ReplaceAllString(schema, "")
+	newSchema = composePathUnique.ReplaceAllString(newSchema, "$1")
+	newSchema = regexp.MustCompile(`(?i)^CREATE TABLE\s+(IF NOT EXISTS\s+)?"?worker"?`).ReplaceAllString(newSchema, "CREATE TABLE worker_new")
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		newSchema,
+		`INSERT INTO worker_new SELECT * FROM worker`,
+		`DROP TABLE worker`,
+		`ALTER TABLE worker_new RENAME TO worker`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// tableColumns returns the set of column names of a table
+func tableColumns(table string) (map[string]bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal any
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -1,44 +1,132 @@
 // this is to read a worker from the database
 
-
 package store
 
 import (
 	"database/sql"
 )
 
-func ReadWorker(deploymentID string) (*Worker, error) {
-	query := `
-		SELECT deploymentId, status, composePath, imageName, contextDir, dockerfilePath, containerName, port
+// the order of the fields in scanWorker(...) must match the order of the fields in this SELECT. because order is specified in query
+const selectWorker = `
+		SELECT deploymentId, status, composePath, imageName, contextDir, dockerfilePath, containerName, port, autoDeploy,
+			sourceImage, imageDigest, watchPaths, commitSha, lastDeployedSha,
+			sourceType, repository, branch, sourcePath, buildArgs, buildTarget, buildSecrets,
+			cacheMounts, currentRelease, publicService, startCommand, noDockerfileGeneration, dockerfileTemplate, resources,
+			restartPolicy, healthCheck, runState, imageRelease
 		FROM worker
+`
+
+func ReadWorker(deploymentID string) (*Worker, error) {
+	query := selectWorker + `
 		WHERE deploymentId = ?
 	`
 
 	row := DB.QueryRow(query, deploymentID)
 
-	var w Worker
+	w, err := scanWorker(row)
+
+	if err == sql.ErrNoRows {
+		return nil, nil // not found is not an error
+	}
+
+	if err != nil { // in row.Scan if error occurs, err != nill
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// ListWorkersByStatus returns every deployment that is in one of the given statuses
+func ListWorkersByStatus(statuses ...string) ([]Worker, error) {
+	return listWorkers("status", statuses)
+}
+
+// ListWorkersByRunState returns every deployment whose container is in one of the given states
+func ListWorkersByRunState(states ...string) ([]Worker, error) {
+	return listWorkers("runState", states)
+}
+
+func listWorkers(column string, values []string) ([]Worker, error) {
+	query := selectWorker + `
+		WHERE ` + column + ` IN (` + placeholders(len(values)) + `)
+		ORDER BY createdAt
+	`
+
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	// the order of the fields in the row.Scan(...) must match the order of the fields in the SELECT statement above. because order is specified in query
+	var workers []Worker
+	for rows.Next() {
+		w, err := scanWorker(rows)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, *w)
+	}
+	return workers, rows.Err()
+}
+
+func scanWorker(row scanner) (*Worker, error) {
+	var w Worker
 
 	err := row.Scan( // row.Scan(...) reads values from the SQL row in order, and writes them into the provided memory addresses.
 		&w.DeploymentID, // it wants memory address of the variable to write the value into
-		&w.Status, 
+		&w.Status,
 		&w.ComposePath,
 		&w.ImageName,
 		&w.ContextDir,
 		&w.DockerfilePath,
 		&w.ContainerName,
 		&w.Port,
+		&w.AutoDeploy,
+		&w.SourceImage,
+		&w.ImageDigest,
+		&w.WatchPaths,
+		&w.CommitSHA,
+		&w.LastDeployedSHA,
+		&w.SourceType,
+		&w.Repository,
+		&w.Branch,
+		&w.SourcePath,
+		&w.BuildArgs,
+		&w.BuildTarget,
+		&w.BuildSecrets,
+		&w.CacheMounts,
+		&w.CurrentRelease,
+		&w.PublicService,
+		&w.StartCommand,
+		&w.NoDockerfileGen,
+		&w.DockerfileTemplate,
+		&w.Resources,
+		&w.RestartPolicy,
+		&w.HealthCheck,
+		&w.RunState,
+		&w.ImageRelease,
 	)
-
-	if err == sql.ErrNoRows {
-		return nil, nil // not found is not an error
-	}
-
-	if err != nil { // in row.Scan if error occurs, err != nill 
+	if err != nil {
 		return nil, err
 	}
 
 	return &w, nil
 }
 
+// placeholders returns "?, ?, ?" for n values
+func placeholders(n int) string {
+	if n == 0 {
+		return "NULL"
+	}
+
+	s := "?"
+	for i := 1; i < n; i++ {
+		s += ", ?"
+	}
+	return s
+}
@@ -0,0 +1,206 @@
+// every successful build becomes a release: an image with its own tag that is never overwritten, so a deployment
+// can go back to an earlier one. releases are numbered per deployment, the worker row points at the one in use.
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const createReleasesTable = `
+	CREATE TABLE IF NOT EXISTS releases (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		deploymentId TEXT NOT NULL,
+		number       INTEGER NOT NULL,
+		image        TEXT NOT NULL,
+		imageId      TEXT,
+		commitSha    TEXT,
+		jobId        INTEGER,
+		digest       TEXT,
+		createdAt    INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+		deployedAt   INTEGER,
+		UNIQUE (deploymentId, number)
+	);
+`
+
+type Release struct {
+	ID           int64
+	DeploymentID string
+	Number       int            // counts the releases of one deployment, starting at 1
+	Image        string         // immutable reference, e.g. blacktree/app-1234abcd:3f2a9c1-4
+	ImageID      sql.NullString // sha256 id of the image
+	CommitSHA    sql.NullString // commit it was built from, empty for archives and prebuilt images
+	JobID        sql.NullInt64  // build that produced it, also the id of its build log
+	Digest       sql.NullString // name@sha256:... it can be pulled by, once pushed to the registry or for pulled images
+	CreatedAt    int64          // unix seconds
+	DeployedAt   sql.NullInt64  // unix seconds, the last time it became the release in use
+}
+
+const releaseColumns = `id, deploymentId, number, image, imageId, commitSha, jobId, digest, createdAt, deployedAt`
+
+// CreateRelease stores the next release of a deployment, the number is picked here and tag turns it into the
+// image reference. once its image is tagged SetImageRelease makes it the one the next trigger starts, it only becomes
+// the release in use with DeployRelease, when its container started.
+func CreateRelease(r Release, tag func(number int) string) (*Release, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(number), 0) + 1 FROM releases WHERE deploymentId = ?`, r.DeploymentID).Scan(&r.Number); err != nil {
+		return nil, err
+	}
+
+	r.Image = tag(r.Number)
+	r.CreatedAt = time.Now().Unix()
+
+	res, err := tx.Exec(`
+		INSERT INTO releases (deploymentId, number, image, imageId, commitSha, jobId, digest, createdAt, deployedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.DeploymentID, r.Number, r.Image, r.ImageID, r.CommitSHA, r.JobID, r.Digest, r.CreatedAt, r.DeployedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ReadRelease returns one release of a deployment, nil when it doesn't exist (anymore)
+func ReadRelease(deploymentID string, number int) (*Release, error) {
+	row := DB.QueryRow(`SELECT `+releaseColumns+` FROM releases WHERE deploymentId = ? AND number = ?`, deploymentID, number)
+
+	r, err := scanRelease(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// ListReleases returns the releases of a deployment, newest first
+func ListReleases(deploymentID string) ([]Release, error) {
+	rows, err := DB.Query(`SELECT `+releaseColumns+` FROM releases WHERE deploymentId = ? ORDER BY number DESC`, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var releases []Release
+	for rows.Next() {
+		r, err := scanRelease(rows)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, *r)
+	}
+	return releases, rows.Err()
+}
+
+// SetImageRelease records that the image name points at the release now, the release in use doesn't change
+func SetImageRelease(deploymentID string, number int) error {
+	_, err := DB.Exec(`
+		UPDATE worker
+		SET imageRelease = ?, updatedAt = CURRENT_TIMESTAMP
+		WHERE deploymentId = ?
+	`, number, deploymentID)
+	return err
+}
+
+// DeployRelease makes an existing release the one in use, once its container started after a trigger or a rollback
+func DeployRelease(deploymentID string, number int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE releases SET deployedAt = ? WHERE deploymentId = ? AND number = ?`, time.Now().Unix(), deploymentID, number)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("release %d of %s not found", number, deploymentID)
+	}
+
+	if err := setCurrentRelease(tx, deploymentID, number); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setCurrentRelease points the worker row at the release, the digest of the deployment follows it (none when it was never pushed).
+// the image name points at the release in use as well, a rollback moves it there.
+func setCurrentRelease(ex execer, deploymentID string, number int) error {
+	_, err := ex.Exec(`
+		UPDATE worker
+		SET currentRelease = ?,
+			imageRelease = ?,
+			imageDigest = (SELECT digest FROM releases WHERE deploymentId = ? AND number = ?),
+			updatedAt = CURRENT_TIMESTAMP
+		WHERE deploymentId = ?
+	`, number, number, deploymentID, number, deploymentID)
+	return err
+}
+
+// SetReleaseDigest records where a release was pushed to, and on the worker row when it is the release in use
+func SetReleaseDigest(deploymentID string, number int, digest string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE releases SET digest = ? WHERE deploymentId = ? AND number = ?`, digest, deploymentID, number); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE worker
+		SET imageDigest = ?, updatedAt = CURRENT_TIMESTAMP
+		WHERE deploymentId = ? AND currentRelease = ?
+	`, digest, deploymentID, number); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRelease forgets one release, its image has to be removed by the caller
+func DeleteRelease(deploymentID string, number int) error {
+	_, err := DB.Exec(`DELETE FROM releases WHERE deploymentId = ? AND number = ?`, deploymentID, number)
+	return err
+}
+
+// DeleteReleases forgets every release of a deployment
+func DeleteReleases(deploymentID string) error {
+	_, err := DB.Exec(`DELETE FROM releases WHERE deploymentId = ?`, deploymentID)
+	return err
+}
+
+func scanRelease(row scanner) (*Release, error) {
+	var r Release
+	err := row.Scan(
+		&r.ID,
+		&r.DeploymentID,
+		&r.Number,
+		&r.Image,
+		&r.ImageID,
+		&r.CommitSHA,
+		&r.JobID,
+		&r.Digest,
+		&r.CreatedAt,
+		&r.DeployedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -1,21 +1,56 @@
-// update the field to change the status of a task or compose file location in the db 
+// update the field to change the status of a task in the db
 
 package store
 
-import (
-	"database/sql"
-)
-
+// SetStatus only changes the status and leaves every other column alone
+func SetStatus(deploymentID string, status string) error {
+	return updateStatus(DB, deploymentID, status)
+}
 
-func UpdateWorker(deploymentID string, status string, composePath sql.NullString) error {
-	query := `
+// updateStatus only changes the status, used inside the job transactions
+func updateStatus(ex execer, deploymentID string, status string) error {
+	_, err := ex.Exec(`
 		UPDATE worker
-		SET status = ?, composePath = ?, updatedAt = CURRENT_TIMESTAMP
+		SET status = ?, updatedAt = CURRENT_TIMESTAMP
 		WHERE deploymentId = ?
-	`
+	`, status, deploymentID)
+	return err
+}
 
-	_, err := DB.Exec(query, status, composePath, deploymentID)
+// SetRunState records the state of the deployment's container. it is the status as well, unless the deployment is
+// being cloned or built: the old container keeps running through a rebuild and the pipeline owns the status until then.
+func SetRunState(deploymentID string, state string) error {
+	_, err := DB.Exec(`
+		UPDATE worker
+		SET runState = ?,
+			status = CASE WHEN status IN ('cloning', ?, ?) THEN status ELSE ? END,
+			updatedAt = CURRENT_TIMESTAMP
+		WHERE deploymentId = ?
+	`, state, StageCloned, StageBuilding, state, deploymentID)
 	return err
 }
 
+// SetContainer records the container that runs the deployment, an empty id clears it
+func SetContainer(deploymentID string, id string) error {
+	var container any
+	if id != "" {
+		container = id
+	}
+	_, err := DB.Exec(`
+		UPDATE worker
+		SET containerName = ?, updatedAt = CURRENT_TIMESTAMP
+		WHERE deploymentId = ?
+	`, container, deploymentID)
+	return err
+}
 
+// SetImage points the deployment at an image that was pulled instead of built here. it is none of this worker's
+// releases, so the current release is cleared.
+func SetImage(deploymentID string, imageName string, digest string) error {
+	_, err := DB.Exec(`
+		UPDATE worker
+		SET imageName = ?, imageDigest = ?, currentRelease = NULL, imageRelease = NULL, updatedAt = CURRENT_TIMESTAMP
+		WHERE deploymentId = ?
+	`, imageName, digest, deploymentID)
+	return err
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DirSize returns the total size in bytes of all regular files under path.
+// files that disappear while walking (git moves things around while cloning) are skipped.
+func DirSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
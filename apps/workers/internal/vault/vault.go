@@ -0,0 +1,136 @@
+// secrets of deployments are stored encrypted with AES-256-GCM. the key is WORKER_SECRETS_KEY (32 bytes, base64)
+// or, when that is not set, a key generated on first start and kept in data/secrets.key. without the key the
+// stored secrets can't be read, so the file has to be backed up along with the database.
+
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"worker/internal/config"
+)
+
+// KeyFile holds the generated key when WORKER_SECRETS_KEY is not set
+const KeyFile = "data/secrets.key"
+
+// prefix of every sealed value, a new key or cipher would get a new version
+const version = "v1:"
+
+var (
+	once    sync.Once
+	aead    cipher.AEAD
+	initErr error
+)
+
+// Init loads the key, creating the key file if needed. Seal and Open call it as well, calling it at startup
+// only makes a bad key fail the worker right away instead of the first secret.
+func Init() error {
+	once.Do(func() {
+		key, err := loadKey()
+		if err != nil {
+			initErr = err
+			return
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			initErr = err
+			return
+		}
+		aead, initErr = cipher.NewGCM(block)
+	})
+	return initErr
+}
+
+// Seal encrypts the value. context is bound to the result (e.g. the deployment and name of the secret),
+// Open fails with any other context, so a sealed value can't be copied to another row.
+func Seal(value string, context string) (string, error) {
+	if err := Init(); err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(context))
+	return version + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// IsSealed tells if the value came from Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, version)
+}
+
+// Open decrypts a value from Seal with the same context
+func Open(sealed string, context string) (string, error) {
+	if err := Init(); err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(sealed, version) {
+		return "", errors.New("unknown secret format")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, version))
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("malformed secret")
+	}
+
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", errors.New("secret can't be decrypted, the key changed or the value was tampered with")
+	}
+	return string(value), nil
+}
+
+func loadKey() ([]byte, error) {
+	if encoded := config.Current.SecretsKey; encoded != "" {
+		return decodeKey(encoded, "WORKER_SECRETS_KEY")
+	}
+
+	data, err := os.ReadFile(KeyFile)
+	if err == nil {
+		return decodeKey(string(data), KeyFile)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", KeyFile, err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(KeyFile), 0750); err != nil {
+		return nil, err
+	}
+	// O_EXCL so two workers starting in the same folder can't both write a key
+	file, err := os.OpenFile(KeyFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", KeyFile, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", KeyFile, err)
+	}
+
+	log.Printf("🔑 Generated the secrets key in %s, back it up with the database or set WORKER_SECRETS_KEY", KeyFile)
+	return key, nil
+}
+
+func decodeKey(encoded string, source string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes, base64 encoded (openssl rand -base64 32)", source)
+	}
+	return key, nil
+}
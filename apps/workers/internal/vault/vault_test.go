@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"worker/internal/config"
+)
+
+// reset forgets the loaded key and makes key the WORKER_SECRETS_KEY of the test, an empty key uses the key file
+func reset(t *testing.T, key string) {
+	t.Helper()
+	previous := config.Current
+	config.Current.SecretsKey = key
+	once, aead, initErr = sync.Once{}, nil, nil
+	t.Cleanup(func() {
+		config.Current = previous
+		once, aead, initErr = sync.Once{}, nil, nil
+	})
+}
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestSealOpen(t *testing.T) {
+	reset(t, newKey(t))
+
+	sealed, err := Seal("s3cret", "dep-1/NPM_TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "s3cret") {
+		t.Fatalf("sealed value %q", sealed)
+	}
+	if value, err := Open(sealed, "dep-1/NPM_TOKEN"); err != nil || value != "s3cret" {
+		t.Errorf("opened %q, %v", value, err)
+	}
+	if _, err := Open(sealed, "dep-2/NPM_TOKEN"); err == nil {
+		t.Error("a value sealed for another row was opened")
+	}
+}
+
+func TestBadKey(t *testing.T) {
+	reset(t, "c2hvcnQ=")
+	if err := Init(); err == nil || !strings.Contains(err.Error(), "WORKER_SECRETS_KEY") {
+		t.Errorf("expected a key error, got %v", err)
+	}
+}
+
+// without WORKER_SECRETS_KEY the key is generated once and read back on the next start
+func TestGeneratedKeyFile(t *testing.T) {
+	reset(t, "")
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	sealed, err := Seal("s3cret", "dep-1/NPM_TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v", info.Mode().Perm())
+	}
+
+	once, aead, initErr = sync.Once{}, nil, nil
+	if value, err := Open(sealed, "dep-1/NPM_TOKEN"); err != nil || value != "s3cret" {
+		t.Errorf("opened %q with the stored key, %v", value, err)
+	}
+}